@@ -21,12 +21,22 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
-	"slices"
+	"regexp"
+	"strings"
+	"syscall"
 	"time"
 
-	slogmulti "github.com/samber/slog-multi"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 
@@ -34,8 +44,9 @@ import (
 )
 
 const (
-	defaultSyncPeriod        = 30 * time.Second
-	defaultReloaderRunPeriod = 60 * time.Second
+	defaultSyncPeriod          = 30 * time.Second
+	defaultReloaderRunPeriod   = 60 * time.Second
+	defaultStoreEvictionPeriod = 10 * time.Minute
 )
 
 func main() {
@@ -44,14 +55,134 @@ func main() {
 		"Determines the minimum frequency at which watched resources are reconciled")
 	reloaderRunPeriod := flag.Duration("reloader-run-period", defaultReloaderRunPeriod,
 		"Determines the minimum frequency at which watched resources are reloaded")
+	reloaderRunJitter := flag.Duration("reloader-run-jitter", 0,
+		"Maximum random duration added to the reloader run period, to spread requests to Vault")
+	vaultUnavailableBackoff := flag.Duration("vault-unavailable-backoff", 10*time.Second,
+		"Time waited before retrying a reloader run Vault was unavailable in, doubled on each consecutive one, 0 waits for the next run")
+	namespaceReloaderRunPeriods := flag.String("namespace-reloader-run-periods", "",
+		"Comma separated list of namespace=period pairs overriding the reloader run period for the workloads of the namespaces")
+	secretPathsAnnotation := flag.String("secret-paths-annotation", reloader.VaultEnvSecretPathsAnnotation,
+		"Pod template annotation listing comma separated Vault secret paths")
+	extraSecretPathsAnnotations := flag.String("extra-secret-paths-annotations", "",
+		"Comma separated list of other pod template annotations listing comma separated Vault secret paths")
+	secretDelimiter := flag.String("secret-delimiter", "#",
+		"Delimiter of the path, key and version of Vault references, as configured in the webhook")
+	versionSeparators := flag.String("version-separators", "",
+		"Comma separated list of separators of the version pinning Vault references, like the secret delimiter and @ if empty")
+	nonSecretVaultPrefixes := flag.String("non-secret-vault-prefixes", "",
+		"Comma separated list of beginnings of Vault references that are not secret paths, like login and v1: if empty")
+	reloadByDefault := flag.Bool("reload-by-default", false,
+		"Reload every workload using Vault secrets, not only the ones opted in via annotation")
+	cronJobReloadStrategy := flag.String("cronjob-reload-strategy", string(reloader.CronJobReloadNone),
+		"Determines how CronJobs are reloaded (none, next-schedule)")
+	storeConfigMap := flag.String("store-configmap", "",
+		"Name of the ConfigMap the collected data is persisted to, persisting is disabled if empty")
+	storeNamespace := flag.String("store-namespace", os.Getenv("POD_NAMESPACE"),
+		"Namespace of the ConfigMap the collected data is persisted to")
+	storeFlushPeriod := flag.Duration("store-flush-period", reloader.DefaultStoreFlushPeriod,
+		"Determines the frequency at which the collected data is persisted")
+	storeEvictionPeriod := flag.Duration("store-eviction-period", defaultStoreEvictionPeriod,
+		"Time interval for evicting collected workloads that do not exist anymore, disabled if 0")
+	startupSyncAttempts := flag.Int("startup-sync-attempts", 5,
+		"Number of times the store ConfigMap is read and the informer caches are waited for on startup")
+	startupSyncTimeout := flag.Duration("startup-sync-timeout", 30*time.Second,
+		"Time each startup sync attempt is given, doubled on each retry, the caches are waited for without timeout if 0")
+	includeNamespaces := flag.String("include-namespaces", "",
+		"Comma separated list of namespaces to collect workloads from, all namespaces if empty")
+	excludeNamespaces := flag.String("exclude-namespaces", "",
+		"Comma separated list of namespaces to never collect workloads from, takes precedence over -include-namespaces")
+	enabledWorkloadKinds := flag.String("enabled-workload-kinds", "",
+		"Comma separated list of workload kinds to collect and reload (Deployment, DaemonSet, StatefulSet, ReplicaSet, CronJob, Job, Rollout, Pod, Secrets), all kinds if empty")
+	preReloadHookURL := flag.String("pre-reload-hook-url", "",
+		"URL a JSON description of every reload is POSTed to before reloading the workload")
+	postReloadHookURL := flag.String("post-reload-hook-url", "",
+		"URL a JSON description of every reload and its outcome is POSTed to after reloading the workload")
+	blockOnPreReloadHookFailure := flag.Bool("block-on-pre-reload-hook-failure", false,
+		"Abort the reload if the pre-reload hook fails or responds with a non-2xx status")
+	eventSinkURL := flag.String("event-sink-url", "",
+		"URL a JSON event describing every reload decision is POSTed to, e.g. to forward it to a message broker")
+	enableArgoRollouts := flag.Bool("enable-argo-rollouts", false,
+		"Collect and reload Argo Rollouts, requires their CRD to be installed")
+	enablePods := flag.Bool("enable-pods", false,
+		"Collect Pods not controlled by a collected workload, and reload the ones with another controller by deleting them")
+	allowedMounts := flag.String("allowed-mounts", "",
+		"Comma separated list of Vault mounts whose secret paths are collected, all of them if empty")
+	allowedVaultAddrs := flag.String("allowed-vault-addrs", "",
+		"Comma separated list of addresses of the Vault servers other than VAULT_ADDR the workloads may set with the vault-addr annotation")
+	excludeSecretPaths := flag.String("exclude-secret-paths", "",
+		"Comma separated list of Vault secret paths that never drive reloads")
+	excludeSecretPathRegexps := flag.String("exclude-secret-path-regexps", "",
+		"Comma separated list of regular expressions, Vault secret paths fully matching one of them never drive reloads")
+	pathVariables := flag.String("path-variables", "",
+		"Comma separated list of NAME=value pairs replacing the ${NAME} placeholders of Vault secret paths")
+	pathVariablesFromEnv := flag.Bool("path-variables-from-env", false,
+		"Replace the ${NAME} placeholders of Vault secret paths not set in -path-variables with environment variables")
+	workloadLabelSelector := flag.String("workload-label-selector", "",
+		"Label selector limiting collection to matching workloads, e.g. team=payments")
+	maxPathsPerAnnotation := flag.Int("max-paths-per-annotation", 0,
+		"Maximum number of Vault secret paths collected from a secret paths annotation, unlimited if 0")
+	maxPathsPerWorkload := flag.Int("max-paths-per-workload", 0,
+		"Maximum number of Vault secret paths tracked for a workload, unlimited if 0")
+	excludeAnnotations := flag.String("exclude-annotations", "",
+		"Comma separated list of annotation keys excluding the workloads having one of them from collection, e.g. reloader.stakater.com/auto")
+	enableDebugEndpoints := flag.Bool("enable-debug-endpoints", false,
+		"Expose the collected data on read-only /debug HTTP endpoints")
+	enableLogLevelEndpoint := flag.Bool("enable-log-level-endpoint", false,
+		"Expose the /debug/loglevel HTTP endpoint reading the log level on GET and changing it live on PUT")
+	reloadStrategy := flag.String("reload-strategy", string(reloader.ReloadRolloutRestart),
+		"Determines how workloads are reloaded (RolloutRestart, DeletePods)")
+	changeDetection := flag.String("change-detection", string(reloader.ChangeDetectionVersion),
+		"Determines how changes of KV version 2 secrets are detected (version, content-hash)")
+	reloadMetadataKey := flag.String("reload-metadata-key", "",
+		"Custom metadata key of KV version 2 secrets whose value changing reloads the workloads like a new version does")
+	dryRun := flag.Bool("dry-run", false, "Only log the workloads that would be reloaded without updating them")
+	collectOnly := flag.Bool("collect-only", false, "Only collect the secrets of the workloads without connecting to Vault or reloading them")
+	missingSecretPolicy := flag.String("missing-secret-policy", "",
+		"Determines what happens to secrets not found in Vault (ignore, warn, untrack), logged as errors if empty")
+	reloadCooldown := flag.Duration("reload-cooldown", 0,
+		"Minimum time between two reloads of the same workload, reloads within it are deferred")
+	initialGracePeriod := flag.Duration("initial-grace-period", 0,
+		"Time after a workload is created during which it is not reloaded, the versions of its secrets are only recorded")
+	versionCacheTTL := flag.Duration("version-cache-ttl", 0,
+		"Time the versions of the secrets looked up in Vault are cached for, nothing is cached if 0")
+	versionCacheSize := flag.Int("version-cache-size", 1000,
+		"Maximum number of secret versions cached, the least recently used ones are evicted first")
+	reloadMaxAttempts := flag.Int("reload-max-attempts", 3,
+		"Number of times a reload failing with a transient API error is attempted")
+	reloadRetryBackoff := flag.Duration("reload-retry-backoff", 500*time.Millisecond,
+		"Time to wait before retrying a failed reload, doubled on each retry")
+	maxConcurrentReloads := flag.Int("max-concurrent-reloads", 5,
+		"Maximum number of workloads reloaded at the same time, the other ones are queued")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 25*time.Second,
+		"Time given to the reload in progress to finish and to the store to be flushed on shutdown")
+	patchStrategy := flag.String("patch-strategy", string(reloader.PatchServerSideApply),
+		"Type of the patches the reloads are recorded in the workloads with (server-side-apply, strategic-merge, json-merge), Argo Rollouts get a JSON merge patch instead of a strategic merge one")
+	fieldManager := flag.String("field-manager", "vault-secrets-reloader",
+		"Field manager the reload annotations are applied to the workloads with")
+	restartAnnotation := flag.String("restart-annotation", reloader.ReloadCountAnnotationName,
+		"Pod template annotation holding the reload count, bumped to restart the pods of the workloads")
+	leaderElect := flag.Bool("leader-elect", false,
+		"Elect a leader among the replicas, so that only one of them reloads workloads")
+	leaderElectionLease := flag.String("leader-election-lease", "vault-secrets-reloader-leader",
+		"Name of the Lease used for leader election")
+	leaderElectionNamespace := flag.String("leader-election-namespace", os.Getenv("POD_NAMESPACE"),
+		"Namespace of the Lease used for leader election")
+	enableTracing := flag.Bool("enable-tracing", false,
+		"Export OpenTelemetry traces of the reconcile cycles over OTLP HTTP")
+	otlpEndpoint := flag.String("otlp-endpoint", "",
+		"host:port of the OTLP HTTP collector traces are exported to, the OTEL_EXPORTER_OTLP_* variables are used if empty")
+	otlpInsecure := flag.Bool("otlp-insecure", false,
+		"Export traces to the OTLP collector without TLS")
 	logLevel := flag.String("log-level", "info", "Log level (debug, info, warn, error).")
-	enableJSONLog := flag.Bool("enable-json-log", false, "Enable JSON logging")
+	logFormat := flag.String("log-format", string(reloader.LogFormatText), "Log format (text, json).")
+	enableJSONLog := flag.Bool("enable-json-log", false, "Enable JSON logging, same as -log-format json")
 	flag.Parse()
 
 	// Set up signals so we handle the shutdown signal gracefully
 	ctx := signals.SetupSignalHandler()
 
 	// Setup logger
+	logLevelVar := new(slog.LevelVar)
 	var logger *slog.Logger
 	{
 		var level slog.Level
@@ -60,62 +191,28 @@ func main() {
 		if err != nil { // Silently fall back to info level
 			level = slog.LevelInfo
 		}
+		logLevelVar.Set(level)
 
-		levelFilter := func(levels ...slog.Level) func(ctx context.Context, r slog.Record) bool {
-			return func(ctx context.Context, r slog.Record) bool {
-				return slices.Contains(levels, r.Level)
-			}
+		format := reloader.LogFormat(*logFormat)
+		if *enableJSONLog {
+			format = reloader.LogFormatJSON
 		}
 
-		router := slogmulti.Router()
-
-		if *enableJSONLog {
-			// Send logs with level higher than warning to stderr
-			router = router.Add(
-				slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}),
-				levelFilter(slog.LevelWarn, slog.LevelError),
-			)
-
-			// Send info and debug logs to stdout
-			router = router.Add(
-				slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}),
-				levelFilter(slog.LevelDebug, slog.LevelInfo),
-			)
-		} else {
-			// Send logs with level higher than warning to stderr
-			router = router.Add(
-				slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}),
-				levelFilter(slog.LevelWarn, slog.LevelError),
-			)
-
-			// Send info and debug logs to stdout
-			router = router.Add(
-				slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: level}),
-				levelFilter(slog.LevelDebug, slog.LevelInfo),
-			)
+		handler, err := reloader.NewLogHandler(reloader.LoggingConfig{
+			LogFormat: format,
+			LogLevel:  logLevelVar,
+		}, os.Stdout, os.Stderr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Errorf("error building log handler: %w", err).Error())
+			os.Exit(1)
 		}
 
-		// TODO: add level filter handler
-		logger = slog.New(router.Handler())
+		logger = slog.New(handler)
 		logger = logger.With(slog.String("app", "vault-secrets-reloader"))
 
 		slog.SetDefault(logger)
 	}
 
-	// Handler for health checks
-	port := os.Getenv("LISTEN_ADDRESS")
-	if port == "" {
-		port = ":8080"
-	}
-
-	go func() {
-		_ = http.ListenAndServe(port, http.HandlerFunc(
-			func(w http.ResponseWriter, r *http.Request) {
-				_, _ = w.Write([]byte("ok"))
-			},
-		))
-	}()
-
 	// Create kubernetes client
 	kubeConfig, err := config.GetConfig()
 	if err != nil {
@@ -129,21 +226,235 @@ func main() {
 		os.Exit(1)
 	}
 
+	var labelSelector labels.Selector
+	if *workloadLabelSelector != "" {
+		labelSelector, err = labels.Parse(*workloadLabelSelector)
+		if err != nil {
+			logger.Error(fmt.Errorf("error parsing workload label selector: %s", err).Error())
+			os.Exit(1)
+		}
+	}
+
+	var secretPathRegexps []*regexp.Regexp
+	for _, expr := range splitList(*excludeSecretPathRegexps) {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			logger.Error(fmt.Errorf("error parsing excluded secret path regexp: %s", err).Error())
+			os.Exit(1)
+		}
+		secretPathRegexps = append(secretPathRegexps, re)
+	}
+
+	secretPathVariables := make(map[string]string)
+	for _, variable := range splitList(*pathVariables) {
+		name, value, ok := strings.Cut(variable, "=")
+		if !ok || name == "" {
+			logger.Error(fmt.Sprintf("error parsing secret path variable %q, expected NAME=value", variable))
+			os.Exit(1)
+		}
+		secretPathVariables[name] = value
+	}
+
+	namespaceReconcileIntervals := make(map[string]time.Duration)
+	for _, namespacePeriod := range splitList(*namespaceReloaderRunPeriods) {
+		namespace, period, _ := strings.Cut(namespacePeriod, "=")
+		interval, err := time.ParseDuration(period)
+		if namespace == "" || err != nil || interval <= 0 {
+			logger.Error(fmt.Sprintf("error parsing namespace reloader run period %q, expected namespace=period", namespacePeriod))
+			os.Exit(1)
+		}
+		namespaceReconcileIntervals[namespace] = interval
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Error(fmt.Errorf("error getting hostname: %s", err).Error())
+		os.Exit(1)
+	}
+
+	// Trace the reconcile cycles
+	if *enableTracing {
+		tracerProvider, err := reloader.NewTracerProvider(ctx, reloader.TracingConfig{
+			OTLPEndpoint: *otlpEndpoint,
+			OTLPInsecure: *otlpInsecure,
+		})
+		if err != nil {
+			logger.Error(fmt.Errorf("error creating tracer provider: %s", err).Error())
+			os.Exit(1)
+		}
+		defer func() {
+			_ = tracerProvider.Shutdown(context.Background())
+		}()
+		otel.SetTracerProvider(tracerProvider)
+	}
+
+	// Record events on the reloaded workloads
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	defer eventBroadcaster.Shutdown()
+
 	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, *collectorSyncPeriod)
 
 	controller := reloader.NewController(
 		logger,
 		kubeClient,
+		eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "vault-secrets-reloader"}),
+		reloader.CollectorConfig{
+			SecretPathsAnnotation:       *secretPathsAnnotation,
+			ExtraSecretPathsAnnotations: splitList(*extraSecretPathsAnnotations),
+			ReloadByDefault:             *reloadByDefault,
+			StoreConfigMap:              *storeConfigMap,
+			StoreNamespace:              *storeNamespace,
+			StoreFlushPeriod:            *storeFlushPeriod,
+			StoreEvictionPeriod:         *storeEvictionPeriod,
+			StartupSyncAttempts:         *startupSyncAttempts,
+			StartupSyncTimeout:          *startupSyncTimeout,
+			IncludeNamespaces:           splitList(*includeNamespaces),
+			ExcludeNamespaces:           splitList(*excludeNamespaces),
+			EnabledWorkloadKinds:        splitList(*enabledWorkloadKinds),
+			WorkloadLabelSelector:       labelSelector,
+			ExcludeAnnotations:          splitList(*excludeAnnotations),
+			SecretDelimiter:             *secretDelimiter,
+			VersionSeparators:           splitList(*versionSeparators),
+			ExcludeSecretPaths:          splitList(*excludeSecretPaths),
+			AllowedMounts:               splitList(*allowedMounts),
+			AllowedVaultAddrs:           splitList(*allowedVaultAddrs),
+			ExcludeSecretPathRegexps:    secretPathRegexps,
+			PathVariables:               secretPathVariables,
+			PathVariablesFromEnv:        *pathVariablesFromEnv,
+			NonSecretVaultPrefixes:      splitList(*nonSecretVaultPrefixes),
+			MaxPathsPerAnnotation:       *maxPathsPerAnnotation,
+			MaxPathsPerWorkload:         *maxPathsPerWorkload,
+		},
+		reloader.ReloaderConfig{
+			ReconcileInterval:           *reloaderRunPeriod,
+			ReconcileJitter:             *reloaderRunJitter,
+			NamespaceReconcileIntervals: namespaceReconcileIntervals,
+			CronJobReloadStrategy:       reloader.CronJobReloadStrategy(*cronJobReloadStrategy),
+			ReloadStrategy:              reloader.ReloadStrategy(*reloadStrategy),
+			ChangeDetection:             reloader.ChangeDetection(*changeDetection),
+			ReloadMetadataKey:           *reloadMetadataKey,
+			DryRun:                      *dryRun,
+			ReloadCooldown:              *reloadCooldown,
+			InitialGracePeriod:          *initialGracePeriod,
+			VersionCacheTTL:             *versionCacheTTL,
+			VersionCacheSize:            *versionCacheSize,
+			VaultUnavailableBackoff:     *vaultUnavailableBackoff,
+			CollectOnly:                 *collectOnly,
+			MissingSecretPolicy:         reloader.MissingSecretPolicy(*missingSecretPolicy),
+			ReloadMaxAttempts:           *reloadMaxAttempts,
+			ReloadRetryBackoff:          *reloadRetryBackoff,
+			ReloadHooks: reloader.ReloadHooksConfig{
+				PreReloadURL:            *preReloadHookURL,
+				PostReloadURL:           *postReloadHookURL,
+				BlockOnPreReloadFailure: *blockOnPreReloadHookFailure,
+			},
+			MaxConcurrentReloads: *maxConcurrentReloads,
+			ShutdownTimeout:      *shutdownTimeout,
+			PatchStrategy:        reloader.PatchStrategy(*patchStrategy),
+			FieldManager:         *fieldManager,
+			RestartAnnotation:    *restartAnnotation,
+			LeaderElection: reloader.LeaderElectionConfig{
+				Enabled:        *leaderElect,
+				LeaseName:      *leaderElectionLease,
+				LeaseNamespace: *leaderElectionNamespace,
+				Identity:       hostname,
+			},
+		},
 		kubeInformerFactory.Apps().V1().Deployments(),
 		kubeInformerFactory.Apps().V1().DaemonSets(),
 		kubeInformerFactory.Apps().V1().StatefulSets(),
+		kubeInformerFactory.Apps().V1().ReplicaSets(),
+		kubeInformerFactory.Batch().V1().CronJobs(),
+		kubeInformerFactory.Batch().V1().Jobs(),
 		kubeInformerFactory.Core().V1().Secrets(),
+		kubeInformerFactory.Core().V1().ConfigMaps(),
 	)
 
+	// Argo Rollouts are watched through the dynamic client, since their CRD may be absent
+	var dynamicInformerFactory dynamicinformer.DynamicSharedInformerFactory
+	if *enableArgoRollouts {
+		dynamicClient, err := dynamic.NewForConfig(kubeConfig)
+		if err != nil {
+			logger.Error(fmt.Errorf("error building dynamic client: %s", err).Error())
+			os.Exit(1)
+		}
+		dynamicInformerFactory = dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, *collectorSyncPeriod)
+		controller.WatchArgoRollouts(dynamicClient, dynamicInformerFactory.ForResource(reloader.RolloutGVR).Informer())
+	}
+
+	if *eventSinkURL != "" {
+		controller.SetEventSink(reloader.NewWebhookEventSink(*eventSinkURL))
+	}
+
+	// Watching every Pod of the cluster is expensive, so they are only collected on demand
+	if *enablePods {
+		controller.WatchPods(kubeInformerFactory.Core().V1().Pods())
+	}
+
+	// Only report whether the tracked secret paths can be read, e.g. before deploying the Reloader
+	if flag.Arg(0) == "check-access" {
+		kubeInformerFactory.Start(ctx.Done())
+		if dynamicInformerFactory != nil {
+			dynamicInformerFactory.Start(ctx.Done())
+		}
+		if err := controller.CheckAccess(ctx, os.Stdout); err != nil {
+			logger.Error(fmt.Errorf("error checking Vault access: %s", err).Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Handler for health checks, metrics and debugging
+	port := os.Getenv("LISTEN_ADDRESS")
+	if port == "" {
+		port = ":8080"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/readyz", controller.ReadyHandler())
+	mux.Handle("/status", controller.StatusHandler())
+	if token := os.Getenv("RELOAD_ENDPOINT_TOKEN"); token != "" {
+		mux.Handle("/reload/", controller.ReloadHandler(token))
+		mux.Handle("/reload-secret", controller.ReloadSecretHandler(token))
+	}
+	if *enableDebugEndpoints {
+		mux.Handle("/debug/workloads", controller.WorkloadsHandler())
+		mux.Handle("/debug/secrets", controller.SecretsHandler())
+	}
+	if *enableLogLevelEndpoint {
+		mux.Handle("/debug/loglevel", reloader.LogLevelHandler(logLevelVar))
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	go func() {
+		_ = http.ListenAndServe(port, mux)
+	}()
+
+	// Dump the tracked workloads on SIGUSR1, e.g. to migrate them to another cluster
+	controller.ExportSnapshotOnSignal(ctx, os.Stdout, syscall.SIGUSR1)
+
 	kubeInformerFactory.Start(ctx.Done())
+	if dynamicInformerFactory != nil {
+		dynamicInformerFactory.Start(ctx.Done())
+	}
 
-	if err = controller.Run(ctx, *reloaderRunPeriod); err != nil {
+	if err = controller.Run(ctx); err != nil {
 		logger.Error(fmt.Errorf("error running controller: %s", err).Error())
 		os.Exit(1)
 	}
 }
+
+// splitList splits a comma separated flag value, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,130 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// ReloadHandler returns a handler forcing the reload of a tracked workload on
+// POST /reload/{namespace}/{kind}/{name} requests authenticated with the bearer token,
+// responding 202 once the reload is started, 404 if the workload is not tracked and
+// 503 if this replica is not running or not the leader
+func (c *Controller) ReloadHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !authorized(r, token) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		ctx, err := c.forcedReloadContext()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		requested, err := parseWorkloadKey(strings.TrimPrefix(r.URL.Path, "/reload/"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !c.workloadSecrets.Has(requested) {
+			http.Error(w, fmt.Sprintf("workload %s is not tracked", requested.key()), http.StatusNotFound)
+			return
+		}
+
+		c.logger.Info(fmt.Sprintf("Forced reload of workload requested: %s", requested))
+		// The reload waits for a slot of the ones shared by all the reloads of the controller
+		go c.reloadConcurrently(ctx, c.logger, map[workload][]string{requested: nil}, false)
+
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// ReloadSecretHandler returns a handler forcing the reload of all the workloads using a
+// secret path on POST /reload-secret?path={secretPath} requests authenticated with the
+// bearer token, responding 202 once the reloads are started, 404 if the path is not tracked
+// and 503 if this replica is not running or not the leader
+func (c *Controller) ReloadSecretHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !authorized(r, token) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		ctx, err := c.forcedReloadContext()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		secretPath := normalizeSecretPath(r.URL.Query().Get("path"))
+		if secretPath == "" {
+			http.Error(w, "missing secret path", http.StatusBadRequest)
+			return
+		}
+		workloads, ok := c.workloadSecrets.GetSecretWorkloadsMap()[secretPath]
+		if !ok {
+			http.Error(w, fmt.Sprintf("secret path %s is not tracked", secretPath), http.StatusNotFound)
+			return
+		}
+
+		c.logger.Info(fmt.Sprintf("Forced reload of the %d workloads using %s requested", len(workloads), secretPath),
+			slog.String("secret_path", secretPath))
+		workloadsToReload := make(map[workload][]string, len(workloads))
+		for _, workload := range workloads {
+			workloadsToReload[workload] = []string{secretPath}
+		}
+		go c.reloadConcurrently(ctx, c.logger, workloadsToReload, false)
+
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// forcedReloadContext returns the context of Run the forced reloads are done under,
+// failing if the controller is not running or not the leader, which does all reloads
+func (c *Controller) forcedReloadContext() (context.Context, error) {
+	ctx := c.runCtx.Load()
+	if ctx == nil || (*ctx).Err() != nil {
+		return nil, fmt.Errorf("the controller is not running")
+	}
+	if !c.isLeader() {
+		return nil, fmt.Errorf("not the leader, only the leader reloads workloads")
+	}
+	return *ctx, nil
+}
+
+// authorized reports whether the request has the bearer token, no request is authorized without one
+func authorized(r *http.Request, token string) bool {
+	bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+}
@@ -0,0 +1,196 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReloadHandler(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Template: newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password"),
+		},
+	}
+	kubeClient := fake.NewSimpleClientset(deployment)
+	controller := newTestController(kubeClient)
+	controller.workloadSecrets.Store(workload{name: "app", namespace: "default", kind: DeploymentKind}, []string{"secret/data/app"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	controller.runCtx.Store(&ctx)
+	handler := controller.ReloadHandler("s3cr3t")
+
+	request := func(method string, path string, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	t.Run("tracked workload", func(t *testing.T) {
+		assert.Equal(t, http.StatusAccepted, request(http.MethodPost, "/reload/default/Deployment/app", "s3cr3t"))
+
+		assert.Eventually(t, func() bool {
+			deployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+			return err == nil && deployment.Spec.Template.GetAnnotations()[ReloadCountAnnotationName] == "1"
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("shares the concurrency limit", func(t *testing.T) {
+		reloadCount := func() string {
+			deployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+			assert.NoError(t, err)
+			return deployment.Spec.Template.GetAnnotations()[ReloadCountAnnotationName]
+		}
+
+		// All the reload slots of the controller are taken by the reloader
+		controller.reloadSemaphore <- struct{}{}
+		assert.Equal(t, http.StatusAccepted, request(http.MethodPost, "/reload/default/Deployment/app", "s3cr3t"))
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, "1", reloadCount())
+
+		<-controller.reloadSemaphore
+		assert.Eventually(t, func() bool { return reloadCount() == "2" }, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("untracked workload", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/reload/default/Deployment/other", "s3cr3t"))
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/reload/default", "s3cr3t"))
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/reload/default/Deployment/app", ""))
+		assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/reload/default/Deployment/app", "wrong"))
+	})
+
+	t.Run("POST only", func(t *testing.T) {
+		assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodGet, "/reload/default/Deployment/app", "s3cr3t"))
+	})
+
+	t.Run("not the leader", func(t *testing.T) {
+		controller.reloaderConfig.LeaderElection.Enabled = true
+		defer func() { controller.reloaderConfig.LeaderElection.Enabled = false }()
+		assert.Equal(t, http.StatusServiceUnavailable, request(http.MethodPost, "/reload/default/Deployment/app", "s3cr3t"))
+	})
+
+	t.Run("shutting down", func(t *testing.T) {
+		cancel()
+		assert.Equal(t, http.StatusServiceUnavailable, request(http.MethodPost, "/reload/default/Deployment/app", "s3cr3t"))
+	})
+}
+
+func TestReloadSecretHandler(t *testing.T) {
+	newDeployment := func(name string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: appsv1.DeploymentSpec{
+				Template: newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/db#password"),
+			},
+		}
+	}
+	kubeClient := fake.NewSimpleClientset(newDeployment("api"), newDeployment("worker"), newDeployment("other"))
+	controller := newTestController(kubeClient)
+	controller.reloaderConfig.MaxConcurrentReloads = 1
+	controller.workloadSecrets.Store(workload{name: "api", namespace: "default", kind: DeploymentKind}, []string{"secret/data/db"})
+	controller.workloadSecrets.Store(workload{name: "worker", namespace: "default", kind: DeploymentKind}, []string{"secret/data/app", "secret/data/db"})
+	controller.workloadSecrets.Store(workload{name: "other", namespace: "default", kind: DeploymentKind}, []string{"secret/data/app"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	controller.runCtx.Store(&ctx)
+	handler := controller.ReloadSecretHandler("s3cr3t")
+
+	request := func(method string, target string, token string) int {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	reloadCount := func(name string) string {
+		deployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return deployment.Spec.Template.GetAnnotations()[ReloadCountAnnotationName]
+	}
+
+	t.Run("tracked secret path", func(t *testing.T) {
+		assert.Equal(t, http.StatusAccepted, request(http.MethodPost, "/reload-secret?path=secret/data/db", "s3cr3t"))
+
+		assert.Eventually(t, func() bool {
+			return reloadCount("api") == "1" && reloadCount("worker") == "1"
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Empty(t, reloadCount("other"))
+	})
+
+	t.Run("shares the concurrency limit", func(t *testing.T) {
+		// All the reload slots of the controller are taken by the reloader
+		controller.reloadSemaphore <- struct{}{}
+		assert.Equal(t, http.StatusAccepted, request(http.MethodPost, "/reload-secret?path=secret/data/db", "s3cr3t"))
+		assert.Equal(t, http.StatusAccepted, request(http.MethodPost, "/reload-secret?path=secret/data/db", "s3cr3t"))
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, "1", reloadCount("api"))
+		assert.Equal(t, "1", reloadCount("worker"))
+
+		<-controller.reloadSemaphore
+		assert.Eventually(t, func() bool {
+			return reloadCount("api") == "3" && reloadCount("worker") == "3"
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("untracked secret path", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/reload-secret?path=secret/data/missing", "s3cr3t"))
+	})
+
+	t.Run("missing secret path", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/reload-secret", "s3cr3t"))
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/reload-secret?path=secret/data/db", ""))
+		assert.Equal(t, http.StatusUnauthorized, request(http.MethodPost, "/reload-secret?path=secret/data/db", "wrong"))
+	})
+
+	t.Run("POST only", func(t *testing.T) {
+		assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodGet, "/reload-secret?path=secret/data/db", "s3cr3t"))
+	})
+
+	t.Run("not the leader", func(t *testing.T) {
+		controller.reloaderConfig.LeaderElection.Enabled = true
+		defer func() { controller.reloaderConfig.LeaderElection.Enabled = false }()
+		assert.Equal(t, http.StatusServiceUnavailable, request(http.MethodPost, "/reload-secret?path=secret/data/db", "s3cr3t"))
+	})
+
+	t.Run("shutting down", func(t *testing.T) {
+		cancel()
+		assert.Equal(t, http.StatusServiceUnavailable, request(http.MethodPost, "/reload-secret?path=secret/data/db", "s3cr3t"))
+	})
+}
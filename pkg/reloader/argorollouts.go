@@ -0,0 +1,120 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+)
+
+const RolloutKind = "Rollout"
+
+// RolloutGVR is the resource of Argo Rollouts, which are watched through the dynamic
+// client since their CRD may not be installed
+var RolloutGVR = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}
+
+// WatchArgoRollouts collects the secrets of Argo Rollouts from the informer, and reloads
+// them with the dynamic client, it has to be called before Run
+func (c *Controller) WatchArgoRollouts(dynamicClient dynamic.Interface, rolloutInformer cache.SharedIndexInformer) {
+	c.dynamicClient = dynamicClient
+	c.rolloutsStore = rolloutInformer.GetStore()
+	c.rolloutsSynced = rolloutInformer.HasSynced
+
+	_, _ = rolloutInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.handleObject,
+		UpdateFunc: func(old, new interface{}) { c.handleObject(new) },
+		DeleteFunc: c.handleObjectDelete,
+	})
+}
+
+// rolloutPodTemplate returns the pod template embedded in a Rollout, Rollouts referencing
+// the template of a Deployment with workloadRef have none
+func rolloutPodTemplate(rollout *unstructured.Unstructured) (corev1.PodTemplateSpec, bool, error) {
+	template, found, err := unstructured.NestedMap(rollout.Object, "spec", "template")
+	if err != nil || !found {
+		return corev1.PodTemplateSpec{}, false, err
+	}
+
+	var podTemplateSpec corev1.PodTemplateSpec
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(template, &podTemplateSpec); err != nil {
+		return corev1.PodTemplateSpec{}, false, err
+	}
+	return podTemplateSpec, true, nil
+}
+
+// reloadRollout increments the restart annotation in the pod template of a Rollout
+// with a merge patch owned by the configured field manager, so that Argo Rollouts replaces its pods
+func (c *Controller) reloadRollout(workload workload, secretVersions string) (runtime.Object, error) {
+	if c.dynamicClient == nil {
+		return nil, fmt.Errorf("cannot reload %s, Argo Rollouts are not watched", workload)
+	}
+
+	rollouts := c.dynamicClient.Resource(RolloutGVR).Namespace(workload.namespace)
+	rollout, err := rollouts.Get(context.Background(), workload.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if paused, _, _ := unstructured.NestedBool(rollout.Object, "spec", "paused"); paused {
+		return rollout, errWorkloadPaused
+	}
+
+	// The patch is built from a copy of the template annotations like for the other workloads,
+	// so that forced reloads apply the recorded secret versions again instead of pruning them
+	annotations, _, _ := unstructured.NestedStringMap(rollout.Object, "spec", "template", "metadata", "annotations")
+	if secretVersions != "" && annotations[SecretVersionsAnnotationName] == secretVersions {
+		return rollout, errAlreadyReloaded
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+
+	version := "1"
+	restartAnnotation := c.reloaderConfig.restartAnnotation()
+	if count, err := strconv.Atoi(annotations[restartAnnotation]); err == nil {
+		version = strconv.Itoa(count + 1)
+	}
+	annotations[restartAnnotation] = version
+	if secretVersions != "" {
+		annotations[SecretVersionsAnnotationName] = secretVersions
+	}
+
+	patch, patchType, err := c.reloadPatch(workload, RolloutGVR.GroupVersion().String(), restartAnnotation, annotations, "spec", "template", "metadata", "annotations")
+	if err != nil {
+		return rollout, err
+	}
+	// Custom resources don't support strategic merge patches, the JSON merge patch of the
+	// reload annotations is the same
+	if patchType == types.StrategicMergePatchType {
+		patchType = types.MergePatchType
+	}
+
+	// The patched Rollout is a typed nil on failure, the events are recorded on the fetched one
+	reloaded, err := rollouts.Patch(context.Background(), workload.name, patchType, patch, c.reloadPatchOptions(patchType))
+	if err != nil {
+		return rollout, err
+	}
+	return reloaded, nil
+}
@@ -0,0 +1,185 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func newTestRollout(t *testing.T, name string, annotations map[string]string, envValue string) *unstructured.Unstructured {
+	podTemplate := newTestPodTemplate(annotations, envValue)
+	template, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&podTemplate)
+	assert.NoError(t, err)
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       RolloutKind,
+		"metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+		"spec":       map[string]interface{}{"template": template},
+	}}
+}
+
+func TestArgoRollouts(t *testing.T) {
+	rollout := newTestRollout(t, "app", map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	workloadRef := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       RolloutKind,
+		"metadata":   map[string]interface{}{"name": "ref", "namespace": "default"},
+		"spec": map[string]interface{}{
+			"workloadRef": map[string]interface{}{"apiVersion": "apps/v1", "kind": DeploymentKind, "name": "app"},
+		},
+	}}
+	rolloutWorkload := workload{name: "app", namespace: "default", kind: RolloutKind}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{RolloutGVR: "RolloutList"}, rollout, workloadRef)
+	controller := newTestController(nil)
+	controller.dynamicClient = dynamicClient
+	// The fake dynamic client can't apply patches to unstructured objects
+	controller.reloaderConfig.PatchStrategy = PatchJSONMerge
+
+	t.Run("collect", func(t *testing.T) {
+		controller.handleObject(rollout)
+		controller.handleObject(workloadRef)
+		assert.Equal(t,
+			map[workload][]string{rolloutWorkload: {"secret/data/app"}},
+			controller.workloadSecrets.GetWorkloadSecretsMap(),
+		)
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		for _, want := range []string{"1", "2"} {
+			_, err := controller.reloadWorkload(rolloutWorkload, "")
+			assert.NoError(t, err)
+
+			reloaded, err := dynamicClient.Resource(RolloutGVR).Namespace("default").Get(context.Background(), "app", metav1.GetOptions{})
+			assert.NoError(t, err)
+			annotations, _, _ := unstructured.NestedStringMap(reloaded.Object, "spec", "template", "metadata", "annotations")
+			assert.Equal(t, want, annotations[ReloadCountAnnotationName])
+			assert.Equal(t, "true", annotations[SecretReloadAnnotationName])
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		controller.handleObjectDelete(rollout)
+		assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		_, err := newTestController(nil).reloadWorkload(rolloutWorkload, "")
+		assert.Error(t, err)
+	})
+}
+
+func TestReloadRolloutPatchStrategy(t *testing.T) {
+	tests := []struct {
+		name      string
+		strategy  PatchStrategy
+		patchType types.PatchType
+	}{
+		{name: "server-side apply", strategy: PatchServerSideApply, patchType: types.ApplyPatchType},
+		// Custom resources don't support strategic merge patches
+		{name: "strategic merge", strategy: PatchStrategicMerge, patchType: types.MergePatchType},
+		{name: "JSON merge", strategy: PatchJSONMerge, patchType: types.MergePatchType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rollout := newTestRollout(t, "app", map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+			dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{RolloutGVR: "RolloutList"}, rollout)
+			var patches []k8stesting.PatchAction
+			dynamicClient.PrependReactor("patch", "rollouts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				patches = append(patches, action.(k8stesting.PatchAction))
+				return true, rollout, nil
+			})
+			controller := newTestController(nil)
+			controller.dynamicClient = dynamicClient
+			controller.reloaderConfig.PatchStrategy = tt.strategy
+
+			_, err := controller.reloadWorkload(workload{name: "app", namespace: "default", kind: RolloutKind}, "")
+			assert.NoError(t, err)
+
+			assert.Len(t, patches, 1)
+			assert.Equal(t, tt.patchType, patches[0].GetPatchType())
+			assert.Contains(t, string(patches[0].GetPatch()), ReloadCountAnnotationName)
+		})
+	}
+}
+
+func TestReloadRolloutForcedApply(t *testing.T) {
+	rollout := newTestRollout(t, "app", map[string]string{
+		SecretReloadAnnotationName:   "true",
+		ReloadCountAnnotationName:    "1",
+		SecretVersionsAnnotationName: "secret/data/app=3",
+	}, "vault:secret/data/app#password")
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{RolloutGVR: "RolloutList"}, rollout)
+	var patches []k8stesting.PatchAction
+	dynamicClient.PrependReactor("patch", "rollouts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patches = append(patches, action.(k8stesting.PatchAction))
+		return true, rollout, nil
+	})
+	controller := newTestController(nil)
+	controller.dynamicClient = dynamicClient
+	controller.reloaderConfig.PatchStrategy = PatchServerSideApply
+
+	// A forced reload has no secret versions, the recorded ones are applied again so that
+	// the field manager doesn't prune them
+	_, err := controller.reloadWorkload(workload{name: "app", namespace: "default", kind: RolloutKind}, "")
+	assert.NoError(t, err)
+
+	assert.Len(t, patches, 1)
+	var patch unstructured.Unstructured
+	assert.NoError(t, patch.UnmarshalJSON(patches[0].GetPatch()))
+	annotations, _, _ := unstructured.NestedStringMap(patch.Object, "spec", "template", "metadata", "annotations")
+	assert.Equal(t, map[string]string{
+		ReloadCountAnnotationName:    "2",
+		SecretVersionsAnnotationName: "secret/data/app=3",
+	}, annotations)
+}
+
+func TestReloadRolloutPatchFailure(t *testing.T) {
+	rollout := newTestRollout(t, "app", map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{RolloutGVR: "RolloutList"}, rollout)
+	dynamicClient.PrependReactor("patch", "rollouts", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("admission webhook denied the request")
+	})
+	broadcaster := record.NewBroadcaster()
+	defer broadcaster.Shutdown()
+	controller := newTestController(nil)
+	controller.dynamicClient = dynamicClient
+	controller.recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "vault-secrets-reloader"})
+
+	// The failure is recorded on the fetched Rollout instead of panicking on the result of the patch
+	assert.NotPanics(t, func() {
+		err := controller.triggerReload(context.Background(), workload{name: "app", namespace: "default", kind: RolloutKind}, []string{"secret/data/app"})
+		assert.ErrorContains(t, err, "admission webhook denied the request")
+	})
+}
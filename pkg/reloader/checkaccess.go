@@ -0,0 +1,164 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// errNotKVSecret marks the tracked secret paths that are skipped by the access check,
+// as the reloader does not check the dynamic secrets for changes
+var errNotKVSecret = errors.New("not a KV secret, skipped")
+
+// accessCheck is the outcome of reading a tracked secret path like the reloader does
+type accessCheck struct {
+	secretPath string
+	mount      string
+	err        error
+}
+
+func (a accessCheck) status() string {
+	switch {
+	case a.err == nil:
+		return "PASS"
+	case errors.Is(a.err, errNotKVSecret):
+		return "SKIP"
+	default:
+		return "FAIL"
+	}
+}
+
+// CheckAccess collects the workloads of the cluster, then reads the metadata of every
+// tracked secret path with the Vault client of the reloader, and writes a report of the
+// paths and mounts that could be read or not. It fails if any path could not be read.
+func (c *Controller) CheckAccess(ctx context.Context, w io.Writer) error {
+	if err := c.waitForCacheSync(ctx); err != nil {
+		return err
+	}
+	c.resyncWorkloads()
+
+	if err := c.initVaultClient(); err != nil {
+		return fmt.Errorf("failed to initialize Vault client: %w", err)
+	}
+
+	checks := c.checkAccess(ctx)
+	if err := writeAccessReport(w, checks); err != nil {
+		return fmt.Errorf("failed to write access report: %w", err)
+	}
+
+	return accessCheckError(checks)
+}
+
+// accessCheckError returns an error if any secret path could not be read
+func accessCheckError(checks []accessCheck) error {
+	var failed int
+	for _, check := range checks {
+		if check.status() == "FAIL" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tracked Vault secret paths could not be read", failed, len(checks))
+	}
+	return nil
+}
+
+// checkAccess reads every tracked secret path once, sorted by path
+func (c *Controller) checkAccess(ctx context.Context) []accessCheck {
+	secretPaths := make([]string, 0)
+	for secretPath := range c.workloadSecrets.GetSecretWorkloadsMap() {
+		secretPaths = append(secretPaths, secretPath)
+	}
+	slices.Sort(secretPaths)
+
+	checks := make([]accessCheck, 0, len(secretPaths))
+	for _, secretPath := range secretPaths {
+		_, namespacedPath := splitVaultAddrSecretPath(secretPath)
+		_, path := splitNamespacedSecretPath(namespacedPath)
+
+		var mount KVMount
+		vaultClient, _, err := c.secretClient(secretPath)
+		if err == nil {
+			mount, err = c.readSecretMetadata(ctx, vaultClient, secretPath, path)
+		}
+		checks = append(checks, accessCheck{secretPath: secretPath, mount: mount.mountPathOf(path), err: err})
+	}
+	return checks
+}
+
+// readSecretMetadata sends the requests the reloader sends to detect the changes of a secret path,
+// returning the mount of the secret path
+func (c *Controller) readSecretMetadata(ctx context.Context, vaultClient VaultClient, secretPath string, path string) (KVMount, error) {
+	mount := c.kvMount(ctx, c.logger, vaultClient, secretPath, path)
+	var err error
+	switch {
+	case mount.Version == notKVMount:
+		err = errNotKVSecret
+	case isWildcardSecretPath(path):
+		_, err = vaultClient.ListSecrets(ctx, strings.TrimSuffix(path, "/*"), mount)
+	case mount.Version == 1 || c.reloaderConfig.ChangeDetection == ChangeDetectionContentHash:
+		_, err = vaultClient.SecretHash(ctx, path, mount.Version)
+	default:
+		_, err = vaultClient.SecretVersion(ctx, path, mount.Path)
+	}
+	return mount, err
+}
+
+// writeAccessReport writes the outcome of the access check of every secret path,
+// followed by the number of paths of each mount that could be read or not
+func writeAccessReport(w io.Writer, checks []accessCheck) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tMOUNT\tSECRET PATH\tERROR")
+	type mountCounts struct{ passed, failed, skipped int }
+	mounts := make(map[string]*mountCounts)
+	mountNames := []string{}
+	for _, check := range checks {
+		counts, ok := mounts[check.mount]
+		if !ok {
+			counts = &mountCounts{}
+			mounts[check.mount] = counts
+			mountNames = append(mountNames, check.mount)
+		}
+
+		var reason string
+		switch check.status() {
+		case "PASS":
+			counts.passed++
+		case "SKIP":
+			counts.skipped++
+			reason = check.err.Error()
+		default:
+			counts.failed++
+			// Vault errors span multiple lines
+			reason = vaultLookupErrorType(check.err) + ": " + strings.Join(strings.Fields(check.err.Error()), " ")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", check.status(), check.mount, check.secretPath, reason)
+	}
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "MOUNT\tPASSED\tFAILED\tSKIPPED")
+	slices.Sort(mountNames)
+	for _, mount := range mountNames {
+		counts := mounts[mount]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", mount, counts.passed, counts.failed, counts.skipped)
+	}
+	return tw.Flush()
+}
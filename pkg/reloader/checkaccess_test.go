@@ -0,0 +1,72 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAccess(t *testing.T) {
+	vault := newTestVault(t)
+	vault.setVersion("app", 1)
+	vault.setVersion("db", 1)
+	vault.setMountVersion("platform", "ci", 1)
+	vault.setMountVersion("team/data/kv", "app", 1)
+	vault.setContents("legacy", map[string]interface{}{"password": "s3cr3t"})
+	vault.forbidden = []string{"db", "ci"}
+
+	controller := newTestController(nil)
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.workloadSecrets.Store(workload{name: "app", namespace: "default", kind: DeploymentKind},
+		[]string{"secret/data/app", "secret/data/db", "kv/legacy", "database/creds/readonly"})
+	controller.workloadSecrets.Store(workload{name: "ci", namespace: "default", kind: DeploymentKind},
+		[]string{"platform/data/ci", "secret/data/app"})
+	controller.workloadSecrets.Store(workload{name: "team", namespace: "default", kind: DeploymentKind},
+		[]string{"team/data/kv/data/app"})
+
+	checks := controller.checkAccess(context.Background())
+	statuses := make(map[string]string)
+	for _, check := range checks {
+		statuses[check.secretPath] = check.status()
+	}
+	assert.Equal(t, map[string]string{
+		"database/creds/readonly": "SKIP",
+		"kv/legacy":               "PASS",
+		"platform/data/ci":        "FAIL",
+		"secret/data/app":         "PASS",
+		"secret/data/db":          "FAIL",
+		"team/data/kv/data/app":   "PASS",
+	}, statuses)
+	assert.EqualError(t, accessCheckError(checks), "2 of 6 tracked Vault secret paths could not be read")
+
+	var report bytes.Buffer
+	assert.NoError(t, writeAccessReport(&report, checks))
+	assert.Regexp(t, `(?m)^FAIL +secret +secret/data/db +auth: `, report.String())
+	assert.Regexp(t, `(?m)^PASS +secret +secret/data/app *$`, report.String())
+	assert.Regexp(t, `(?m)^SKIP +database +database/creds/readonly +not a KV secret, skipped$`, report.String())
+	assert.Regexp(t, `(?m)^platform +0 +1 +0$`, report.String())
+	assert.Regexp(t, `(?m)^secret +1 +1 +0$`, report.String())
+	assert.Regexp(t, `(?m)^kv +1 +0 +0$`, report.String())
+	// Nested mounts are reported by their detected path
+	assert.Regexp(t, `(?m)^PASS +team/data/kv +team/data/kv/data/app *$`, report.String())
+	assert.Regexp(t, `(?m)^team/data/kv +1 +0 +0$`, report.String())
+
+	assert.NoError(t, accessCheckError(checks[:1]))
+}
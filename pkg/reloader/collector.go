@@ -15,23 +15,409 @@
 package reloader
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"os"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
-const VaultEnvSecretPathsAnnotation = "vault.security.banzaicloud.io/vault-env-from-path"
+const (
+	VaultEnvSecretPathsAnnotation = "vault.security.banzaicloud.io/vault-env-from-path"
+	// VaultVolumeSecretPathsAnnotation lists comma separated Vault secret paths written
+	// to volumes, e.g. by the Vault agent, it can be suffixed with the name of the
+	// volume, e.g. vault.security.banzaicloud.io/vault-from-path-config
+	VaultVolumeSecretPathsAnnotation = "vault.security.banzaicloud.io/vault-from-path"
+	// VaultNamespaceAnnotation sets the Vault Enterprise namespace the secrets of a
+	// workload are read from, overriding the one of the Vault client
+	VaultNamespaceAnnotation = "vault.security.banzaicloud.io/vault-namespace"
+	// VaultAddrAnnotation sets the address of the Vault server the secrets of a workload
+	// are read from, overriding the one of the Vault client
+	VaultAddrAnnotation = "vault.security.banzaicloud.io/vault-addr"
+
+	// vaultNamespaceSeparator separates the Vault namespace from the path in the
+	// tracked secret paths, so that identical paths in different namespaces don't collide
+	vaultNamespaceSeparator = "::"
+	// vaultAddrSeparator separates the address of the Vault server from the rest of the
+	// tracked secret paths, it comes before the Vault namespace
+	vaultAddrSeparator = "|"
+
+	// defaultSecretDelimiter separates the path, key and version of Vault references
+	// in the format the webhook uses by default
+	defaultSecretDelimiter = "#"
+	// defaultVersionSeparator separates the version pinned after the key of Vault references
+	// in the path#key@version format some bank-vaults configurations use
+	defaultVersionSeparator = "@"
+)
+
+// defaultNonSecretVaultPrefixes are the Vault references of the webhook that are not
+// secret paths: vault:login injects the Vault token of the workload and vault:v1:
+// prefixes values encrypted with the transit secrets engine
+var defaultNonSecretVaultPrefixes = []string{"login", "v1:"}
+
+// CollectorConfig holds the settings of the collector worker
+type CollectorConfig struct {
+	// SecretPathsAnnotation is the pod template annotation listing comma separated
+	// Vault secret paths, defaults to VaultEnvSecretPathsAnnotation
+	SecretPathsAnnotation string
+	// ExtraSecretPathsAnnotations are pod template annotations also listing comma
+	// separated Vault secret paths, for deployments encoding them in other annotations
+	ExtraSecretPathsAnnotations []string
+	// ReloadByDefault collects every workload using Vault secrets,
+	// not just the ones opted in with SecretReloadAnnotationName
+	ReloadByDefault bool
+	// StoreConfigMap is the name of the ConfigMap in StoreNamespace the collected
+	// data is flushed to every StoreFlushPeriod, persisting is disabled if empty
+	StoreConfigMap   string
+	StoreNamespace   string
+	StoreFlushPeriod time.Duration
+	// StoreEvictionPeriod is the interval of evicting the stored workloads that do not
+	// exist anymore, in case their deletion was missed, eviction is disabled if not set
+	StoreEvictionPeriod time.Duration
+	// StartupSyncAttempts is the number of times the store ConfigMap is read and the informer
+	// caches are waited for on startup, every attempt timing out after StartupSyncTimeout doubled
+	// on each retry, they are attempted once without timeout if StartupSyncTimeout is not set
+	StartupSyncAttempts int
+	StartupSyncTimeout  time.Duration
+	// IncludeNamespaces limits collection to the listed namespaces if not empty,
+	// ExcludeNamespaces takes precedence over it
+	IncludeNamespaces []string
+	ExcludeNamespaces []string
+	// EnabledWorkloadKinds limits collection and reloads to the listed workload kinds,
+	// e.g. DeploymentKind, all the supported kinds are enabled if it is empty
+	EnabledWorkloadKinds []string
+	// WorkloadLabelSelector limits collection to workloads with matching labels if set
+	WorkloadLabelSelector labels.Selector
+	// ExcludeAnnotations are annotation keys, e.g. reloader.stakater.com/auto, excluding the
+	// workloads having one of them on their metadata or pod template from collection
+	ExcludeAnnotations []string
+	// SecretDelimiter separates the path, key and version of Vault references,
+	// defaults to defaultSecretDelimiter
+	SecretDelimiter string
+	// VersionSeparators separate the version pinned after the key of the Vault references and
+	// after the secret paths of the annotations, e.g. "@" for vault:secret/data/app#key@2, the
+	// pinned secrets are not collected. Defaults to the SecretDelimiter and defaultVersionSeparator
+	VersionSeparators []string
+	// ExcludeSecretPaths are never collected, like the secret paths fully
+	// matching one of ExcludeSecretPathRegexps
+	ExcludeSecretPaths       []string
+	ExcludeSecretPathRegexps []*regexp.Regexp
+	// AllowedMounts limits collection to the secret paths of the listed Vault mounts,
+	// e.g. secret or team/kv, the secret paths of all mounts are collected if it is empty
+	AllowedMounts []string
+	// AllowedVaultAddrs are the addresses of the Vault servers, other than the default one,
+	// the workloads may read their secrets from with VaultAddrAnnotation, the secrets of the
+	// workloads setting another address are not tracked
+	AllowedVaultAddrs []string
+	// MaxPathsPerAnnotation and MaxPathsPerWorkload limit the number of secret paths collected
+	// from a secret paths annotation and tracked for a workload, the ones beyond the limits are
+	// dropped, there is no limit if they are not set
+	MaxPathsPerAnnotation int
+	MaxPathsPerWorkload   int
+	// PathVariables are the values of the ${NAME} placeholders of the collected secret paths,
+	// looked up in the environment of the controller if PathVariablesFromEnv is set
+	PathVariables        map[string]string
+	PathVariablesFromEnv bool
+	// NonSecretVaultPrefixes are the beginnings of the Vault references, after "vault:",
+	// that are not secret paths and are skipped, defaults to defaultNonSecretVaultPrefixes.
+	// A prefix not ending with ":" or "/" only matches a whole path.
+	NonSecretVaultPrefixes []string
+}
+
+func (c CollectorConfig) secretPathsAnnotation() string {
+	if c.SecretPathsAnnotation == "" {
+		return VaultEnvSecretPathsAnnotation
+	}
+	return c.SecretPathsAnnotation
+}
+
+// isSecretPathsAnnotation reports whether the annotation lists comma separated Vault secret paths
+func (c CollectorConfig) isSecretPathsAnnotation(key string) bool {
+	return key == c.secretPathsAnnotation() || slices.Contains(c.ExtraSecretPathsAnnotations, key)
+}
+
+// nonSecretVaultRef reports whether a Vault reference, without its "vault:" prefix, is not a secret path
+func (c CollectorConfig) nonSecretVaultRef(ref string) bool {
+	prefixes := c.NonSecretVaultPrefixes
+	if prefixes == nil {
+		prefixes = defaultNonSecretVaultPrefixes
+	}
+	return slices.ContainsFunc(prefixes, func(prefix string) bool {
+		if prefix == "" || !strings.HasPrefix(ref, prefix) {
+			return false
+		}
+		if strings.HasSuffix(prefix, ":") || strings.HasSuffix(prefix, "/") {
+			return true
+		}
+		rest := ref[len(prefix):]
+		return rest == "" || strings.HasPrefix(rest, c.secretDelimiter())
+	})
+}
+
+func (c CollectorConfig) secretDelimiter() string {
+	if c.SecretDelimiter == "" {
+		return defaultSecretDelimiter
+	}
+	return c.SecretDelimiter
+}
+
+func (c CollectorConfig) versionSeparators() []string {
+	if c.VersionSeparators == nil {
+		return []string{c.secretDelimiter(), defaultVersionSeparator}
+	}
+	return c.VersionSeparators
+}
+
+// ErrUnresolvedPlaceholder is returned for secret paths with a ${NAME} placeholder
+// without a value, so that they are skipped instead of being looked up literally
+type ErrUnresolvedPlaceholder struct {
+	secretPath string
+	name       string
+}
+
+func (e ErrUnresolvedPlaceholder) Error() string {
+	return fmt.Sprintf("unresolved placeholder ${%s} in Vault secret path %s", e.name, e.secretPath)
+}
+
+var placeholderRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// interpolateSecretPaths replaces the ${NAME} placeholders of the secret paths with the
+// PathVariables, dropping the paths with placeholders that cannot be resolved
+func (c CollectorConfig) interpolateSecretPaths(secretPaths []string) ([]string, error) {
+	var errs []error
+	interpolated := make([]string, 0, len(secretPaths))
+	for _, secretPath := range secretPaths {
+		var err error
+		secretPath := placeholderRegexp.ReplaceAllStringFunc(secretPath, func(placeholder string) string {
+			name := placeholderRegexp.FindStringSubmatch(placeholder)[1]
+			if value, ok := c.pathVariable(name); ok {
+				return value
+			}
+			if err == nil {
+				err = ErrUnresolvedPlaceholder{secretPath: secretPath, name: name}
+			}
+			return placeholder
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		interpolated = append(interpolated, normalizeSecretPath(secretPath))
+	}
+	return interpolated, errors.Join(errs...)
+}
+
+func (c CollectorConfig) pathVariable(name string) (string, bool) {
+	if value, ok := c.PathVariables[name]; ok {
+		return value, true
+	}
+	if c.PathVariablesFromEnv {
+		return os.LookupEnv(name)
+	}
+	return "", false
+}
+
+// removeExcludedSecretPaths drops the secret paths excluded from collection
+func (c CollectorConfig) removeExcludedSecretPaths(secretPaths []string) []string {
+	if len(c.ExcludeSecretPaths) == 0 && len(c.ExcludeSecretPathRegexps) == 0 {
+		return secretPaths
+	}
+	return slices.DeleteFunc(secretPaths, func(secretPath string) bool {
+		if slices.Contains(c.ExcludeSecretPaths, secretPath) {
+			return true
+		}
+		return slices.ContainsFunc(c.ExcludeSecretPathRegexps, func(re *regexp.Regexp) bool {
+			return re.MatchString(secretPath)
+		})
+	})
+}
+
+// ErrMountNotAllowed is returned for secret paths of a mount not in AllowedMounts,
+// so that they are skipped instead of being looked up in Vault
+type ErrMountNotAllowed struct {
+	secretPath string
+}
+
+func (e ErrMountNotAllowed) Error() string {
+	return fmt.Sprintf("Vault secret path %s is not in an allowed mount", e.secretPath)
+}
+
+const (
+	secretPathsLimitAnnotation = "annotation"
+	secretPathsLimitWorkload   = "workload"
+)
+
+// ErrTooManySecretPaths is returned when more secret paths than MaxPathsPerAnnotation
+// are listed in an annotation, the ones beyond the limit being dropped
+type ErrTooManySecretPaths struct {
+	annotation string
+	limit      int
+}
+
+func (e ErrTooManySecretPaths) Error() string {
+	return fmt.Sprintf("annotation %s lists more than %d Vault secret paths, dropping the rest", e.annotation, e.limit)
+}
+
+// mountAllowed tells whether a secret path, without Vault server and namespace, is in one of AllowedMounts
+func (c CollectorConfig) mountAllowed(secretPath string) bool {
+	if len(c.AllowedMounts) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(c.AllowedMounts, func(mount string) bool {
+		mount = strings.Trim(mount, "/")
+		return mount != "" && (secretPath == mount || strings.HasPrefix(secretPath, mount+"/"))
+	})
+}
+
+// removeDisallowedSecretPaths drops the secret paths of the mounts not in AllowedMounts
+func (c CollectorConfig) removeDisallowedSecretPaths(secretPaths []string) ([]string, error) {
+	var errs []error
+	secretPaths = slices.DeleteFunc(secretPaths, func(secretPath string) bool {
+		if c.mountAllowed(secretPath) {
+			return false
+		}
+		errs = append(errs, ErrMountNotAllowed{secretPath: secretPath})
+		return true
+	})
+	return secretPaths, errors.Join(errs...)
+}
+
+func (c CollectorConfig) namespaceAllowed(namespace string) bool {
+	if slices.Contains(c.ExcludeNamespaces, namespace) {
+		return false
+	}
+	return len(c.IncludeNamespaces) == 0 || slices.Contains(c.IncludeNamespaces, namespace)
+}
+
+func (c CollectorConfig) kindEnabled(kind string) bool {
+	return len(c.EnabledWorkloadKinds) == 0 || slices.Contains(c.EnabledWorkloadKinds, kind)
+}
+
+func (c CollectorConfig) labelsAllowed(workloadLabels map[string]string) bool {
+	return c.WorkloadLabelSelector == nil || c.WorkloadLabelSelector.Matches(labels.Set(workloadLabels))
+}
+
+// annotationsExcluded reports whether one of the annotations excludes a workload from collection
+func (c CollectorConfig) annotationsExcluded(annotations map[string]string) bool {
+	for _, key := range c.ExcludeAnnotations {
+		if _, ok := annotations[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadEnabled tells whether a workload is reloaded, setting the reload annotation
+// to "false" opts it out even if ReloadByDefault is set
+func (c CollectorConfig) reloadEnabled(template corev1.PodTemplateSpec) bool {
+	switch template.GetAnnotations()[SecretReloadAnnotationName] {
+	case "true":
+		return true
+	case "false":
+		return false
+	default:
+		return c.ReloadByDefault
+	}
+}
+
+// duplicateSlashesRegexp matches consecutive slashes in secret paths
+var duplicateSlashesRegexp = regexp.MustCompile(`/{2,}`)
+
+// vaultSecretRefRegexp matches every Vault reference in a value that is separated
+// by whitespace or follows a "=", capturing the part after the "vault:" prefix
+var vaultSecretRefRegexp = regexp.MustCompile(`(?:^|[\s=])(?:>>)?vault:(\S*)`)
+
+// pathSource tells where the paths of a workload were collected from
+type pathSource string
+
+const (
+	pathSourceEnv        pathSource = "env"
+	pathSourceArgs       pathSource = "args"
+	pathSourceAnnotation pathSource = "annotation"
+	pathSourceConfigMap  pathSource = "configMap"
+	pathSourceSecret     pathSource = "secret"
+	pathSourceSecretRef  pathSource = "secretRef"
+)
+
+// trackedPath is a secret path of a workload along with its source, which is empty
+// for the paths stored without one, e.g. the ones restored from a snapshot
+type trackedPath struct {
+	Path   string
+	Source pathSource
+}
+
+func tagSecretPaths(secretPaths []string, source pathSource) []trackedPath {
+	trackedPaths := make([]trackedPath, 0, len(secretPaths))
+	for _, secretPath := range secretPaths {
+		trackedPaths = append(trackedPaths, trackedPath{Path: secretPath, Source: source})
+	}
+	return trackedPaths
+}
+
+// trackedPathNames returns the secret paths of the tracked paths in order, once
+// even if they were collected from several sources
+func trackedPathNames(trackedPaths []trackedPath) []string {
+	secretPaths := make([]string, 0, len(trackedPaths))
+	for _, trackedPath := range trackedPaths {
+		if !slices.Contains(secretPaths, trackedPath.Path) {
+			secretPaths = append(secretPaths, trackedPath.Path)
+		}
+	}
+	return secretPaths
+}
+
+// compactTrackedPaths sorts the tracked paths by path and source, removing duplicates
+func compactTrackedPaths(trackedPaths []trackedPath) []trackedPath {
+	slices.SortFunc(trackedPaths, func(a, b trackedPath) int {
+		if a.Path != b.Path {
+			return strings.Compare(a.Path, b.Path)
+		}
+		return strings.Compare(string(a.Source), string(b.Source))
+	})
+	return slices.Compact(trackedPaths)
+}
 
 type workloadSecretsStore interface {
+	// Store records the secret paths of a workload without their source
 	Store(workload workload, secrets []string)
+	StoreTrackedPaths(workload workload, trackedPaths []trackedPath)
+	GetTrackedPaths(workload workload) ([]trackedPath, bool)
 	Delete(workload workload)
+	Has(workload workload) bool
+	GetSecrets(workload workload) ([]string, bool)
 	GetWorkloadSecretsMap() map[workload][]string
 	GetSecretWorkloadsMap() map[string][]workload
+	Snapshot() ([]byte, error)
+	Restore(snapshot []byte) error
+	SetLastReload(workload workload, reloadedAt time.Time)
+	GetLastReload(workload workload) (time.Time, bool)
+	SetFirstSeen(workload workload, seenAt time.Time)
+	GetFirstSeen(workload workload) (time.Time, bool)
+	// SetReconcileInterval records the interval the secrets of a workload are checked at,
+	// it is dropped if the interval is 0
+	SetReconcileInterval(workload workload, interval time.Duration)
+	GetReconcileIntervals() map[workload]time.Duration
+	SetVersion(secretPath string, version int)
+	GetVersion(secretPath string) (int, bool)
+	SetHash(secretPath string, hash string)
+	GetHash(secretPath string) (string, bool)
+	SetMetadataValue(secretPath string, value string)
+	GetMetadataValue(secretPath string) (string, bool)
+	PruneVersions(secretPaths []string)
+	UntrackSecretPath(secretPath string)
+	StoreSecretRefs(workload workload, secrets []workload)
+	GetSecretConsumersMap() map[workload][]workload
 }
 
 type workload struct {
@@ -40,66 +426,487 @@ type workload struct {
 	kind      string
 }
 
+// key identifies the workload in the namespace/kind/name format
+func (w workload) key() string {
+	return w.namespace + "/" + w.kind + "/" + w.name
+}
+
+func parseWorkloadKey(key string) (workload, error) {
+	split := strings.SplitN(key, "/", 3)
+	if len(split) != 3 {
+		return workload{}, fmt.Errorf("invalid workload key: %s", key)
+	}
+
+	return workload{namespace: split[0], kind: split[1], name: split[2]}, nil
+}
+
 type workloadSecrets struct {
 	sync.RWMutex
-	workloadSecretsMap map[workload][]string
+	workloadSecretsMap map[workload][]trackedPath
+	lastReloads        map[workload]time.Time
+	// firstSeen holds when the workloads created after the initial collection were first collected
+	firstSeen map[workload]time.Time
+	// reconcileIntervals holds the reconcile intervals the workloads set with an annotation
+	reconcileIntervals map[workload]time.Duration
+	// secretVersions holds the last observed version of the secret paths
+	secretVersions map[string]int
+	// secretHashes holds the last observed content hash of the secret paths
+	// whose changes are not detected by their version
+	secretHashes map[string]string
+	// secretMetadataValues holds the last observed value of the reload custom metadata key of the secret paths
+	secretMetadataValues map[string]string
+	// untrackedSecretPaths holds the secret paths that are never stored again
+	untrackedSecretPaths map[string]bool
+	// workloadSecretRefsMap holds the Kubernetes Secrets consumed by the workloads
+	workloadSecretRefsMap map[workload][]workload
 }
 
 func newWorkloadSecrets() workloadSecretsStore {
 	return &workloadSecrets{
-		workloadSecretsMap: make(map[workload][]string),
+		workloadSecretsMap:    make(map[workload][]trackedPath),
+		lastReloads:           make(map[workload]time.Time),
+		firstSeen:             make(map[workload]time.Time),
+		reconcileIntervals:    make(map[workload]time.Duration),
+		secretVersions:        make(map[string]int),
+		secretHashes:          make(map[string]string),
+		secretMetadataValues:  make(map[string]string),
+		untrackedSecretPaths:  make(map[string]bool),
+		workloadSecretRefsMap: make(map[workload][]workload),
 	}
 }
 
 func (w *workloadSecrets) Store(workload workload, secrets []string) {
+	w.StoreTrackedPaths(workload, tagSecretPaths(secrets, ""))
+}
+
+func (w *workloadSecrets) StoreTrackedPaths(workload workload, trackedPaths []trackedPath) {
+	w.Lock()
+	defer w.Unlock()
+	if len(w.untrackedSecretPaths) > 0 {
+		trackedPaths = slices.DeleteFunc(slices.Clone(trackedPaths), func(trackedPath trackedPath) bool {
+			return w.untrackedSecretPaths[trackedPath.Path]
+		})
+		if len(trackedPaths) == 0 {
+			delete(w.workloadSecretsMap, workload)
+			return
+		}
+	}
+	w.workloadSecretsMap[workload] = trackedPaths
+}
+
+// GetTrackedPaths returns a copy of the secret paths of a workload along with their sources
+func (w *workloadSecrets) GetTrackedPaths(workload workload) ([]trackedPath, bool) {
+	w.RLock()
+	defer w.RUnlock()
+	trackedPaths, ok := w.workloadSecretsMap[workload]
+	return slices.Clone(trackedPaths), ok
+}
+
+// UntrackSecretPath removes a secret path from all workloads and keeps it from being
+// stored again, dropping the workloads that have no other secret paths
+func (w *workloadSecrets) UntrackSecretPath(secretPath string) {
 	w.Lock()
 	defer w.Unlock()
-	w.workloadSecretsMap[workload] = secrets
+	w.untrackedSecretPaths[secretPath] = true
+	delete(w.secretVersions, secretPath)
+	delete(w.secretHashes, secretPath)
+	delete(w.secretMetadataValues, secretPath)
+	for workload, trackedPaths := range w.workloadSecretsMap {
+		isSecretPath := func(trackedPath trackedPath) bool {
+			return trackedPath.Path == secretPath
+		}
+		if !slices.ContainsFunc(trackedPaths, isSecretPath) {
+			continue
+		}
+		trackedPaths = slices.DeleteFunc(slices.Clone(trackedPaths), isSecretPath)
+		if len(trackedPaths) == 0 {
+			delete(w.workloadSecretsMap, workload)
+			continue
+		}
+		w.workloadSecretsMap[workload] = trackedPaths
+	}
 }
 
 func (w *workloadSecrets) Delete(workload workload) {
 	w.Lock()
 	defer w.Unlock()
 	delete(w.workloadSecretsMap, workload)
+	delete(w.lastReloads, workload)
+	delete(w.firstSeen, workload)
+	delete(w.reconcileIntervals, workload)
+	delete(w.workloadSecretRefsMap, workload)
 }
 
-func (w *workloadSecrets) GetWorkloadSecretsMap() map[workload][]string {
-	return w.workloadSecretsMap
+// StoreSecretRefs records the Kubernetes Secrets consumed by a workload,
+// dropping the workload from the index if it consumes none
+func (w *workloadSecrets) StoreSecretRefs(workload workload, secrets []workload) {
+	w.Lock()
+	defer w.Unlock()
+	if len(secrets) == 0 {
+		delete(w.workloadSecretRefsMap, workload)
+		return
+	}
+	w.workloadSecretRefsMap[workload] = secrets
 }
 
-func (w *workloadSecrets) GetSecretWorkloadsMap() map[string][]workload {
+// GetSecretConsumersMap returns the workloads consuming each Kubernetes Secret,
+// the reverse of the index kept by StoreSecretRefs
+func (w *workloadSecrets) GetSecretConsumersMap() map[workload][]workload {
+	w.RLock()
+	defer w.RUnlock()
+	secretConsumers := make(map[workload][]workload)
+	for workload, secrets := range w.workloadSecretRefsMap {
+		for _, secret := range secrets {
+			secretConsumers[secret] = append(secretConsumers[secret], workload)
+		}
+	}
+	return secretConsumers
+}
+
+func (w *workloadSecrets) SetLastReload(workload workload, reloadedAt time.Time) {
+	w.Lock()
+	defer w.Unlock()
+	w.lastReloads[workload] = reloadedAt
+}
+
+func (w *workloadSecrets) GetLastReload(workload workload) (time.Time, bool) {
+	w.RLock()
+	defer w.RUnlock()
+	reloadedAt, ok := w.lastReloads[workload]
+	return reloadedAt, ok
+}
+
+func (w *workloadSecrets) SetFirstSeen(workload workload, seenAt time.Time) {
+	w.Lock()
+	defer w.Unlock()
+	w.firstSeen[workload] = seenAt
+}
+
+func (w *workloadSecrets) GetFirstSeen(workload workload) (time.Time, bool) {
+	w.RLock()
+	defer w.RUnlock()
+	seenAt, ok := w.firstSeen[workload]
+	return seenAt, ok
+}
+
+func (w *workloadSecrets) SetReconcileInterval(workload workload, interval time.Duration) {
+	w.Lock()
+	defer w.Unlock()
+	if interval == 0 {
+		delete(w.reconcileIntervals, workload)
+		return
+	}
+	w.reconcileIntervals[workload] = interval
+}
+
+func (w *workloadSecrets) GetReconcileIntervals() map[workload]time.Duration {
+	w.RLock()
+	defer w.RUnlock()
+	return maps.Clone(w.reconcileIntervals)
+}
+
+func (w *workloadSecrets) SetVersion(secretPath string, version int) {
+	w.Lock()
+	defer w.Unlock()
+	w.secretVersions[secretPath] = version
+}
+
+func (w *workloadSecrets) GetVersion(secretPath string) (int, bool) {
+	w.RLock()
+	defer w.RUnlock()
+	version, ok := w.secretVersions[secretPath]
+	return version, ok
+}
+
+func (w *workloadSecrets) SetHash(secretPath string, hash string) {
 	w.Lock()
 	defer w.Unlock()
+	w.secretHashes[secretPath] = hash
+}
+
+func (w *workloadSecrets) GetHash(secretPath string) (string, bool) {
+	w.RLock()
+	defer w.RUnlock()
+	hash, ok := w.secretHashes[secretPath]
+	return hash, ok
+}
+
+func (w *workloadSecrets) SetMetadataValue(secretPath string, value string) {
+	w.Lock()
+	defer w.Unlock()
+	w.secretMetadataValues[secretPath] = value
+}
+
+func (w *workloadSecrets) GetMetadataValue(secretPath string) (string, bool) {
+	w.RLock()
+	defer w.RUnlock()
+	value, ok := w.secretMetadataValues[secretPath]
+	return value, ok
+}
+
+// PruneVersions drops the versions, hashes and metadata values of the secret paths that are not listed
+func (w *workloadSecrets) PruneVersions(secretPaths []string) {
+	retained := make(map[string]bool, len(secretPaths))
+	for _, secretPath := range secretPaths {
+		retained[secretPath] = true
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	for secretPath := range w.secretVersions {
+		if !retained[secretPath] {
+			delete(w.secretVersions, secretPath)
+		}
+	}
+	for secretPath := range w.secretHashes {
+		if !retained[secretPath] {
+			delete(w.secretHashes, secretPath)
+		}
+	}
+	for secretPath := range w.secretMetadataValues {
+		if !retained[secretPath] {
+			delete(w.secretMetadataValues, secretPath)
+		}
+	}
+}
+
+func (w *workloadSecrets) Has(workload workload) bool {
+	w.RLock()
+	defer w.RUnlock()
+	_, ok := w.workloadSecretsMap[workload]
+	return ok
+}
+
+// GetSecrets returns a copy of the secret paths of a single workload
+func (w *workloadSecrets) GetSecrets(workload workload) ([]string, bool) {
+	w.RLock()
+	defer w.RUnlock()
+	trackedPaths, ok := w.workloadSecretsMap[workload]
+	if !ok {
+		return nil, false
+	}
+	return trackedPathNames(trackedPaths), true
+}
+
+// GetWorkloadSecretsMap returns a deep copy of the stored workloads, so that callers
+// neither race with the collector storing workloads nor mutate the store
+func (w *workloadSecrets) GetWorkloadSecretsMap() map[workload][]string {
+	w.RLock()
+	defer w.RUnlock()
+	workloadSecrets := make(map[workload][]string, len(w.workloadSecretsMap))
+	for workload, trackedPaths := range w.workloadSecretsMap {
+		workloadSecrets[workload] = trackedPathNames(trackedPaths)
+	}
+	return workloadSecrets
+}
+
+func (w *workloadSecrets) GetSecretWorkloadsMap() map[string][]workload {
+	w.RLock()
+	defer w.RUnlock()
 	secretWorkloads := make(map[string][]workload)
-	for workload, secretPaths := range w.workloadSecretsMap {
-		for _, secretPath := range secretPaths {
+	for workload, trackedPaths := range w.workloadSecretsMap {
+		for _, secretPath := range trackedPathNames(trackedPaths) {
 			secretWorkloads[secretPath] = append(secretWorkloads[secretPath], workload)
 		}
 	}
 	return secretWorkloads
 }
 
-func (c *Controller) collectWorkloadSecrets(workload workload, template corev1.PodTemplateSpec) {
+// Snapshot serializes the secret paths of the stored workloads keyed by namespace/kind/name,
+// their sources are left out as they are set again when the workloads are collected
+func (w *workloadSecrets) Snapshot() ([]byte, error) {
+	w.RLock()
+	defer w.RUnlock()
+	workloads := make(map[string][]string, len(w.workloadSecretsMap))
+	for workload, trackedPaths := range w.workloadSecretsMap {
+		workloads[workload.key()] = trackedPathNames(trackedPaths)
+	}
+	return json.Marshal(workloads)
+}
+
+// Restore loads workloads from a snapshot, keeping the ones that have already
+// been collected since they are more recent than the snapshot
+func (w *workloadSecrets) Restore(snapshot []byte) error {
+	if len(snapshot) == 0 {
+		return nil
+	}
+
+	var workloads map[string][]string
+	if err := json.Unmarshal(snapshot, &workloads); err != nil {
+		return err
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	for key, secretPaths := range workloads {
+		workload, err := parseWorkloadKey(key)
+		if err != nil {
+			return err
+		}
+		if _, ok := w.workloadSecretsMap[workload]; !ok {
+			w.workloadSecretsMap[workload] = tagSecretPaths(secretPaths, "")
+		}
+	}
+	return nil
+}
+
+func (c *Controller) collectWorkloadSecrets(workload workload, workloadLabels map[string]string, template corev1.PodTemplateSpec) {
 	collectorLogger := c.logger.With(slog.String("worker", "collector"))
 
+	// Skip workload and drop it from the store in case it was collected before
+	if !c.collectorConfig.kindEnabled(workload.kind) ||
+		!c.collectorConfig.namespaceAllowed(workload.namespace) ||
+		!c.collectorConfig.labelsAllowed(workloadLabels) ||
+		c.collectorConfig.annotationsExcluded(template.GetAnnotations()) ||
+		!c.collectorConfig.reloadEnabled(template) {
+		c.workloadSecrets.Delete(workload)
+		return
+	}
+	collectorLogger.Debug(fmt.Sprintf("Processing workload: %#v", workload))
+
 	// Collect secrets from different locations
-	vaultSecretPaths := collectSecrets(template)
+	trackedPaths, err := collectSecrets(template, c.collectorConfig)
+	envFromSecretPaths, envFromErr := c.collectSecretsFromEnvFrom(workload.namespace, templateContainers(template))
+	envFromSecretPaths, filterErr := c.collectorConfig.filterSecretPaths(envFromSecretPaths, template.GetAnnotations())
+	secretRefPaths, secretRefErr := c.collectSecretsFromEnvFromSecrets(workload.namespace, templateContainers(template))
+	secretRefPaths, secretRefFilterErr := c.collectorConfig.filterSecretPaths(secretRefPaths, template.GetAnnotations())
+	if err := errors.Join(err, envFromErr, filterErr, secretRefErr, secretRefFilterErr); err != nil {
+		// Malformed or unresolved references are skipped, the valid ones of the workload are still tracked
+		collectorLogger.Warn(fmt.Errorf("skipping invalid Vault references: %w", err).Error())
+		if errors.As(err, &ErrTooManySecretPaths{}) {
+			c.metrics.truncatedSecretPaths.WithLabelValues(workload.namespace, workload.kind, secretPathsLimitAnnotation).Inc()
+		}
+	}
+	if len(envFromSecretPaths) > 0 {
+		trackedPaths = compactTrackedPaths(append(trackedPaths, tagSecretPaths(envFromSecretPaths, pathSourceConfigMap)...))
+	}
+	if len(secretRefPaths) > 0 {
+		trackedPaths = compactTrackedPaths(append(trackedPaths, tagSecretPaths(secretRefPaths, pathSourceSecretRef)...))
+	}
 
-	if len(vaultSecretPaths) == 0 {
+	// The limit counts the secret paths, a path collected from several sources keeps all of them
+	if secretPaths, limit := trackedPathNames(trackedPaths), c.collectorConfig.MaxPathsPerWorkload; limit > 0 && len(secretPaths) > limit {
+		collectorLogger.Warn(fmt.Sprintf("%s uses %d Vault secret paths, tracking only the first %d of them", workload, len(secretPaths), limit))
+		c.metrics.truncatedSecretPaths.WithLabelValues(workload.namespace, workload.kind, secretPathsLimitWorkload).Inc()
+		kept := secretPaths[:limit]
+		trackedPaths = slices.DeleteFunc(trackedPaths, func(trackedPath trackedPath) bool {
+			return !slices.Contains(kept, trackedPath.Path)
+		})
+	}
+
+	// Index the Kubernetes Secrets consumed by the workload, so that it is reloaded
+	// when one of them changes even if it references no Vault secret itself
+	secretRefs := collectSecretRefs(workload.namespace, template)
+
+	// Logging in to a Vault server set by a workload would hand it the credentials of the reloader
+	if vaultAddr := template.GetAnnotations()[VaultAddrAnnotation]; vaultAddr != "" && !c.vaultAddrAllowed(vaultAddr) {
+		collectorLogger.Error(fmt.Sprintf("%s sets Vault address %s that is not allowed, not tracking its secrets", workload, vaultAddr))
+		c.workloadSecrets.Delete(workload)
+		c.workloadSecrets.StoreSecretRefs(workload, secretRefs)
+		return
+	}
+
+	if len(trackedPaths) == 0 {
 		collectorLogger.Debug("No Vault secret paths found in container env vars")
+		c.workloadSecrets.Delete(workload)
+		c.workloadSecrets.StoreSecretRefs(workload, secretRefs)
 		return
 	}
-	collectorLogger.Debug(fmt.Sprintf("Vault secret paths found: %v", vaultSecretPaths))
+	if vaultNamespace := template.GetAnnotations()[VaultNamespaceAnnotation]; vaultNamespace != "" {
+		for i, trackedPath := range trackedPaths {
+			trackedPaths[i].Path = namespacedSecretPath(vaultNamespace, trackedPath.Path)
+		}
+	}
+	if vaultAddr := template.GetAnnotations()[VaultAddrAnnotation]; vaultAddr != "" {
+		for i, trackedPath := range trackedPaths {
+			trackedPaths[i].Path = vaultAddrSecretPath(vaultAddr, trackedPath.Path)
+		}
+	}
+	collectorLogger.Debug(fmt.Sprintf("Vault secret paths found: %v", trackedPaths))
+
+	// Workloads showing up after the existing ones were collected are new, their
+	// pods already run with the current secrets during InitialGracePeriod
+	if c.existingCollected.Load() && !c.workloadSecrets.Has(workload) {
+		c.workloadSecrets.SetFirstSeen(workload, time.Now())
+	}
+
+	reconcileInterval, err := parseReconcileInterval(template.GetAnnotations()[ReconcileIntervalAnnotationName])
+	if err != nil {
+		collectorLogger.Warn(fmt.Errorf("ignoring the reconcile interval of %s: %w", workload, err).Error())
+	}
 
 	// Add workload and secrets to workloadSecrets map
-	c.workloadSecrets.Store(workload, vaultSecretPaths)
+	c.workloadSecrets.StoreTrackedPaths(workload, trackedPaths)
+	c.workloadSecrets.StoreSecretRefs(workload, secretRefs)
+	c.workloadSecrets.SetReconcileInterval(workload, reconcileInterval)
 	collectorLogger.Info(fmt.Sprintf("Collected secrets from %s %s/%s", workload.kind, workload.namespace, workload.name))
 }
 
+// parseReconcileInterval parses the value of ReconcileIntervalAnnotationName, raising it to
+// minReconcileInterval if it is shorter, 0 stands for no override
+func parseReconcileInterval(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("reconcile interval %s is not positive", value)
+	}
+	return max(interval, minReconcileInterval), nil
+}
+
+// collectSecretRefs returns the Kubernetes Secrets a pod template consumes through
+// volumes, env vars and envFrom, as workloads of the Secret kind
+func collectSecretRefs(namespace string, template corev1.PodTemplateSpec) []workload {
+	names := []string{}
+	for _, volume := range template.Spec.Volumes {
+		if volume.Secret != nil {
+			names = append(names, volume.Secret.SecretName)
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil {
+					names = append(names, source.Secret.Name)
+				}
+			}
+		}
+	}
+	for _, container := range templateContainers(template) {
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+				names = append(names, env.ValueFrom.SecretKeyRef.Name)
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil {
+				names = append(names, envFrom.SecretRef.Name)
+			}
+		}
+	}
+
+	slices.Sort(names)
+	secrets := []workload{}
+	for _, name := range slices.Compact(names) {
+		if name != "" {
+			secrets = append(secrets, workload{name: name, namespace: namespace, kind: SecretsKind})
+		}
+	}
+	return secrets
+}
 
 func (c *Controller) collectKindSecrets(workload workload, secret *corev1.Secret) {
 	collectorLogger := c.logger.With(slog.String("worker", "collector"))
 
+	if !c.collectorConfig.kindEnabled(workload.kind) ||
+		!c.collectorConfig.namespaceAllowed(workload.namespace) ||
+		!c.collectorConfig.labelsAllowed(secret.GetLabels()) {
+		c.workloadSecrets.Delete(workload)
+		return
+	}
+
 	// Collect secrets from different locations
 	vaultSecretPaths := collectSecretsFromSecret(*secret)
 
@@ -110,22 +917,82 @@ func (c *Controller) collectKindSecrets(workload workload, secret *corev1.Secret
 	collectorLogger.Debug(fmt.Sprintf("Vault secret paths found: %v", vaultSecretPaths))
 
 	// Add workload and secrets to workloadSecrets map
-	c.workloadSecrets.Store(workload, vaultSecretPaths)
+	c.workloadSecrets.StoreTrackedPaths(workload, tagSecretPaths(vaultSecretPaths, pathSourceSecret))
 	collectorLogger.Info(fmt.Sprintf("Collected secrets from %s %s/%s", workload.kind, workload.namespace, workload.name))
 }
 
-func collectSecrets(template corev1.PodTemplateSpec) []string {
+// templateContainers returns the containers, init containers and ephemeral containers of
+// a pod template, the latter converted to containers as they share the same fields.
+// Only the containers listed in WatchContainersAnnotationName are returned if it is set.
+func templateContainers(template corev1.PodTemplateSpec) []corev1.Container {
 	containers := []corev1.Container{}
 	containers = append(containers, template.Spec.Containers...)
 	containers = append(containers, template.Spec.InitContainers...)
+	for _, ephemeralContainer := range template.Spec.EphemeralContainers {
+		containers = append(containers, corev1.Container(ephemeralContainer.EphemeralContainerCommon))
+	}
 
-	vaultSecretPaths := []string{}
-	vaultSecretPaths = append(vaultSecretPaths, collectSecretsFromContainerEnvVars(containers)...)
-	vaultSecretPaths = append(vaultSecretPaths, collectSecretsFromAnnotations(template.GetAnnotations())...)
+	watchContainers, ok := template.GetAnnotations()[WatchContainersAnnotationName]
+	if !ok {
+		return containers
+	}
+	watchedNames := []string{}
+	for _, name := range strings.Split(watchContainers, ",") {
+		watchedNames = append(watchedNames, strings.TrimSpace(name))
+	}
+	return slices.DeleteFunc(containers, func(container corev1.Container) bool {
+		return !slices.Contains(watchedNames, container.Name)
+	})
+}
+
+// collectSecrets returns the secret paths referenced by a pod template tagged with their
+// source, along with the errors of the malformed references that were skipped
+func collectSecrets(template corev1.PodTemplateSpec, config CollectorConfig) ([]trackedPath, error) {
+	containers := templateContainers(template)
+
+	envVarSecretPaths, envVarErr := collectSecretsFromContainerEnvVars(containers, config)
+	argSecretPaths, argErr := collectSecretsFromContainerArgs(containers, config)
+	errs := []error{envVarErr, argErr}
+
+	trackedPaths := []trackedPath{}
+	track := func(secretPaths []string, source pathSource) {
+		secretPaths, err := config.filterSecretPaths(secretPaths, template.GetAnnotations())
+		trackedPaths = append(trackedPaths, tagSecretPaths(secretPaths, source)...)
+		errs = append(errs, err)
+	}
+	track(envVarSecretPaths, pathSourceEnv)
+	track(argSecretPaths, pathSourceArgs)
+	annotationSecretPaths, annotationErr := collectSecretsFromAnnotations(template.GetAnnotations(), config)
+	errs = append(errs, annotationErr)
+	track(annotationSecretPaths, pathSourceAnnotation)
 
 	// Remove duplicates
-	slices.Sort(vaultSecretPaths)
-	return slices.Compact(vaultSecretPaths)
+	return compactTrackedPaths(trackedPaths), errors.Join(errs...)
+}
+
+// filterSecretPaths interpolates the collected secret paths, dropping the pinned and
+// excluded ones, and the ones of the mounts that are not allowed
+func (c CollectorConfig) filterSecretPaths(secretPaths []string, annotations map[string]string) ([]string, error) {
+	secretPaths, err := c.interpolateSecretPaths(secretPaths)
+	secretPaths = removePinnedSecretPaths(secretPaths, annotations)
+	secretPaths, mountErr := c.removeDisallowedSecretPaths(secretPaths)
+	return c.removeExcludedSecretPaths(secretPaths), errors.Join(err, mountErr)
+}
+
+// removePinnedSecretPaths drops the secret paths listed in PinnedPathsAnnotationName
+func removePinnedSecretPaths(secretPaths []string, annotations map[string]string) []string {
+	pinnedPaths := annotations[PinnedPathsAnnotationName]
+	if pinnedPaths == "" {
+		return secretPaths
+	}
+
+	pinned := make(map[string]bool)
+	for _, pinnedPath := range strings.Split(pinnedPaths, ",") {
+		pinned[normalizeSecretPath(strings.TrimSpace(pinnedPath))] = true
+	}
+	return slices.DeleteFunc(secretPaths, func(secretPath string) bool {
+		return pinned[secretPath]
+	})
 }
 
 func collectSecretsFromSecret(secret corev1.Secret) []string {
@@ -139,37 +1006,176 @@ func collectSecretsFromSecret(secret corev1.Secret) []string {
 	return slices.Compact(vaultSecretPaths)
 }
 
-func collectSecretsFromContainerEnvVars(containers []corev1.Container) []string {
+func collectSecretsFromContainerEnvVars(containers []corev1.Container, config CollectorConfig) ([]string, error) {
 	vaultSecretPaths := []string{}
+	var errs []error
 	// iterate through all environment variables and extract secrets
 	for _, container := range containers {
 		for _, env := range container.Env {
-			// Skip if env var does not contain a vault secret or is a secret with pinned version
-			if hasVaultPrefix(env.Value) && unversionedSecretValue(env.Value) {
-				secret := regexp.MustCompile(`vault:(.*?)#`).FindStringSubmatch(env.Value)[1]
-				if secret != "" {
-					vaultSecretPaths = append(vaultSecretPaths, secret)
+			value := strings.TrimSpace(env.Value)
+			// Skip if env var does not contain a vault secret
+			if !hasVaultPrefix(value) {
+				continue
+			}
+			secretPaths, err := collectSecretsFromValue(value, config)
+			vaultSecretPaths = append(vaultSecretPaths, secretPaths...)
+			errs = append(errs, err)
+		}
+	}
+
+	return vaultSecretPaths, errors.Join(errs...)
+}
+
+func collectSecretsFromContainerArgs(containers []corev1.Container, config CollectorConfig) ([]string, error) {
+	vaultSecretPaths := []string{}
+	var errs []error
+	// iterate through all commands and args and extract secrets, e.g. from --password=vault:path#key
+	for _, container := range containers {
+		for _, arg := range append(slices.Clone(container.Command), container.Args...) {
+			secretPaths, err := collectSecretsFromValue(arg, config)
+			vaultSecretPaths = append(vaultSecretPaths, secretPaths...)
+			errs = append(errs, err)
+		}
+	}
+
+	return vaultSecretPaths, errors.Join(errs...)
+}
+
+// collectSecretsFromEnvFrom extracts secrets from the values of ConfigMaps pulled in
+// via envFrom, reading them from the informer cache
+func (c *Controller) collectSecretsFromEnvFrom(namespace string, containers []corev1.Container) ([]string, error) {
+	vaultSecretPaths := []string{}
+	var errs []error
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef == nil {
+				continue
+			}
+
+			name := envFrom.ConfigMapRef.Name
+			configMap, err := c.configMapsLister.ConfigMaps(namespace).Get(name)
+			if err != nil {
+				if !apierrors.IsNotFound(err) {
+					c.logger.Error(fmt.Errorf("failed to read ConfigMap %s/%s: %w", namespace, name, err).Error())
+				}
+				continue
+			}
+
+			for _, value := range configMap.Data {
+				value = strings.TrimSpace(value)
+				if hasVaultPrefix(value) {
+					secretPaths, err := collectSecretsFromValue(value, c.collectorConfig)
+					vaultSecretPaths = append(vaultSecretPaths, secretPaths...)
+					errs = append(errs, err)
 				}
 			}
 		}
 	}
 
-	return vaultSecretPaths
+	return vaultSecretPaths, errors.Join(errs...)
 }
 
-func collectSecretsFromAnnotations(annotations map[string]string) []string {
+// collectSecretsFromEnvFromSecrets extracts secrets from the values of Secrets pulled in
+// via envFrom, which the webhook resolves like the ones of ConfigMaps, reading them
+// from the informer cache
+func (c *Controller) collectSecretsFromEnvFromSecrets(namespace string, containers []corev1.Container) ([]string, error) {
 	vaultSecretPaths := []string{}
+	var errs []error
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef == nil {
+				continue
+			}
 
-	secretPaths := annotations[VaultEnvSecretPathsAnnotation]
-	if secretPaths != "" {
-		for _, secretPath := range strings.Split(secretPaths, ",") {
-			if unversionedAnnotationSecretValue(secretPath) {
-				vaultSecretPaths = append(vaultSecretPaths, secretPath)
+			name := envFrom.SecretRef.Name
+			secret, err := c.secretsLister.Secrets(namespace).Get(name)
+			if err != nil {
+				if !apierrors.IsNotFound(err) {
+					c.logger.Error(fmt.Errorf("failed to read Secret %s/%s: %w", namespace, name, err).Error())
+				}
+				continue
+			}
+
+			for _, data := range secret.Data {
+				value := strings.TrimSpace(string(data))
+				if hasVaultPrefix(value) {
+					secretPaths, err := collectSecretsFromValue(value, c.collectorConfig)
+					vaultSecretPaths = append(vaultSecretPaths, secretPaths...)
+					errs = append(errs, err)
+				}
 			}
 		}
 	}
 
-	return vaultSecretPaths
+	return vaultSecretPaths, errors.Join(errs...)
+}
+
+// collectSecretsFromValue extracts the paths of all Vault references in a value,
+// skipping the ones without a key, with pinned version or that are not secret paths,
+// and returning an ErrMalformedVaultRef for each reference that cannot be parsed
+func collectSecretsFromValue(value string, config CollectorConfig) ([]string, error) {
+	vaultSecretPaths := []string{}
+	var errs []error
+	delimiter := config.secretDelimiter()
+	for _, match := range vaultSecretRefRegexp.FindAllStringSubmatch(value, -1) {
+		if config.nonSecretVaultRef(match[1]) {
+			continue
+		}
+		ref := parseVaultRef(match[1], delimiter, config.versionSeparators()...)
+		if err := ref.validate(match[1], delimiter); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// Wildcard paths stand for all the secrets below them, so they have no key
+		if (ref.Key == "" && !isWildcardSecretPath(ref.Path)) || !ref.unversioned() {
+			continue
+		}
+		if path := normalizeSecretPath(ref.Path); path != "" {
+			vaultSecretPaths = append(vaultSecretPaths, path)
+		}
+	}
+
+	return vaultSecretPaths, errors.Join(errs...)
+}
+
+// collectSecretsFromAnnotations extracts secrets from the secret paths annotation
+// and the volume secret paths annotations, returning an ErrTooManySecretPaths for
+// each annotation listing more than MaxPathsPerAnnotation secret paths
+func collectSecretsFromAnnotations(annotations map[string]string, config CollectorConfig) ([]string, error) {
+	vaultSecretPaths := []string{}
+	var errs []error
+
+	for key, secretPaths := range annotations {
+		if !config.isSecretPathsAnnotation(key) && !isVolumeSecretPathsAnnotation(key) {
+			continue
+		}
+		if secretPaths == "" {
+			continue
+		}
+		// Split no further than the limit, so that pathological lists are not allocated
+		limit, n := config.MaxPathsPerAnnotation, -1
+		if limit > 0 {
+			n = limit + 1
+		}
+		values := strings.SplitN(secretPaths, ",", n)
+		if limit > 0 && len(values) > limit {
+			values = values[:limit]
+			errs = append(errs, ErrTooManySecretPaths{annotation: key, limit: limit})
+		}
+		for _, secretPath := range values {
+			// Lists are often written with a space after the commas
+			if path := normalizeSecretPath(strings.TrimSpace(secretPath)); path != "" && unversionedAnnotationSecretValue(path, config.secretDelimiter(), config.versionSeparators()) {
+				vaultSecretPaths = append(vaultSecretPaths, path)
+			}
+		}
+	}
+
+	slices.Sort(vaultSecretPaths)
+	return vaultSecretPaths, errors.Join(errs...)
+}
+
+func isVolumeSecretPathsAnnotation(key string) bool {
+	return key == VaultVolumeSecretPathsAnnotation || strings.HasPrefix(key, VaultVolumeSecretPathsAnnotation+"-")
 }
 
 // copied from bank-vaults/vault-secrets-webhook/pkg/webhook/common.go
@@ -177,13 +1183,134 @@ func hasVaultPrefix(value string) bool {
 	return strings.HasPrefix(value, "vault:") || strings.HasPrefix(value, ">>vault:")
 }
 
-// implementation based on bank-vaults/vault-secrets-webhook/internal/injector/injector.go
-func unversionedSecretValue(value string) bool {
-	split := strings.SplitN(value, "#", 3)
-	return len(split) == 2
+// vaultRef is a Vault secret reference in the path#key#version format used by
+// the webhook, or path#key@version, without its "vault:" prefix, "#" being the delimiter
+type vaultRef struct {
+	Path    string
+	Key     string
+	Version string
+	// Options are the query-style modifiers appended to the reference after a "?",
+	// e.g. "opt=val" for vault:secret/data/app#key?opt=val
+	Options string
+}
+
+// parseVaultRef is based on bank-vaults/vault-secrets-webhook/internal/injector/injector.go,
+// the version follows one of the versionSeparators, the delimiter if there is none, and is
+// only split off the key if it is numeric, so that keys containing the separators are not
+// mistaken for pinned secrets
+func parseVaultRef(ref string, delimiter string, versionSeparators ...string) vaultRef {
+	ref, options := splitVaultRefOptions(ref)
+	path, key, _ := strings.Cut(ref, delimiter)
+	parsed := vaultRef{Path: path, Key: key, Options: options}
+
+	if len(versionSeparators) == 0 {
+		versionSeparators = []string{delimiter}
+	}
+	if key, version, ok := cutVersion(key, versionSeparators); ok {
+		parsed.Key = key
+		parsed.Version = version
+	}
+
+	return parsed
+}
+
+// cutVersion splits the numeric version following the last occurrence of the first
+// of the separators found in a value off it, reporting whether there is one
+func cutVersion(value string, separators []string) (string, string, bool) {
+	for _, separator := range separators {
+		if separator == "" {
+			continue
+		}
+		if i := strings.LastIndex(value, separator); i >= 0 {
+			if _, err := strconv.Atoi(value[i+len(separator):]); err == nil {
+				return value[:i], value[i+len(separator):], true
+			}
+		}
+	}
+	return value, "", false
+}
+
+// ErrMalformedVaultRef is returned for Vault references that cannot be parsed,
+// so that they are skipped instead of being tracked with a bogus path
+type ErrMalformedVaultRef struct {
+	ref    string
+	reason string
+}
+
+func (e ErrMalformedVaultRef) Error() string {
+	return fmt.Sprintf("malformed Vault reference %q: %s", "vault:"+e.ref, e.reason)
+}
+
+// splitVaultRefOptions splits the query-style options modifying a Vault reference off it
+func splitVaultRefOptions(ref string) (string, string) {
+	ref, options, _ := strings.Cut(ref, "?")
+	return ref, options
 }
 
-func unversionedAnnotationSecretValue(value string) bool {
-	split := strings.SplitN(value, "#", 2)
-	return len(split) == 1
+// validate checks that a parsed reference has a path, and a key if it has a delimiter
+func (r vaultRef) validate(ref string, delimiter string) error {
+	if normalizeSecretPath(r.Path) == "" {
+		return ErrMalformedVaultRef{ref: ref, reason: "empty secret path"}
+	}
+	if unmodified, _ := splitVaultRefOptions(ref); strings.Contains(unmodified, delimiter) && r.Key == "" {
+		return ErrMalformedVaultRef{ref: ref, reason: "empty secret key"}
+	}
+	return nil
+}
+
+func (r vaultRef) unversioned() bool {
+	return r.Version == ""
+}
+
+// normalizeSecretPath collapses duplicate slashes and trims trailing ones,
+// so that logically identical secret paths are only tracked once, the case is
+// kept since Vault paths are case-sensitive, secret/data/App and secret/data/app
+// being different secrets
+func normalizeSecretPath(secretPath string) string {
+	return strings.TrimRight(duplicateSlashesRegexp.ReplaceAllString(secretPath, "/"), "/")
+}
+
+// isWildcardSecretPath tells whether a secret path stands for all the secrets below it
+func isWildcardSecretPath(secretPath string) bool {
+	return strings.HasSuffix(secretPath, "/*")
+}
+
+// namespacedSecretPath prefixes a secret path with the Vault namespace it is read from
+func namespacedSecretPath(vaultNamespace string, secretPath string) string {
+	return vaultNamespace + vaultNamespaceSeparator + secretPath
+}
+
+// splitNamespacedSecretPath splits a tracked secret path into its Vault namespace,
+// which is empty for the namespace of the Vault client, and path
+func splitNamespacedSecretPath(secretPath string) (string, string) {
+	vaultNamespace, path, ok := strings.Cut(secretPath, vaultNamespaceSeparator)
+	if !ok {
+		return "", secretPath
+	}
+	return vaultNamespace, path
+}
+
+// vaultAddrSecretPath prefixes a secret path with the address of the Vault server it is read from
+func vaultAddrSecretPath(vaultAddr string, secretPath string) string {
+	return vaultAddr + vaultAddrSeparator + secretPath
+}
+
+// splitVaultAddrSecretPath splits a tracked secret path into the address of its Vault server,
+// which is empty for the server of the Vault client, and the possibly namespaced path
+func splitVaultAddrSecretPath(secretPath string) (string, string) {
+	vaultAddr, path, ok := strings.Cut(secretPath, vaultAddrSeparator)
+	if !ok {
+		return "", secretPath
+	}
+	return vaultAddr, path
+}
+
+// unversionedAnnotationSecretValue tells whether an annotation secret path has no version,
+// neither after the delimiter nor after one of the version separators
+func unversionedAnnotationSecretValue(value string, delimiter string, versionSeparators []string) bool {
+	if strings.Contains(value, delimiter) {
+		return false
+	}
+	_, _, versioned := cutVersion(value, versionSeparators)
+	return !versioned
 }
@@ -15,11 +15,22 @@
 package reloader
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sync"
 	"testing"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+	v1listers "k8s.io/client-go/listers/core/v1"
 )
 
 func TestWorkloadSecretsStore(t *testing.T) {
@@ -59,14 +70,118 @@ func TestWorkloadSecretsStore(t *testing.T) {
 		assert.ElementsMatch(t, secretWorkloadsMap["secret/data/docker"], []workload{workload2})
 	})
 
+	t.Run("GetWorkloadSecretsMap returns a copy", func(t *testing.T) {
+		workloadSecrets := store.GetWorkloadSecretsMap()
+		workloadSecrets[workload1][0] = "secret/data/changed"
+		workloadSecrets[workload2] = append(workloadSecrets[workload2], "secret/data/added")
+		delete(workloadSecrets, workload1)
+
+		assert.Equal(t,
+			map[workload][]string{
+				workload1: {"secret/data/accounts/aws", "secret/data/mysql"},
+				workload2: {"secret/data/accounts/aws", "secret/data/docker"},
+			},
+			store.GetWorkloadSecretsMap(),
+		)
+	})
+
+	t.Run("Has and GetSecrets", func(t *testing.T) {
+		assert.True(t, store.Has(workload1))
+		secretPaths, ok := store.GetSecrets(workload1)
+		assert.True(t, ok)
+		assert.Equal(t, []string{"secret/data/accounts/aws", "secret/data/mysql"}, secretPaths)
+
+		// The returned paths are a copy
+		secretPaths[0] = "secret/data/changed"
+		secretPaths, _ = store.GetSecrets(workload1)
+		assert.Equal(t, []string{"secret/data/accounts/aws", "secret/data/mysql"}, secretPaths)
+
+		absent := workload{name: "absent", namespace: "default", kind: DeploymentKind}
+		assert.False(t, store.Has(absent))
+		secretPaths, ok = store.GetSecrets(absent)
+		assert.False(t, ok)
+		assert.Nil(t, secretPaths)
+	})
+
 	t.Run("delete from workloadSecrets map", func(t *testing.T) {
 		// check workload secret deleting
 		store.Delete(workload1)
 		assert.Equal(t, map[workload][]string{
 			workload2: {"secret/data/accounts/aws", "secret/data/docker"}}, store.GetWorkloadSecretsMap())
+		assert.False(t, store.Has(workload1))
 	})
 }
 
+func TestWorkloadSecretsStoreSnapshot(t *testing.T) {
+	store := newWorkloadSecrets()
+	workload1 := workload{name: "test", namespace: "default", kind: DeploymentKind}
+	workload2 := workload{name: "test2", namespace: "default", kind: DaemonSetKind}
+	store.Store(workload1, []string{"secret/data/accounts/aws", "secret/data/mysql"})
+	store.Store(workload2, []string{"secret/data/docker"})
+
+	snapshot, err := store.Snapshot()
+	assert.NoError(t, err)
+
+	t.Run("round trip", func(t *testing.T) {
+		restored := newWorkloadSecrets()
+		assert.NoError(t, restored.Restore(snapshot))
+		assert.Equal(t, store.GetWorkloadSecretsMap(), restored.GetWorkloadSecretsMap())
+	})
+
+	t.Run("collected workloads take precedence", func(t *testing.T) {
+		restored := newWorkloadSecrets()
+		restored.Store(workload1, []string{"secret/data/mysql"})
+		assert.NoError(t, restored.Restore(snapshot))
+		assert.Equal(t,
+			map[workload][]string{
+				workload1: {"secret/data/mysql"},
+				workload2: {"secret/data/docker"},
+			},
+			restored.GetWorkloadSecretsMap(),
+		)
+	})
+
+	t.Run("empty snapshot", func(t *testing.T) {
+		restored := newWorkloadSecrets()
+		assert.NoError(t, restored.Restore(nil))
+		assert.Empty(t, restored.GetWorkloadSecretsMap())
+	})
+
+	t.Run("invalid snapshot", func(t *testing.T) {
+		assert.Error(t, newWorkloadSecrets().Restore([]byte(`{"invalid": ["secret/data/foo"]}`)))
+	})
+}
+
+// TestWorkloadSecretsStoreConcurrency relies on the race detector enabled by make test
+func TestWorkloadSecretsStoreConcurrency(t *testing.T) {
+	store := newWorkloadSecrets()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		i := i
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				store.Store(workload{name: fmt.Sprintf("app-%d-%d", i, j), namespace: "default", kind: DeploymentKind}, []string{"secret/data/shared"})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for range store.GetWorkloadSecretsMap() {
+				}
+				for range store.GetSecretWorkloadsMap() {
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, store.GetWorkloadSecretsMap(), 400)
+	assert.Len(t, store.GetSecretWorkloadsMap()["secret/data/shared"], 400)
+}
+
 func TestCollectSecrets(t *testing.T) {
 	template := corev1.PodTemplateSpec{
 		ObjectMeta: metav1.ObjectMeta{
@@ -127,5 +242,1244 @@ func TestCollectSecrets(t *testing.T) {
 		},
 	}
 
-	assert.Equal(t, []string{"secret/data/accounts/aws", "secret/data/foo", "secret/data/mysql"}, collectSecrets(template))
+	trackedPaths, err := collectSecrets(template, CollectorConfig{})
+	secretPaths := trackedPathNames(trackedPaths)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"secret/data/accounts/aws", "secret/data/foo", "secret/data/mysql"}, secretPaths)
+}
+
+func TestCollectSecretsEphemeralContainers(t *testing.T) {
+	template := corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "app",
+					Env: []corev1.EnvVar{
+						{
+							Name:  "ENV1",
+							Value: "value1",
+						},
+					},
+				},
+			},
+			EphemeralContainers: []corev1.EphemeralContainer{
+				{
+					EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+						Name: "debugger",
+						Env: []corev1.EnvVar{
+							{
+								Name:  "DEBUG_TOKEN",
+								Value: "vault:secret/data/debug#token",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	trackedPaths, err := collectSecrets(template, CollectorConfig{})
+	secretPaths := trackedPathNames(trackedPaths)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"secret/data/debug"}, secretPaths)
+}
+
+func TestCollectSecretsWatchContainers(t *testing.T) {
+	newTemplate := func(annotations map[string]string) corev1.PodTemplateSpec {
+		return corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{
+					{
+						Name: "init",
+						Env:  []corev1.EnvVar{{Name: "INIT_TOKEN", Value: "vault:secret/data/init#token"}},
+					},
+				},
+				Containers: []corev1.Container{
+					{
+						Name: "app",
+						Env:  []corev1.EnvVar{{Name: "APP_PASSWORD", Value: "vault:secret/data/app#password"}},
+					},
+					{
+						Name: "worker",
+						Env:  []corev1.EnvVar{{Name: "WORKER_PASSWORD", Value: "vault:secret/data/worker#password"}},
+					},
+					{
+						Name: "sidecar",
+						Env:  []corev1.EnvVar{{Name: "SIDECAR_TOKEN", Value: "vault:secret/data/sidecar#token"}},
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("all containers without annotation", func(t *testing.T) {
+		trackedPaths, err := collectSecrets(newTemplate(nil), CollectorConfig{})
+		secretPaths := trackedPathNames(trackedPaths)
+		assert.NoError(t, err)
+		assert.Equal(t,
+			[]string{"secret/data/app", "secret/data/init", "secret/data/sidecar", "secret/data/worker"},
+			secretPaths,
+		)
+	})
+
+	t.Run("only watched containers", func(t *testing.T) {
+		template := newTemplate(map[string]string{WatchContainersAnnotationName: "app, worker"})
+		trackedPaths, err := collectSecrets(template, CollectorConfig{})
+		secretPaths := trackedPathNames(trackedPaths)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/app", "secret/data/worker"}, secretPaths)
+	})
+}
+
+func TestCollectSecretsPinnedPaths(t *testing.T) {
+	template := newTestPodTemplate(map[string]string{
+		PinnedPathsAnnotationName:     "secret/data/db, secret/data/cache/",
+		VaultEnvSecretPathsAnnotation: "secret/data/cache,secret/data/config",
+	}, "vault:secret/data/db#password vault:secret/data/app#token")
+
+	trackedPaths, err := collectSecrets(template, CollectorConfig{})
+	secretPaths := trackedPathNames(trackedPaths)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"secret/data/app", "secret/data/config"}, secretPaths)
+
+	t.Run("all paths pinned", func(t *testing.T) {
+		workload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+		template := newTestPodTemplate(map[string]string{
+			SecretReloadAnnotationName: "true",
+			PinnedPathsAnnotationName:  "secret/data/db",
+		}, "vault:secret/data/db#password")
+
+		controller := newTestController(nil)
+		controller.collectWorkloadSecrets(workload, nil, template)
+		assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+	})
+}
+
+func TestCollectSecretsExcludeSecretPaths(t *testing.T) {
+	template := newTestPodTemplate(map[string]string{
+		VaultEnvSecretPathsAnnotation: "secret/data/bootstrap,secret/data/app",
+	}, "vault:secret/data/bootstrap#token vault:secret/data/shared/ci#token vault:secret/data/shared/db#password")
+
+	t.Run("exact", func(t *testing.T) {
+		trackedPaths, err := collectSecrets(template, CollectorConfig{ExcludeSecretPaths: []string{"secret/data/bootstrap"}})
+		secretPaths := trackedPathNames(trackedPaths)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/app", "secret/data/shared/ci", "secret/data/shared/db"}, secretPaths)
+	})
+
+	t.Run("regexp", func(t *testing.T) {
+		config := CollectorConfig{ExcludeSecretPathRegexps: []*regexp.Regexp{regexp.MustCompile(`^(?:secret/data/shared/c.*)$`)}}
+		trackedPaths, err := collectSecrets(template, config)
+		secretPaths := trackedPathNames(trackedPaths)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/app", "secret/data/bootstrap", "secret/data/shared/db"}, secretPaths)
+	})
+
+	t.Run("exact and regexp", func(t *testing.T) {
+		config := CollectorConfig{
+			ExcludeSecretPaths:       []string{"secret/data/bootstrap"},
+			ExcludeSecretPathRegexps: []*regexp.Regexp{regexp.MustCompile(`^(?:secret/data/shared/.*)$`)},
+		}
+		trackedPaths, err := collectSecrets(template, config)
+		secretPaths := trackedPathNames(trackedPaths)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/app"}, secretPaths)
+	})
+}
+
+func TestCollectSecretsAllowedMounts(t *testing.T) {
+	template := newTestPodTemplate(map[string]string{
+		VaultEnvSecretPathsAnnotation: "platform/data/ci,team/kv/data/api",
+	}, "vault:secret/data/app#password vault:other/data/db#password vault:secretive/data/x#key")
+	config := CollectorConfig{AllowedMounts: []string{"secret", "platform/", "team/kv"}}
+
+	trackedPaths, err := collectSecrets(template, config)
+	assert.Equal(t, []string{"platform/data/ci", "secret/data/app", "team/kv/data/api"}, trackedPathNames(trackedPaths))
+
+	var mountErr ErrMountNotAllowed
+	assert.ErrorAs(t, err, &mountErr)
+	assert.ErrorContains(t, err, "Vault secret path other/data/db is not in an allowed mount")
+	assert.ErrorContains(t, err, "Vault secret path secretive/data/x is not in an allowed mount")
+
+	t.Run("all mounts allowed by default", func(t *testing.T) {
+		trackedPaths, err := collectSecrets(template, CollectorConfig{})
+		assert.NoError(t, err)
+		assert.Len(t, trackedPaths, 5)
+	})
+}
+
+func TestCollectSecretsPathVariables(t *testing.T) {
+	template := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"},
+		"vault:secret/data/${ENV}/db#password vault:secret/data/${REGION}/${ENV}/cache#password vault:secret/data/${TEAM}/api#token")
+
+	t.Run("resolved placeholders", func(t *testing.T) {
+		config := CollectorConfig{PathVariables: map[string]string{"ENV": "prod", "REGION": "eu", "TEAM": "payments"}}
+		trackedPaths, err := collectSecrets(template, config)
+		secretPaths := trackedPathNames(trackedPaths)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/eu/prod/cache", "secret/data/payments/api", "secret/data/prod/db"}, secretPaths)
+	})
+
+	t.Run("unresolved placeholders are skipped", func(t *testing.T) {
+		config := CollectorConfig{PathVariables: map[string]string{"ENV": "prod"}}
+		trackedPaths, err := collectSecrets(template, config)
+		secretPaths := trackedPathNames(trackedPaths)
+		assert.Equal(t, []string{"secret/data/prod/db"}, secretPaths)
+		assert.ErrorIs(t, err, ErrUnresolvedPlaceholder{secretPath: "secret/data/${REGION}/${ENV}/cache", name: "REGION"})
+		assert.ErrorIs(t, err, ErrUnresolvedPlaceholder{secretPath: "secret/data/${TEAM}/api", name: "TEAM"})
+
+		// The resolved paths of the workload are still tracked
+		controller := newTestController(nil)
+		controller.collectorConfig = config
+		app := workload{name: "app", namespace: "default", kind: DeploymentKind}
+		controller.collectWorkloadSecrets(app, nil, template)
+		assert.Equal(t, []string{"secret/data/prod/db"}, controller.workloadSecrets.GetWorkloadSecretsMap()[app])
+	})
+
+	t.Run("environment variables", func(t *testing.T) {
+		t.Setenv("REGION", "us")
+		t.Setenv("TEAM", "orders")
+		config := CollectorConfig{PathVariables: map[string]string{"ENV": "dev", "TEAM": "payments"}, PathVariablesFromEnv: true}
+		trackedPaths, err := collectSecrets(template, config)
+		secretPaths := trackedPathNames(trackedPaths)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/dev/db", "secret/data/payments/api", "secret/data/us/dev/cache"}, secretPaths)
+	})
+}
+
+func TestCollectSecretsFromContainerEnvVars(t *testing.T) {
+	t.Run("prefixes and whitespace", func(t *testing.T) {
+		containers := []corev1.Container{
+			{
+				Name: "container1",
+				Env: []corev1.EnvVar{
+					{
+						Name:  "AWS_SECRET_ACCESS_KEY",
+						Value: "vault:secret/data/accounts/aws#AWS_SECRET_ACCESS_KEY",
+					},
+					// the >> prefix is accepted by the webhook as well
+					{
+						Name:  "DB_PASSWORD",
+						Value: ">>vault:secret/data/db#password",
+					},
+					// leading and trailing whitespace should be ignored
+					{
+						Name:  "MYSQL_PASSWORD",
+						Value: "  vault:secret/data/mysql#password\n",
+					},
+					{
+						Name:  "REDIS_PASSWORD",
+						Value: " >>vault:secret/data/redis#password ",
+					},
+				},
+			},
+		}
+
+		secretPaths, err := collectSecretsFromContainerEnvVars(containers, CollectorConfig{})
+		assert.NoError(t, err)
+		assert.Equal(t,
+			[]string{"secret/data/accounts/aws", "secret/data/db", "secret/data/mysql", "secret/data/redis"},
+			secretPaths,
+		)
+	})
+
+	t.Run("multiple references in one value", func(t *testing.T) {
+		containers := []corev1.Container{
+			{
+				Name: "container1",
+				Env: []corev1.EnvVar{
+					{
+						Name:  "CONNECTION",
+						Value: "vault:secret/data/a#foo vault:secret/data/b#bar",
+					},
+					// the versioned reference should be ignored, the other one kept
+					{
+						Name:  "MIXED",
+						Value: "vault:secret/data/c#baz#2 >>vault:secret/data/d#qux",
+					},
+				},
+			},
+		}
+
+		secretPaths, err := collectSecretsFromContainerEnvVars(containers, CollectorConfig{})
+		assert.NoError(t, err)
+		assert.Equal(t,
+			[]string{"secret/data/a", "secret/data/b", "secret/data/d"},
+			secretPaths,
+		)
+	})
+
+	t.Run("options modifiers", func(t *testing.T) {
+		containers := []corev1.Container{
+			{
+				Name: "container1",
+				Env: []corev1.EnvVar{
+					{
+						Name:  "API_TOKEN",
+						Value: ">>vault:secret/data/api#token?opt=val",
+					},
+					{
+						Name:  "DB_PASSWORD",
+						Value: "vault:secret/data/db#password?opt=val&other=1",
+					},
+					// the versioned reference should still be ignored
+					{
+						Name:  "CACHE_PASSWORD",
+						Value: "vault:secret/data/cache#password#2?opt=val",
+					},
+				},
+			},
+		}
+
+		secretPaths, err := collectSecretsFromContainerEnvVars(containers, CollectorConfig{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/api", "secret/data/db"}, secretPaths)
+	})
+}
+
+func TestParseVaultRef(t *testing.T) {
+	t.Run("unversioned", func(t *testing.T) {
+		ref := parseVaultRef("secret/data/mysql#${.MYSQL_PASSWORD}", defaultSecretDelimiter)
+		assert.Equal(t, vaultRef{Path: "secret/data/mysql", Key: "${.MYSQL_PASSWORD}"}, ref)
+		assert.True(t, ref.unversioned())
+	})
+
+	t.Run("versioned", func(t *testing.T) {
+		ref := parseVaultRef("secret/data/dockerrepo#${.DOCKER_REPO_PASSWORD}#1", defaultSecretDelimiter)
+		assert.Equal(t, vaultRef{Path: "secret/data/dockerrepo", Key: "${.DOCKER_REPO_PASSWORD}", Version: "1"}, ref)
+		assert.False(t, ref.unversioned())
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		assert.Equal(t, vaultRef{Path: "secret/data/accounts/azure"}, parseVaultRef("secret/data/accounts/azure", defaultSecretDelimiter))
+	})
+
+	t.Run("key containing a # character", func(t *testing.T) {
+		ref := parseVaultRef("secret/data/db#pass#word", defaultSecretDelimiter)
+		assert.Equal(t, vaultRef{Path: "secret/data/db", Key: "pass#word"}, ref)
+		assert.True(t, ref.unversioned())
+
+		ref = parseVaultRef("secret/data/db#pass#word#3", defaultSecretDelimiter)
+		assert.Equal(t, vaultRef{Path: "secret/data/db", Key: "pass#word", Version: "3"}, ref)
+		assert.False(t, ref.unversioned())
+	})
+
+	t.Run("key with encoded characters", func(t *testing.T) {
+		ref := parseVaultRef("secret/data/db#pass%23word%20%3D", defaultSecretDelimiter)
+		assert.Equal(t, vaultRef{Path: "secret/data/db", Key: "pass%23word%20%3D"}, ref)
+		assert.True(t, ref.unversioned())
+
+		ref = parseVaultRef("secret/data/db#pass%23word#12", defaultSecretDelimiter)
+		assert.Equal(t, vaultRef{Path: "secret/data/db", Key: "pass%23word", Version: "12"}, ref)
+		assert.False(t, ref.unversioned())
+	})
+
+	t.Run("options modifiers", func(t *testing.T) {
+		ref := parseVaultRef("secret/data/x#k?opt=val", defaultSecretDelimiter)
+		assert.Equal(t, vaultRef{Path: "secret/data/x", Key: "k", Options: "opt=val"}, ref)
+		assert.True(t, ref.unversioned())
+
+		ref = parseVaultRef("secret/data/x#k#3?opt=val", defaultSecretDelimiter)
+		assert.Equal(t, vaultRef{Path: "secret/data/x", Key: "k", Version: "3", Options: "opt=val"}, ref)
+		assert.False(t, ref.unversioned())
+
+		ref = parseVaultRef("secret/data/x?opt=val", defaultSecretDelimiter)
+		assert.Equal(t, vaultRef{Path: "secret/data/x", Options: "opt=val"}, ref)
+	})
+}
+
+func TestCollectMalformedVaultRefs(t *testing.T) {
+	for _, value := range []string{
+		"vault:",
+		"vault:#",
+		"vault:##",
+		"vault:#key",
+		"vault:/#key",
+		">>vault:",
+		"vault:secret/data/db#",
+		"vault:secret/data/db#?opt=val",
+		"vault:?opt=val",
+	} {
+		t.Run(value, func(t *testing.T) {
+			var secretPaths []string
+			var err error
+			assert.NotPanics(t, func() {
+				secretPaths, err = collectSecretsFromValue(value, CollectorConfig{})
+			})
+			assert.Empty(t, secretPaths)
+
+			var malformedErr ErrMalformedVaultRef
+			assert.ErrorAs(t, err, &malformedErr)
+		})
+	}
+
+	t.Run("valid references are still collected", func(t *testing.T) {
+		template := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+		template.Spec.Containers[0].Env = append(template.Spec.Containers[0].Env, corev1.EnvVar{Name: "EMPTY", Value: "vault:"})
+		template.Spec.Containers[0].Args = []string{"--token=vault:#", "--key=vault:secret/data/api#key"}
+
+		trackedPaths, err := collectSecrets(template, CollectorConfig{})
+		secretPaths := trackedPathNames(trackedPaths)
+		assert.Equal(t, []string{"secret/data/api", "secret/data/app"}, secretPaths)
+		assert.ErrorAs(t, err, &ErrMalformedVaultRef{})
+
+		deployment := workload{name: "app", namespace: "default", kind: DeploymentKind}
+		controller := newTestController(nil)
+		assert.NotPanics(t, func() {
+			controller.collectWorkloadSecrets(deployment, nil, template)
+		})
+		assert.Equal(t,
+			map[workload][]string{deployment: {"secret/data/api", "secret/data/app"}},
+			controller.workloadSecrets.GetWorkloadSecretsMap(),
+		)
+	})
+}
+
+func TestCustomSecretDelimiter(t *testing.T) {
+	t.Run("parse", func(t *testing.T) {
+		ref := parseVaultRef("secret/data/mysql~password", "~")
+		assert.Equal(t, vaultRef{Path: "secret/data/mysql", Key: "password"}, ref)
+		assert.True(t, ref.unversioned())
+
+		ref = parseVaultRef("secret/data/mysql~password~2", "~")
+		assert.Equal(t, vaultRef{Path: "secret/data/mysql", Key: "password", Version: "2"}, ref)
+		assert.False(t, ref.unversioned())
+
+		ref = parseVaultRef("secret/data/mysql%%pass#word%%3", "%%")
+		assert.Equal(t, vaultRef{Path: "secret/data/mysql", Key: "pass#word", Version: "3"}, ref)
+	})
+
+	t.Run("collect", func(t *testing.T) {
+		template := corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					VaultEnvSecretPathsAnnotation: "secret/data/foo,secret/data/bar~1",
+				},
+			},
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name: "app",
+						Env: []corev1.EnvVar{
+							{Name: "PASSWORD", Value: "vault:secret/data/mysql~password"},
+							{Name: "TOKEN", Value: "vault:secret/data/api~token~3"},
+							// Without the delimiter there is no key
+							{Name: "HASH", Value: "vault:secret/data/hash#key"},
+						},
+						Args: []string{"--cert=vault:secret/data/tls~cert"},
+					},
+				},
+			},
+		}
+
+		trackedPaths, err := collectSecrets(template, CollectorConfig{SecretDelimiter: "~"})
+		secretPaths := trackedPathNames(trackedPaths)
+		assert.NoError(t, err)
+		assert.Equal(t,
+			[]string{"secret/data/foo", "secret/data/mysql", "secret/data/tls"},
+			secretPaths,
+		)
+	})
+}
+
+func TestVersionSeparators(t *testing.T) {
+	t.Run("parse", func(t *testing.T) {
+		ref := parseVaultRef("secret/data/x#k@2", defaultSecretDelimiter, defaultSecretDelimiter, defaultVersionSeparator)
+		assert.Equal(t, vaultRef{Path: "secret/data/x", Key: "k", Version: "2"}, ref)
+		assert.False(t, ref.unversioned())
+
+		ref = parseVaultRef("secret/data/x#k#2", defaultSecretDelimiter, defaultSecretDelimiter, defaultVersionSeparator)
+		assert.Equal(t, vaultRef{Path: "secret/data/x", Key: "k", Version: "2"}, ref)
+		assert.False(t, ref.unversioned())
+
+		// Only a numeric version is split off the key
+		ref = parseVaultRef("secret/data/x#admin@example.com", defaultSecretDelimiter, defaultSecretDelimiter, defaultVersionSeparator)
+		assert.Equal(t, vaultRef{Path: "secret/data/x", Key: "admin@example.com"}, ref)
+		assert.True(t, ref.unversioned())
+	})
+
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				VaultEnvSecretPathsAnnotation: "secret/data/foo,secret/data/bar#1,secret/data/baz@2",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "app",
+					Env: []corev1.EnvVar{
+						{Name: "TRACKED", Value: "vault:secret/data/x#k"},
+						{Name: "PINNED_AT", Value: "vault:secret/data/pinned-at#k@2"},
+						{Name: "PINNED_HASH", Value: "vault:secret/data/pinned-hash#k#2"},
+					},
+				},
+			},
+		},
+	}
+
+	t.Run("default", func(t *testing.T) {
+		trackedPaths, err := collectSecrets(template, CollectorConfig{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/foo", "secret/data/x"}, trackedPathNames(trackedPaths))
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		// Without the @ syntax, k@2 is the key of a tracked secret
+		trackedPaths, err := collectSecrets(template, CollectorConfig{VersionSeparators: []string{"#"}})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/baz@2", "secret/data/foo", "secret/data/pinned-at", "secret/data/x"}, trackedPathNames(trackedPaths))
+	})
+}
+
+func TestCollectSecretsFromAnnotations(t *testing.T) {
+	annotations := map[string]string{
+		VaultEnvSecretPathsAnnotation:                    "secret/data/foo,secret/data/bar#1",
+		"vault.security.example.com/vault-env-from-path": "secret/data/baz",
+	}
+
+	t.Run("default annotation", func(t *testing.T) {
+		secretPaths, err := collectSecretsFromAnnotations(annotations, CollectorConfig{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/foo"}, secretPaths)
+	})
+
+	t.Run("custom annotation", func(t *testing.T) {
+		config := CollectorConfig{SecretPathsAnnotation: "vault.security.example.com/vault-env-from-path"}
+		secretPaths, err := collectSecretsFromAnnotations(annotations, config)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/baz"}, secretPaths)
+	})
+
+	t.Run("spaces after the commas", func(t *testing.T) {
+		spaced := map[string]string{VaultEnvSecretPathsAnnotation: "secret/data/foo, secret/data/bar ,  secret/data/App"}
+		secretPaths, err := collectSecretsFromAnnotations(spaced, CollectorConfig{})
+		assert.NoError(t, err)
+		// Vault paths are case-sensitive, so the case is kept
+		assert.Equal(t, []string{"secret/data/App", "secret/data/bar", "secret/data/foo"}, secretPaths)
+	})
+
+	t.Run("extra annotations", func(t *testing.T) {
+		config := CollectorConfig{ExtraSecretPathsAnnotations: []string{
+			"vault.security.example.com/vault-env-from-path",
+			"secrets.example.com/vault-paths",
+		}}
+		template := newTestPodTemplate(map[string]string{
+			SecretReloadAnnotationName:                       "true",
+			VaultEnvSecretPathsAnnotation:                    "secret/data/foo",
+			"vault.security.example.com/vault-env-from-path": "secret/data/baz,secret/data/shared",
+			"secrets.example.com/vault-paths":                "secret/data/shared,secret/data/qux,secret/data/pinned#2",
+			"secrets.example.com/other":                      "secret/data/ignored",
+		}, "")
+
+		controller := newTestController(nil)
+		controller.collectorConfig = config
+		app := workload{name: "app", namespace: "default", kind: DeploymentKind}
+		controller.collectWorkloadSecrets(app, nil, template)
+		assert.Equal(t,
+			[]string{"secret/data/baz", "secret/data/foo", "secret/data/qux", "secret/data/shared"},
+			controller.workloadSecrets.GetWorkloadSecretsMap()[app],
+		)
+	})
+
+	t.Run("volume annotations", func(t *testing.T) {
+		template := newTestPodTemplate(map[string]string{
+			VaultEnvSecretPathsAnnotation:              "secret/data/foo",
+			VaultVolumeSecretPathsAnnotation:           "secret/data/agent",
+			VaultVolumeSecretPathsAnnotation + "-tls":  "secret/data/tls,secret/data/ca#2",
+			VaultVolumeSecretPathsAnnotation + "-conf": "secret/data/config",
+			"vault.security.banzaicloud.io/vault-role": "app",
+		}, "")
+		template.Spec.Volumes = []corev1.Volume{{Name: "tls"}, {Name: "conf"}}
+
+		trackedPaths, err := collectSecrets(template, CollectorConfig{})
+		secretPaths := trackedPathNames(trackedPaths)
+		assert.NoError(t, err)
+		assert.Equal(t,
+			[]string{"secret/data/agent", "secret/data/config", "secret/data/foo", "secret/data/tls"},
+			secretPaths,
+		)
+	})
+}
+
+func TestCollectWorkloadSecrets(t *testing.T) {
+	deployment := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	optedIn := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	notOptedIn := newTestPodTemplate(nil, "vault:secret/data/app#password")
+
+	t.Run("default off", func(t *testing.T) {
+		controller := newTestController(nil)
+
+		controller.collectWorkloadSecrets(deployment, nil, notOptedIn)
+		assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+
+		controller.collectWorkloadSecrets(deployment, nil, optedIn)
+		assert.Equal(t,
+			map[workload][]string{deployment: {"secret/data/app"}},
+			controller.workloadSecrets.GetWorkloadSecretsMap(),
+		)
+
+		// removing the annotation drops the workload from the store
+		controller.collectWorkloadSecrets(deployment, nil, notOptedIn)
+		assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+	})
+
+	t.Run("default on", func(t *testing.T) {
+		controller := newTestController(nil)
+		controller.collectorConfig.ReloadByDefault = true
+
+		controller.collectWorkloadSecrets(deployment, nil, notOptedIn)
+		assert.Equal(t,
+			map[workload][]string{deployment: {"secret/data/app"}},
+			controller.workloadSecrets.GetWorkloadSecretsMap(),
+		)
+
+		controller.collectWorkloadSecrets(deployment, nil, optedIn)
+		assert.Equal(t,
+			map[workload][]string{deployment: {"secret/data/app"}},
+			controller.workloadSecrets.GetWorkloadSecretsMap(),
+		)
+	})
+
+	optedOut := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "false"}, "vault:secret/data/app#password")
+	for _, reloadByDefault := range []bool{false, true} {
+		t.Run(fmt.Sprintf("explicit opt-out with default %t", reloadByDefault), func(t *testing.T) {
+			controller := newTestController(nil)
+			controller.collectorConfig.ReloadByDefault = reloadByDefault
+
+			controller.collectWorkloadSecrets(deployment, nil, optedIn)
+			assert.Len(t, controller.workloadSecrets.GetWorkloadSecretsMap(), 1)
+
+			// opting out drops the workload from the store and keeps it out
+			controller.collectWorkloadSecrets(deployment, nil, optedOut)
+			assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+			controller.collectWorkloadSecrets(deployment, nil, optedOut)
+			assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+		})
+	}
+}
+
+func TestNamespaceFiltering(t *testing.T) {
+	t.Run("include only", func(t *testing.T) {
+		config := CollectorConfig{IncludeNamespaces: []string{"payments"}}
+		assert.True(t, config.namespaceAllowed("payments"))
+		assert.False(t, config.namespaceAllowed("default"))
+	})
+
+	t.Run("exclude only", func(t *testing.T) {
+		config := CollectorConfig{ExcludeNamespaces: []string{"kube-system"}}
+		assert.True(t, config.namespaceAllowed("default"))
+		assert.False(t, config.namespaceAllowed("kube-system"))
+	})
+
+	t.Run("exclude takes precedence", func(t *testing.T) {
+		config := CollectorConfig{
+			IncludeNamespaces: []string{"payments", "orders"},
+			ExcludeNamespaces: []string{"payments"},
+		}
+		assert.False(t, config.namespaceAllowed("payments"))
+		assert.True(t, config.namespaceAllowed("orders"))
+	})
+
+	t.Run("excluded workloads are removed from the store", func(t *testing.T) {
+		controller := newTestController(nil)
+		deployment := workload{name: "app", namespace: "payments", kind: DeploymentKind}
+		template := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+
+		controller.collectWorkloadSecrets(deployment, nil, template)
+		assert.Len(t, controller.workloadSecrets.GetWorkloadSecretsMap(), 1)
+
+		controller.collectorConfig.ExcludeNamespaces = []string{"payments"}
+		controller.collectWorkloadSecrets(deployment, nil, template)
+		assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+	})
+}
+
+func TestWorkloadKindFiltering(t *testing.T) {
+	template := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Template: template},
+	}
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+		Spec:       appsv1.StatefulSetSpec{Template: template},
+	}
+	deploymentWorkload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	statefulSetWorkload := workload{name: "db", namespace: "default", kind: StatefulSetKind}
+
+	t.Run("only enabled kinds are collected", func(t *testing.T) {
+		controller := newTestController(nil)
+		controller.collectorConfig.EnabledWorkloadKinds = []string{DeploymentKind}
+
+		controller.handleObject(deployment)
+		controller.handleObject(statefulSet)
+		assert.Equal(t,
+			map[workload][]string{deploymentWorkload: {"secret/data/app"}},
+			controller.workloadSecrets.GetWorkloadSecretsMap(),
+		)
+	})
+
+	t.Run("only enabled kinds are reloaded", func(t *testing.T) {
+		kubeClient := fake.NewSimpleClientset(deployment, statefulSet)
+		controller := newTestController(kubeClient)
+		controller.collectorConfig.EnabledWorkloadKinds = []string{DeploymentKind}
+
+		assert.NoError(t, controller.triggerReload(context.Background(), deploymentWorkload, []string{"secret/data/app"}))
+		assert.NoError(t, controller.triggerReload(context.Background(), statefulSetWorkload, []string{"secret/data/app"}))
+
+		reloadedDeployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "1", reloadedDeployment.Spec.Template.Annotations[ReloadCountAnnotationName])
+		reloadedStatefulSet, err := kubeClient.AppsV1().StatefulSets("default").Get(context.Background(), "db", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, reloadedStatefulSet.Spec.Template.Annotations[ReloadCountAnnotationName])
+	})
+
+	t.Run("all kinds are enabled by default", func(t *testing.T) {
+		assert.True(t, CollectorConfig{}.kindEnabled(StatefulSetKind))
+	})
+}
+
+func TestLabelSelectorFiltering(t *testing.T) {
+	selector, err := labels.Parse("team=payments")
+	assert.NoError(t, err)
+
+	deployment := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	template := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+
+	t.Run("matching labels", func(t *testing.T) {
+		controller := newTestController(nil)
+		controller.collectorConfig.WorkloadLabelSelector = selector
+
+		controller.collectWorkloadSecrets(deployment, map[string]string{"team": "payments", "app": "api"}, template)
+		assert.Len(t, controller.workloadSecrets.GetWorkloadSecretsMap(), 1)
+	})
+
+	t.Run("non-matching labels", func(t *testing.T) {
+		controller := newTestController(nil)
+		controller.collectorConfig.WorkloadLabelSelector = selector
+
+		controller.collectWorkloadSecrets(deployment, map[string]string{"team": "orders"}, template)
+		assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+	})
+
+	t.Run("removal on label change", func(t *testing.T) {
+		controller := newTestController(nil)
+		controller.collectorConfig.WorkloadLabelSelector = selector
+
+		controller.collectWorkloadSecrets(deployment, map[string]string{"team": "payments"}, template)
+		assert.Len(t, controller.workloadSecrets.GetWorkloadSecretsMap(), 1)
+
+		controller.collectWorkloadSecrets(deployment, nil, template)
+		assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+	})
+}
+
+func TestCollectSecretsFromContainerArgs(t *testing.T) {
+	t.Run("args", func(t *testing.T) {
+		containers := []corev1.Container{
+			{
+				Name: "app",
+				Args: []string{"--verbose", "--password=vault:secret/data/db#pw", "vault:secret/data/api#token"},
+			},
+		}
+		secretPaths, err := collectSecretsFromContainerArgs(containers, CollectorConfig{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/db", "secret/data/api"}, secretPaths)
+	})
+
+	t.Run("command", func(t *testing.T) {
+		containers := []corev1.Container{
+			{
+				Name: "app",
+				// the versioned reference should be ignored
+				Command: []string{"/app", "-token", ">>vault:secret/data/api#token", "-key=vault:secret/data/key#key#2"},
+			},
+		}
+		secretPaths, err := collectSecretsFromContainerArgs(containers, CollectorConfig{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/api"}, secretPaths)
+	})
+
+	t.Run("mixed with env vars", func(t *testing.T) {
+		template := corev1.PodTemplateSpec{
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{
+						Name:    "app",
+						Command: []string{"/app", "--db-password=vault:secret/data/db#pw"},
+						Args:    []string{"--api-token=vault:secret/data/api#token"},
+						Env: []corev1.EnvVar{
+							{
+								Name:  "DB_PASSWORD",
+								Value: "vault:secret/data/db#pw",
+							},
+						},
+					},
+				},
+			},
+		}
+		trackedPaths, err := collectSecrets(template, CollectorConfig{})
+		secretPaths := trackedPathNames(trackedPaths)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/api", "secret/data/db"}, secretPaths)
+	})
+}
+
+func TestCollectSecretsFromEnvFrom(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-config",
+			Namespace: "default",
+		},
+		Data: map[string]string{
+			"DB_PASSWORD": "vault:secret/data/db#password",
+			"LOG_LEVEL":   "info",
+		},
+	}
+	kubeClient := fake.NewSimpleClientset()
+	controller := newTestController(kubeClient)
+	controller.configMapsLister = v1listers.NewConfigMapLister(newTestIndexer(configMap))
+
+	envFrom := []corev1.EnvFromSource{
+		{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app-config"}}},
+		{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}}},
+	}
+	template := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/api#token")
+	template.Spec.Containers[0].EnvFrom = envFrom
+	template.Spec.InitContainers = []corev1.Container{{Name: "init", EnvFrom: envFrom}}
+
+	deployment := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	controller.collectWorkloadSecrets(deployment, nil, template)
+
+	assert.Equal(t,
+		map[workload][]string{deployment: {"secret/data/api", "secret/data/db"}},
+		controller.workloadSecrets.GetWorkloadSecretsMap(),
+	)
+	// ConfigMaps are read from the informer cache
+	assert.Empty(t, kubeClient.Actions())
+}
+
+func TestCollectSecretsFromEnvFromSecrets(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-secrets",
+			Namespace: "default",
+		},
+		Data: map[string][]byte{
+			"DB_PASSWORD": []byte("vault:secret/data/db#password"),
+			"API_TOKEN":   []byte(" vault:secret/data/api#token "),
+			"LOG_LEVEL":   []byte("info"),
+		},
+	}
+	kubeClient := fake.NewSimpleClientset()
+	controller := newTestController(kubeClient)
+	controller.secretsLister = v1listers.NewSecretLister(newTestIndexer(secret))
+
+	envFrom := []corev1.EnvFromSource{
+		{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app-secrets"}}},
+		{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}}},
+	}
+	template := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/env#password")
+	template.Spec.Containers[0].EnvFrom = envFrom
+	template.Spec.InitContainers = []corev1.Container{{Name: "init", EnvFrom: envFrom}}
+
+	deployment := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	controller.collectWorkloadSecrets(deployment, nil, template)
+
+	trackedPaths, ok := controller.workloadSecrets.GetTrackedPaths(deployment)
+	assert.True(t, ok)
+	assert.ElementsMatch(t, []trackedPath{
+		{Path: "secret/data/api", Source: pathSourceSecretRef},
+		{Path: "secret/data/db", Source: pathSourceSecretRef},
+		{Path: "secret/data/env", Source: pathSourceEnv},
+	}, trackedPaths)
+	// Secrets are read from the informer cache
+	assert.Empty(t, kubeClient.Actions())
+}
+
+func TestCollectSecretsNonSecretVaultRefs(t *testing.T) {
+	containers := []corev1.Container{
+		{
+			Name: "app",
+			Env: []corev1.EnvVar{
+				{Name: "VAULT_TOKEN", Value: "vault:login"},
+				{Name: "ENCRYPTED", Value: "vault:v1:8SDd3WHDOjf7mq69CyCqYjBXAiQQAVZRkFM13ok481zoCmHnSeDX9vyf7w=="},
+				{Name: "PASSWORD", Value: "vault:secret/data/x#k"},
+				{Name: "LOGINS", Value: "vault:logins/data/app#k"},
+				{Name: "DECRYPTED", Value: "vault:transit/decrypt/app#plaintext"},
+			},
+		},
+	}
+
+	t.Run("default prefixes", func(t *testing.T) {
+		secretPaths, err := collectSecretsFromContainerEnvVars(containers, CollectorConfig{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/x", "logins/data/app", "transit/decrypt/app"}, secretPaths)
+	})
+
+	t.Run("custom prefixes", func(t *testing.T) {
+		secretPaths, err := collectSecretsFromContainerEnvVars(containers, CollectorConfig{NonSecretVaultPrefixes: []string{"login", "transit/"}})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"secret/data/x", "logins/data/app"}, secretPaths)
+	})
+}
+
+func TestCollectSecretsSources(t *testing.T) {
+	template := newTestPodTemplate(map[string]string{
+		SecretReloadAnnotationName:    "true",
+		VaultEnvSecretPathsAnnotation: "secret/data/shared,secret/data/annotated",
+	}, "vault:secret/data/env#password vault:secret/data/shared#token")
+	template.Spec.Containers[0].Args = []string{"--token=vault:secret/data/args#token"}
+
+	t.Run("pod template", func(t *testing.T) {
+		trackedPaths, err := collectSecrets(template, CollectorConfig{})
+		assert.NoError(t, err)
+		assert.Equal(t, []trackedPath{
+			{Path: "secret/data/annotated", Source: pathSourceAnnotation},
+			{Path: "secret/data/args", Source: pathSourceArgs},
+			{Path: "secret/data/env", Source: pathSourceEnv},
+			{Path: "secret/data/shared", Source: pathSourceAnnotation},
+			{Path: "secret/data/shared", Source: pathSourceEnv},
+		}, trackedPaths)
+		// Paths collected from several sources are listed once
+		assert.Equal(t,
+			[]string{"secret/data/annotated", "secret/data/args", "secret/data/env", "secret/data/shared"},
+			trackedPathNames(trackedPaths),
+		)
+	})
+
+	t.Run("envFrom ConfigMaps", func(t *testing.T) {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-config", Namespace: "default"},
+			Data:       map[string]string{"DB_PASSWORD": "vault:secret/data/db#password"},
+		}
+		controller := newTestController(nil)
+		controller.configMapsLister = v1listers.NewConfigMapLister(newTestIndexer(configMap))
+		template := template.DeepCopy()
+		template.Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{
+			{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app-config"}}},
+		}
+
+		deployment := workload{name: "app", namespace: "default", kind: DeploymentKind}
+		controller.collectWorkloadSecrets(deployment, nil, *template)
+
+		trackedPaths, ok := controller.workloadSecrets.GetTrackedPaths(deployment)
+		assert.True(t, ok)
+		assert.Contains(t, trackedPaths, trackedPath{Path: "secret/data/db", Source: pathSourceConfigMap})
+		assert.Contains(t, trackedPaths, trackedPath{Path: "secret/data/env", Source: pathSourceEnv})
+	})
+
+	t.Run("Secrets", func(t *testing.T) {
+		controller := newTestController(nil)
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-secrets", Namespace: "default"},
+			Data:       map[string][]byte{"secret/data/app": []byte("value")},
+		}
+
+		secretWorkload := workload{name: "app-secrets", namespace: "default", kind: SecretsKind}
+		controller.collectKindSecrets(secretWorkload, secret)
+
+		trackedPaths, ok := controller.workloadSecrets.GetTrackedPaths(secretWorkload)
+		assert.True(t, ok)
+		assert.Equal(t, []trackedPath{{Path: "secret/data/app", Source: pathSourceSecret}}, trackedPaths)
+	})
+
+	t.Run("stored without source", func(t *testing.T) {
+		store := newWorkloadSecrets()
+		app := workload{name: "app", namespace: "default", kind: DeploymentKind}
+		store.Store(app, []string{"secret/data/app"})
+
+		trackedPaths, ok := store.GetTrackedPaths(app)
+		assert.True(t, ok)
+		assert.Equal(t, []trackedPath{{Path: "secret/data/app"}}, trackedPaths)
+	})
+}
+
+func TestCollectVaultNamespacedSecrets(t *testing.T) {
+	controller := newTestController(nil)
+	teamA := workload{name: "app", namespace: "team-a", kind: DeploymentKind}
+	teamB := workload{name: "app", namespace: "team-b", kind: DeploymentKind}
+	root := workload{name: "app", namespace: "default", kind: DeploymentKind}
+
+	controller.collectWorkloadSecrets(teamA, nil, newTestPodTemplate(map[string]string{
+		SecretReloadAnnotationName: "true",
+		VaultNamespaceAnnotation:   "team-a",
+	}, "vault:secret/data/app#password"))
+	controller.collectWorkloadSecrets(teamB, nil, newTestPodTemplate(map[string]string{
+		SecretReloadAnnotationName: "true",
+		VaultNamespaceAnnotation:   "team-b",
+	}, "vault:secret/data/app#password"))
+	controller.collectWorkloadSecrets(root, nil, newTestPodTemplate(map[string]string{
+		SecretReloadAnnotationName: "true",
+	}, "vault:secret/data/app#password"))
+
+	assert.Equal(t, map[string][]workload{
+		"team-a::secret/data/app": {teamA},
+		"team-b::secret/data/app": {teamB},
+		"secret/data/app":         {root},
+	}, controller.workloadSecrets.GetSecretWorkloadsMap())
+}
+
+func TestCollectVaultAddrSecrets(t *testing.T) {
+	controller := newTestController(nil)
+	controller.collectorConfig.AllowedVaultAddrs = []string{"https://vault-eu:8200/"}
+	regional := workload{name: "app", namespace: "eu", kind: DeploymentKind}
+	global := workload{name: "app", namespace: "default", kind: DeploymentKind}
+
+	controller.collectWorkloadSecrets(regional, nil, newTestPodTemplate(map[string]string{
+		SecretReloadAnnotationName: "true",
+		VaultAddrAnnotation:        "https://vault-eu:8200",
+		VaultNamespaceAnnotation:   "team-a",
+	}, "vault:secret/data/app#password"))
+	controller.collectWorkloadSecrets(global, nil, newTestPodTemplate(map[string]string{
+		SecretReloadAnnotationName: "true",
+	}, "vault:secret/data/app#password"))
+
+	assert.Equal(t, map[string][]workload{
+		"https://vault-eu:8200|team-a::secret/data/app": {regional},
+		"secret/data/app": {global},
+	}, controller.workloadSecrets.GetSecretWorkloadsMap())
+
+	vaultAddr, path := splitVaultAddrSecretPath("https://vault-eu:8200|team-a::secret/data/app")
+	assert.Equal(t, "https://vault-eu:8200", vaultAddr)
+	assert.Equal(t, "team-a::secret/data/app", path)
+}
+
+func TestCollectDisallowedVaultAddr(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "https://vault:8200")
+	controller := newTestController(nil)
+	controller.collectorConfig.AllowedVaultAddrs = []string{"https://vault-eu:8200"}
+	var logs bytes.Buffer
+	controller.logger = slog.New(slog.NewTextHandler(&logs, nil))
+	attacker := workload{name: "app", namespace: "untrusted", kind: DeploymentKind}
+	defaultAddr := workload{name: "app", namespace: "default", kind: DeploymentKind}
+
+	controller.workloadSecrets.Store(attacker, []string{"secret/data/app"})
+	controller.collectWorkloadSecrets(attacker, nil, newTestPodTemplate(map[string]string{
+		SecretReloadAnnotationName: "true",
+		VaultAddrAnnotation:        "https://vault.attacker.example:8200",
+	}, "vault:secret/data/app#password"))
+	controller.collectWorkloadSecrets(defaultAddr, nil, newTestPodTemplate(map[string]string{
+		SecretReloadAnnotationName: "true",
+		VaultAddrAnnotation:        "https://vault:8200",
+	}, "vault:secret/data/app#password"))
+
+	// The secrets of the workload setting a disallowed address are not tracked anymore
+	assert.Equal(t, map[string][]workload{
+		"https://vault:8200|secret/data/app": {defaultAddr},
+	}, controller.workloadSecrets.GetSecretWorkloadsMap())
+	assert.Contains(t, logs.String(), "sets Vault address https://vault.attacker.example:8200 that is not allowed")
+
+	// The reloader never logs in to it, even for secret paths restored from the store
+	controller.vaultConfig = &VaultConfig{Addr: "https://vault:8200"}
+	_, err := controller.vaultClientForAddr("https://vault.attacker.example:8200")
+	assert.EqualError(t, err, "Vault address https://vault.attacker.example:8200 is not allowed")
+	assert.Empty(t, controller.vaultClients)
+}
+
+func TestSplitNamespacedSecretPath(t *testing.T) {
+	vaultNamespace, path := splitNamespacedSecretPath(namespacedSecretPath("team-a/child", "secret/data/app"))
+	assert.Equal(t, "team-a/child", vaultNamespace)
+	assert.Equal(t, "secret/data/app", path)
+
+	vaultNamespace, path = splitNamespacedSecretPath("secret/data/app")
+	assert.Equal(t, "", vaultNamespace)
+	assert.Equal(t, "secret/data/app", path)
+}
+
+func TestNormalizeSecretPath(t *testing.T) {
+	template := newTestPodTemplate(map[string]string{
+		VaultEnvSecretPathsAnnotation: "secret/data/foo/,secret//data/foo",
+	}, "vault:secret/data//foo//#password")
+	template.Spec.Containers[0].Args = []string{"--token=vault:secret/data/foo/#token"}
+
+	trackedPaths, err := collectSecrets(template, CollectorConfig{})
+	secretPaths := trackedPathNames(trackedPaths)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"secret/data/foo"}, secretPaths)
+	assert.Equal(t, "secret/data/foo", normalizeSecretPath("secret///data/foo///"))
+	assert.Equal(t, "", normalizeSecretPath("/"))
+}
+
+func TestCollectWildcardSecrets(t *testing.T) {
+	template := newTestPodTemplate(map[string]string{
+		VaultEnvSecretPathsAnnotation: "secret/data/shared/*",
+	}, "vault:secret/data/team/*")
+	template.Spec.Containers[0].Env = append(template.Spec.Containers[0].Env, corev1.EnvVar{Name: "NO_KEY", Value: "vault:secret/data/team"})
+
+	trackedPaths, err := collectSecrets(template, CollectorConfig{})
+	secretPaths := trackedPathNames(trackedPaths)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"secret/data/shared/*", "secret/data/team/*"}, secretPaths)
+}
+
+func TestWorkloadSecretsVersions(t *testing.T) {
+	store := newWorkloadSecrets()
+
+	_, ok := store.GetVersion("secret/data/app")
+	assert.False(t, ok)
+
+	store.SetVersion("secret/data/app", 1)
+	store.SetVersion("secret/data/db", 3)
+	version, ok := store.GetVersion("secret/data/app")
+	assert.True(t, ok)
+	assert.Equal(t, 1, version)
+
+	store.SetVersion("secret/data/app", 2)
+	version, _ = store.GetVersion("secret/data/app")
+	assert.Equal(t, 2, version)
+
+	store.PruneVersions([]string{"secret/data/db"})
+	_, ok = store.GetVersion("secret/data/app")
+	assert.False(t, ok)
+	version, ok = store.GetVersion("secret/data/db")
+	assert.True(t, ok)
+	assert.Equal(t, 3, version)
+}
+
+func TestCollectSecretRefs(t *testing.T) {
+	template := newTestPodTemplate(nil, "plain")
+	template.Spec.Volumes = []corev1.Volume{
+		{Name: "tls", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "tls"}}},
+		{Name: "projected", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+			Sources: []corev1.VolumeProjection{
+				{Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "certs"}}},
+				{ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}}},
+			},
+		}}},
+	}
+	template.Spec.Containers[0].Env = append(template.Spec.Containers[0].Env, corev1.EnvVar{
+		Name: "PASSWORD",
+		ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "db"},
+			Key:                  "password",
+		}},
+	})
+	template.Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{
+		{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}}},
+		{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}}},
+	}
+
+	assert.Equal(t, []workload{
+		{name: "certs", namespace: "default", kind: SecretsKind},
+		{name: "db", namespace: "default", kind: SecretsKind},
+		{name: "tls", namespace: "default", kind: SecretsKind},
+	}, collectSecretRefs("default", template))
+}
+
+func TestAnnotationFiltering(t *testing.T) {
+	const stakaterAnnotation = "reloader.stakater.com/auto"
+	template := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	deploymentWorkload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+
+	t.Run("excluded annotation on the workload", func(t *testing.T) {
+		controller := newTestController(nil)
+		controller.collectorConfig.ExcludeAnnotations = []string{stakaterAnnotation}
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Spec:       appsv1.DeploymentSpec{Template: template},
+		}
+
+		controller.handleObject(deployment)
+		assert.Len(t, controller.workloadSecrets.GetWorkloadSecretsMap(), 1)
+
+		// annotating the workload for the other reloader drops it from the store
+		deployment.Annotations = map[string]string{stakaterAnnotation: "true"}
+		controller.handleObject(deployment)
+		assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+	})
+
+	t.Run("excluded annotation on the pod template", func(t *testing.T) {
+		controller := newTestController(nil)
+		controller.collectorConfig.ExcludeAnnotations = []string{stakaterAnnotation}
+		excluded := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true", stakaterAnnotation: "false"}, "vault:secret/data/app#password")
+
+		controller.collectWorkloadSecrets(deploymentWorkload, nil, excluded)
+		assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+	})
+
+	t.Run("nothing excluded by default", func(t *testing.T) {
+		controller := newTestController(nil)
+		annotated := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true", stakaterAnnotation: "true"}, "vault:secret/data/app#password")
+
+		controller.collectWorkloadSecrets(deploymentWorkload, nil, annotated)
+		assert.Len(t, controller.workloadSecrets.GetWorkloadSecretsMap(), 1)
+	})
+}
+
+func TestSecretPathLimits(t *testing.T) {
+	deployment := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	template := newTestPodTemplate(map[string]string{
+		SecretReloadAnnotationName:    "true",
+		VaultEnvSecretPathsAnnotation: "secret/data/a,secret/data/b,secret/data/c,secret/data/d",
+	}, "vault:secret/data/env#password")
+
+	t.Run("per annotation", func(t *testing.T) {
+		var logs bytes.Buffer
+		controller := newTestController(nil)
+		controller.logger = slog.New(slog.NewTextHandler(&logs, nil))
+		controller.collectorConfig.MaxPathsPerAnnotation = 2
+
+		controller.collectWorkloadSecrets(deployment, nil, template)
+		assert.Equal(t,
+			map[workload][]string{deployment: {"secret/data/a", "secret/data/b", "secret/data/env"}},
+			controller.workloadSecrets.GetWorkloadSecretsMap(),
+		)
+		assert.Contains(t, logs.String(), "annotation vault.security.banzaicloud.io/vault-env-from-path lists more than 2 Vault secret paths, dropping the rest")
+		assert.Equal(t, float64(1), testutil.ToFloat64(controller.metrics.truncatedSecretPaths.WithLabelValues("default", DeploymentKind, secretPathsLimitAnnotation)))
+	})
+
+	t.Run("per workload", func(t *testing.T) {
+		var logs bytes.Buffer
+		controller := newTestController(nil)
+		controller.logger = slog.New(slog.NewTextHandler(&logs, nil))
+		controller.collectorConfig.MaxPathsPerWorkload = 3
+
+		controller.collectWorkloadSecrets(deployment, nil, template)
+		assert.Equal(t,
+			map[workload][]string{deployment: {"secret/data/a", "secret/data/b", "secret/data/c"}},
+			controller.workloadSecrets.GetWorkloadSecretsMap(),
+		)
+		assert.Contains(t, logs.String(), "uses 5 Vault secret paths, tracking only the first 3 of them")
+		assert.Equal(t, float64(1), testutil.ToFloat64(controller.metrics.truncatedSecretPaths.WithLabelValues("default", DeploymentKind, secretPathsLimitWorkload)))
+	})
+
+	t.Run("per workload counts paths with several sources once", func(t *testing.T) {
+		var logs bytes.Buffer
+		controller := newTestController(nil)
+		controller.logger = slog.New(slog.NewTextHandler(&logs, nil))
+		controller.collectorConfig.MaxPathsPerWorkload = 3
+		// secret/data/c, the last path within the limit, is also used by an env var
+		duplicated := newTestPodTemplate(map[string]string{
+			SecretReloadAnnotationName:    "true",
+			VaultEnvSecretPathsAnnotation: "secret/data/a,secret/data/b,secret/data/c,secret/data/d",
+		}, "vault:secret/data/c#password")
+
+		controller.collectWorkloadSecrets(deployment, nil, duplicated)
+		trackedPaths, ok := controller.workloadSecrets.GetTrackedPaths(deployment)
+		assert.True(t, ok)
+		assert.Equal(t, []trackedPath{
+			{Path: "secret/data/a", Source: pathSourceAnnotation},
+			{Path: "secret/data/b", Source: pathSourceAnnotation},
+			{Path: "secret/data/c", Source: pathSourceAnnotation},
+			{Path: "secret/data/c", Source: pathSourceEnv},
+		}, trackedPaths)
+		assert.Contains(t, logs.String(), "uses 4 Vault secret paths, tracking only the first 3 of them")
+
+		// Paths used from several sources within the limit are not truncated
+		controller.collectorConfig.MaxPathsPerWorkload = 4
+		controller.collectWorkloadSecrets(deployment, nil, duplicated)
+		assert.Len(t, controller.workloadSecrets.GetWorkloadSecretsMap()[deployment], 4)
+		assert.Equal(t, float64(1), testutil.ToFloat64(controller.metrics.truncatedSecretPaths.WithLabelValues("default", DeploymentKind, secretPathsLimitWorkload)))
+	})
+
+	t.Run("unlimited by default", func(t *testing.T) {
+		controller := newTestController(nil)
+
+		controller.collectWorkloadSecrets(deployment, nil, template)
+		assert.Len(t, controller.workloadSecrets.GetWorkloadSecretsMap()[deployment], 5)
+	})
 }
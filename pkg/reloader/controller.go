@@ -15,41 +15,85 @@
 package reloader
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
 	appsinformers "k8s.io/client-go/informers/apps/v1"
+	batchinformers "k8s.io/client-go/informers/batch/v1"
 	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
 	v1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
 	DeploymentKind  = "Deployment"
 	DaemonSetKind   = "DaemonSet"
 	StatefulSetKind = "StatefulSet"
-	SecretsKind 	= "Secrets"
+	ReplicaSetKind  = "ReplicaSet"
+	CronJobKind     = "CronJob"
+	JobKind         = "Job"
+	SecretsKind     = "Secrets"
 
 	SecretReloadAnnotationName = "alpha.vault.security.banzaicloud.io/reload-on-secret-change"
 	ReloadCountAnnotationName  = "alpha.vault.security.banzaicloud.io/secret-reload-count"
+	// SecretVersionsAnnotationName holds a hash of the versions of the changed secrets a
+	// workload was last reloaded for, so that reloading it again for them is a no-op
+	SecretVersionsAnnotationName = "alpha.vault.security.banzaicloud.io/secret-versions"
+	// ReloadStrategyAnnotationName overrides the ReloadStrategy of a workload
+	ReloadStrategyAnnotationName = "alpha.vault.security.banzaicloud.io/reload-strategy"
+	// WatchContainersAnnotationName lists the comma separated names of the containers
+	// whose secrets are collected, all containers are watched if it is not set
+	WatchContainersAnnotationName = "alpha.vault.security.banzaicloud.io/watch-containers"
+	// PinnedPathsAnnotationName lists the comma separated secret paths that are not
+	// tracked for a workload, freezing its reloads when they change
+	PinnedPathsAnnotationName = "alpha.vault.security.banzaicloud.io/pinned-paths"
+	// ReconcileIntervalAnnotationName overrides the interval the secrets of a workload are
+	// checked at, e.g. 30s, it is raised to minReconcileInterval if it is shorter
+	ReconcileIntervalAnnotationName = "alpha.vault.security.banzaicloud.io/reconcile-interval"
 )
 
 // Controller is the controller implementation for Foo resources
 type Controller struct {
-	kubeClient  kubernetes.Interface
-	vaultClient *vaultapi.Client
-	vaultConfig *VaultConfig
-	logger      *slog.Logger
+	kubeClient      kubernetes.Interface
+	recorder        record.EventRecorder
+	vaultClient     *vaultapi.Client
+	vaultConfig     *VaultConfig
+	collectorConfig CollectorConfig
+	reloaderConfig  ReloaderConfig
+	logger          *slog.Logger
+	metrics         *metrics
+	tracer          trace.Tracer
+
+	// vaultAuthenticator logs in the Vault client again once vaultTokenRenewAt is reached,
+	// it is nil if the Vault SDK logs in
+	vaultAuthenticator VaultAuthenticator
+	vaultTokenRenewAt  time.Time
+	// vaultClients holds the clients of the Vault servers set by VaultAddrAnnotation
+	vaultClients map[string]*pooledVaultClient
 
 	deploymentsLister  appslisters.DeploymentLister
 	deploymentsSynced  cache.InformerSynced
@@ -57,41 +101,125 @@ type Controller struct {
 	daemonSetsLister   appslisters.DaemonSetLister
 	statefulSetsLister appslisters.StatefulSetLister
 	statefulSetsSynced cache.InformerSynced
-	secretsLister	   v1listers.SecretLister
-	secretsSynced	   cache.InformerSynced
+	replicaSetsLister  appslisters.ReplicaSetLister
+	replicaSetsSynced  cache.InformerSynced
+	cronJobsLister     batchlisters.CronJobLister
+	cronJobsSynced     cache.InformerSynced
+	jobsLister         batchlisters.JobLister
+	jobsSynced         cache.InformerSynced
+	secretsLister      v1listers.SecretLister
+	secretsSynced      cache.InformerSynced
+	configMapsLister   v1listers.ConfigMapLister
+	configMapsSynced   cache.InformerSynced
+	// dynamicClient, rolloutsStore and rolloutsSynced are only set by WatchArgoRollouts
+	dynamicClient  dynamic.Interface
+	rolloutsStore  cache.Store
+	rolloutsSynced cache.InformerSynced
+	// podsLister and podsSynced are only set by WatchPods
+	podsLister v1listers.PodLister
+	podsSynced cache.InformerSynced
 
 	// workloadSecrets map[Workload][]string
 	workloadSecrets workloadSecretsStore
-	secretVersions  map[string]int
+	// wildcardSecrets holds the secret paths found below the tracked wildcard paths
+	wildcardSecrets map[string][]string
+	// kvMounts caches the KV secrets engine mount of the secret paths
+	kvMounts map[string]KVMount
+	// versionCache serves the versions of the secrets looked up within VersionCacheTTL
+	versionCache *versionCache
+	// intervalChecks holds when the secrets of the workloads of each reconcile interval
+	// were last checked, if namespaces have their own intervals
+	intervalChecks map[time.Duration]time.Time
+	// cachesSynced and vaultAuthenticated make the controller ready
+	cachesSynced       atomic.Bool
+	vaultAuthenticated atomic.Bool
+	// leader is set while this replica holds the leader election Lease
+	leader atomic.Bool
+	// existingCollected is set once the workloads existing on startup were collected
+	existingCollected atomic.Bool
+	// runCtx holds the context of Run, the forced reloads are done under it
+	runCtx atomic.Pointer[context.Context]
+	// deferredReloads holds the workloads whose reload was deferred by the cooldown,
+	// or found changed while standing by
+	deferredReloads reloadQueue
+	// secretReloads holds the consumers of the Secrets whose data changed until the
+	// reloader loop, woken up by secretReloadsReady, reloads them
+	secretReloadsMu    sync.Mutex
+	secretReloads      reloadQueue
+	secretReloadsReady chan struct{}
+	// reloadSemaphore limits the reloads in flight to MaxConcurrentReloads
+	reloadSemaphore chan struct{}
+	// pendingReloads tracks the changed secret paths whose workloads were not reloaded yet
+	pendingReloads *pendingReloads
+	// eventSink receives the reload decisions
+	eventSink EventSink
+	// status records the outcome of the reconcile cycles
+	status reconcileStatus
 }
 
 // NewController returns a new sample controller
 func NewController(
 	logger *slog.Logger,
 	kubeClient kubernetes.Interface,
+	recorder record.EventRecorder,
+	collectorConfig CollectorConfig,
+	reloaderConfig ReloaderConfig,
 	deploymentInformer appsinformers.DeploymentInformer,
 	daemonSetInformer appsinformers.DaemonSetInformer,
 	statefulSetInformer appsinformers.StatefulSetInformer,
-	secretsInformer		coreinformers.SecretInformer,
+	replicaSetInformer appsinformers.ReplicaSetInformer,
+	cronJobInformer batchinformers.CronJobInformer,
+	jobInformer batchinformers.JobInformer,
+	secretsInformer coreinformers.SecretInformer,
+	configMapsInformer coreinformers.ConfigMapInformer,
 ) *Controller {
+	registerer := reloaderConfig.MetricsRegisterer
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	metrics := newMetrics(registerer)
+
 	controller := &Controller{
 		kubeClient:         kubeClient,
+		recorder:           recorder,
+		collectorConfig:    collectorConfig,
+		reloaderConfig:     reloaderConfig,
 		logger:             logger,
+		metrics:            metrics,
+		tracer:             otel.Tracer(tracerName),
 		deploymentsLister:  deploymentInformer.Lister(),
 		deploymentsSynced:  deploymentInformer.Informer().HasSynced,
 		daemonSetsLister:   daemonSetInformer.Lister(),
 		daemonSetsSynced:   daemonSetInformer.Informer().HasSynced,
 		statefulSetsLister: statefulSetInformer.Lister(),
-		statefulSetsSynced: deploymentInformer.Informer().HasSynced,
-		secretsLister:		secretsInformer.Lister(),
+		statefulSetsSynced: statefulSetInformer.Informer().HasSynced,
+		replicaSetsLister:  replicaSetInformer.Lister(),
+		replicaSetsSynced:  replicaSetInformer.Informer().HasSynced,
+		cronJobsLister:     cronJobInformer.Lister(),
+		cronJobsSynced:     cronJobInformer.Informer().HasSynced,
+		jobsLister:         jobInformer.Lister(),
+		jobsSynced:         jobInformer.Informer().HasSynced,
+		secretsLister:      secretsInformer.Lister(),
 		secretsSynced:      secretsInformer.Informer().HasSynced,
-		workloadSecrets:    newWorkloadSecrets(),
-		secretVersions:     make(map[string]int),
+		configMapsLister:   configMapsInformer.Lister(),
+		configMapsSynced:   configMapsInformer.Informer().HasSynced,
+		workloadSecrets:    newInstrumentedWorkloadSecrets(newWorkloadSecrets(), metrics, logger),
+		kvMounts:           make(map[string]KVMount),
+		versionCache:       newVersionCache(reloaderConfig.VersionCacheTTL, reloaderConfig.VersionCacheSize),
+		vaultClients:       make(map[string]*pooledVaultClient),
+		wildcardSecrets:    make(map[string][]string),
+		deferredReloads:    make(reloadQueue),
+		secretReloads:      make(reloadQueue),
+		secretReloadsReady: make(chan struct{}, 1),
+		reloadSemaphore:    newReloadSemaphore(reloaderConfig.MaxConcurrentReloads),
+		pendingReloads:     newPendingReloads(metrics.pendingReloads),
+		eventSink:          NoopEventSink{},
+		intervalChecks:     make(map[time.Duration]time.Time),
 	}
 
 	logger.Info("Setting up event handlers")
 
-	// Set up event handlers for Deployments, DaemonSets, StatefulSets and Secrets
+	// Set up event handlers for Deployments, DaemonSets, StatefulSets, ReplicaSets, CronJobs, Jobs and Secrets
 	_, _ = deploymentInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    controller.handleObject,
 		UpdateFunc: func(old, new interface{}) { controller.handleObject(new) },
@@ -110,37 +238,240 @@ func NewController(
 		DeleteFunc: controller.handleObjectDelete,
 	})
 
-	_, _ = secretsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+	_, _ = replicaSetInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    controller.handleObject,
 		UpdateFunc: func(old, new interface{}) { controller.handleObject(new) },
 		DeleteFunc: controller.handleObjectDelete,
 	})
 
+	_, _ = cronJobInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    controller.handleObject,
+		UpdateFunc: func(old, new interface{}) { controller.handleObject(new) },
+		DeleteFunc: controller.handleObjectDelete,
+	})
+
+	_, _ = jobInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    controller.handleObject,
+		UpdateFunc: func(old, new interface{}) { controller.handleObject(new) },
+		DeleteFunc: controller.handleObjectDelete,
+	})
+
+	_, _ = secretsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			controller.handleObject(new)
+			controller.handleSecretUpdate(old, new)
+		},
+		DeleteFunc: controller.handleObjectDelete,
+	})
+
 	return controller
 }
 
 // Run will set up the event handlers for types we are interested in, as well
 // as syncing informer caches and starting reloader worker. It will block until stopCh
 // is closed, at which point it will wait for the reloader to finish processing.
-func (c *Controller) Run(ctx context.Context, reloaderPeriod time.Duration) error {
+func (c *Controller) Run(ctx context.Context) error {
 	defer utilruntime.HandleCrash()
+	c.runCtx.Store(&ctx)
 
 	// Start the informer factories to begin populating the informer caches
 	c.logger.Info("Starting vault-secrets-reloader controller")
 
+	// Restore data collected by a previous run and keep flushing it
+	if c.collectorConfig.StoreConfigMap != "" {
+		if err := c.restoreStore(ctx); err != nil {
+			c.logger.Error(err.Error())
+		}
+		flushPeriod := c.collectorConfig.StoreFlushPeriod
+		if flushPeriod <= 0 {
+			flushPeriod = DefaultStoreFlushPeriod
+		}
+		go wait.UntilWithContext(ctx, c.flushStore, flushPeriod)
+	}
+
 	// Wait for the caches to be synced before starting reloader
-	c.logger.Info("Waiting for informer caches to sync")
+	if err := c.waitForCacheSync(ctx); err != nil {
+		return err
+	}
+
+	// Collect every existing workload before the first reconcile, instead of relying
+	// on the add events of the informers that may race with it
+	c.resyncWorkloads()
+	c.existingCollected.Store(true)
+	if c.collectorConfig.StoreEvictionPeriod > 0 {
+		go wait.UntilWithContext(ctx, c.evictStaleWorkloads, c.collectorConfig.StoreEvictionPeriod)
+	}
 
-	if !cache.WaitForCacheSync(ctx.Done(), c.deploymentsSynced, c.daemonSetsSynced, c.statefulSetsSynced, c.secretsSynced) {
-		return fmt.Errorf("failed to wait for caches to sync")
+	if c.reloaderConfig.LeaderElection.Enabled {
+		go c.runLeaderElection(ctx)
 	}
 
 	// Launch reloader to reload resources with changed secrets
-	go wait.UntilWithContext(ctx, c.runReloader, reloaderPeriod)
+	reloaderDone := make(chan struct{})
+	if c.reloaderConfig.CollectOnly {
+		c.logger.Info("Collect only mode, not starting the reloader")
+		close(reloaderDone)
+	} else {
+		go func() {
+			defer close(reloaderDone)
+			c.runReloaderLoop(ctx)
+		}()
+	}
 
 	<-ctx.Done()
 	c.logger.Info("Shutting down reloader")
 
+	return c.shutdown(reloaderDone)
+}
+
+// waitForCacheSync waits for the caches of all the watched informers to be synced
+func (c *Controller) waitForCacheSync(ctx context.Context) error {
+	c.logger.Info("Waiting for informer caches to sync")
+
+	cachesSynced := []cache.InformerSynced{c.deploymentsSynced, c.daemonSetsSynced, c.statefulSetsSynced, c.replicaSetsSynced, c.cronJobsSynced, c.jobsSynced, c.secretsSynced, c.configMapsSynced}
+	if c.rolloutsSynced != nil {
+		cachesSynced = append(cachesSynced, c.rolloutsSynced)
+	}
+	if c.podsSynced != nil {
+		cachesSynced = append(cachesSynced, c.podsSynced)
+	}
+	// The informers keep retrying failed lists with a backoff, so an API server briefly
+	// unavailable on startup only delays the sync instead of failing the controller
+	err := c.retryStartup(ctx, "Syncing informer caches", func(ctx context.Context) error {
+		if !cache.WaitForCacheSync(ctx.Done(), cachesSynced...) {
+			return fmt.Errorf("failed to wait for caches to sync")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.cachesSynced.Store(true)
+	return nil
+}
+
+// retryStartup attempts a startup step until it succeeds, StartupSyncAttempts attempts
+// failed or ctx is done, giving each attempt StartupSyncTimeout doubled on every retry.
+// Attempts failing early are retried once their timeout elapsed.
+func (c *Controller) retryStartup(ctx context.Context, step string, attempt func(ctx context.Context) error) error {
+	timeout := c.collectorConfig.StartupSyncTimeout
+	if timeout <= 0 {
+		return attempt(ctx)
+	}
+	maxAttempts := max(c.collectorConfig.StartupSyncAttempts, 1)
+
+	for attempts := 1; ; attempts++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := attempt(attemptCtx)
+		if err == nil || attempts >= maxAttempts || ctx.Err() != nil {
+			cancel()
+			if err != nil && attempts > 1 {
+				err = fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+			}
+			return err
+		}
+
+		c.logger.Warn(fmt.Sprintf("%s failed, retrying: %s", step, err))
+		<-attemptCtx.Done()
+		cancel()
+		timeout *= 2
+	}
+}
+
+// resyncWorkloads collects the secrets of all the workloads in the informer caches
+func (c *Controller) resyncWorkloads() {
+	c.logger.Info("Collecting secrets of existing workloads")
+
+	var objects []interface{}
+	deployments, err := c.deploymentsLister.List(labels.Everything())
+	if err != nil {
+		c.logger.Error(fmt.Errorf("failed to list Deployments: %w", err).Error())
+	}
+	for _, deployment := range deployments {
+		objects = append(objects, deployment)
+	}
+	daemonSets, err := c.daemonSetsLister.List(labels.Everything())
+	if err != nil {
+		c.logger.Error(fmt.Errorf("failed to list DaemonSets: %w", err).Error())
+	}
+	for _, daemonSet := range daemonSets {
+		objects = append(objects, daemonSet)
+	}
+	statefulSets, err := c.statefulSetsLister.List(labels.Everything())
+	if err != nil {
+		c.logger.Error(fmt.Errorf("failed to list StatefulSets: %w", err).Error())
+	}
+	for _, statefulSet := range statefulSets {
+		objects = append(objects, statefulSet)
+	}
+	replicaSets, err := c.replicaSetsLister.List(labels.Everything())
+	if err != nil {
+		c.logger.Error(fmt.Errorf("failed to list ReplicaSets: %w", err).Error())
+	}
+	for _, replicaSet := range replicaSets {
+		objects = append(objects, replicaSet)
+	}
+	cronJobs, err := c.cronJobsLister.List(labels.Everything())
+	if err != nil {
+		c.logger.Error(fmt.Errorf("failed to list CronJobs: %w", err).Error())
+	}
+	for _, cronJob := range cronJobs {
+		objects = append(objects, cronJob)
+	}
+	jobs, err := c.jobsLister.List(labels.Everything())
+	if err != nil {
+		c.logger.Error(fmt.Errorf("failed to list Jobs: %w", err).Error())
+	}
+	for _, job := range jobs {
+		objects = append(objects, job)
+	}
+	secrets, err := c.secretsLister.List(labels.Everything())
+	if err != nil {
+		c.logger.Error(fmt.Errorf("failed to list Secrets: %w", err).Error())
+	}
+	for _, secret := range secrets {
+		objects = append(objects, secret)
+	}
+	if c.rolloutsStore != nil {
+		objects = append(objects, c.rolloutsStore.List()...)
+	}
+	if c.podsLister != nil {
+		pods, err := c.podsLister.List(labels.Everything())
+		if err != nil {
+			c.logger.Error(fmt.Errorf("failed to list Pods: %w", err).Error())
+		}
+		for _, pod := range pods {
+			objects = append(objects, pod)
+		}
+	}
+
+	for _, obj := range objects {
+		c.handleObject(obj)
+	}
+	c.logger.Info(fmt.Sprintf("Collected secrets of %d existing workloads", len(c.workloadSecrets.GetWorkloadSecretsMap())))
+}
+
+// shutdown waits for the reload in progress to finish and flushes the store
+// one last time, giving up after the shutdown timeout
+func (c *Controller) shutdown(reloaderDone <-chan struct{}) error {
+	timeout := c.reloaderConfig.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	select {
+	case <-reloaderDone:
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for in-flight reloads to finish")
+	}
+
+	if c.collectorConfig.StoreConfigMap != "" {
+		c.flushStore(ctx)
+	}
+
 	return nil
 }
 
@@ -164,25 +495,73 @@ func (c *Controller) handleObject(obj interface{}) {
 		workloadData = workload{name: o.Name, namespace: o.Namespace, kind: StatefulSetKind}
 		podTemplateSpec = o.Spec.Template
 
+	case *appsv1.ReplicaSet:
+		// ReplicaSets of a Deployment or Rollout are tracked through their parent
+		if isOwnedByRolloutController(o) {
+			return
+		}
+		workloadData = workload{name: o.Name, namespace: o.Namespace, kind: ReplicaSetKind}
+		podTemplateSpec = o.Spec.Template
+
+	case *batchv1.CronJob:
+		workloadData = workload{name: o.Name, namespace: o.Namespace, kind: CronJobKind}
+		podTemplateSpec = o.Spec.JobTemplate.Spec.Template
+
+	case *batchv1.Job:
+		// Jobs spawned by a CronJob are tracked through their parent
+		if isOwnedByCronJob(o) {
+			return
+		}
+		workloadData = workload{name: o.Name, namespace: o.Namespace, kind: JobKind}
+		podTemplateSpec = o.Spec.Template
+
 	case *corev1.Secret:
 		workloadData = workload{name: o.Name, namespace: o.Namespace, kind: SecretsKind}
 		c.collectKindSecrets(workloadData, o)
+		return
+
+	case *corev1.Pod:
+		// Pods of a collected workload are tracked through it
+		if isControlledByCollectedWorkload(o) {
+			return
+		}
+		workloadData = workload{name: o.Name, namespace: o.Namespace, kind: PodKind}
+		podTemplateSpec = podTemplate(o)
+
+	case *unstructured.Unstructured:
+		if o.GetKind() != RolloutKind {
+			c.logger.Error("error decoding object, invalid type")
+			return
+		}
+		workloadData = workload{name: o.GetName(), namespace: o.GetNamespace(), kind: RolloutKind}
+		template, ok, err := rolloutPodTemplate(o)
+		if err != nil {
+			c.logger.Error(fmt.Errorf("error decoding pod template of Rollout %s/%s: %w", o.GetNamespace(), o.GetName(), err).Error())
+			return
+		}
+		if !ok {
+			// Rollouts referencing a Deployment with workloadRef are reloaded through it
+			c.logger.Debug(fmt.Sprintf("Rollout %s/%s has no pod template", o.GetNamespace(), o.GetName()))
+			return
+		}
+		podTemplateSpec = template
+
 	default:
 		// Unsupported workload
 		c.logger.Error("error decoding object, invalid type")
 		return
 	}
 
-	// Process workload, skip if reload annotation not present
-	if podTemplateSpec.GetAnnotations()[SecretReloadAnnotationName] != "true" {
+	// Workloads managed by another reloader are dropped from the store in case they were collected before
+	if c.collectorConfig.annotationsExcluded(obj.(metav1.Object).GetAnnotations()) {
+		c.workloadSecrets.Delete(workloadData)
 		return
 	}
-	c.logger.Debug(fmt.Sprintf("Processing workload: %#v", workloadData))
-	c.collectWorkloadSecrets(workloadData, podTemplateSpec)
+	c.collectWorkloadSecrets(workloadData, obj.(metav1.Object).GetLabels(), podTemplateSpec)
 }
 
 // handleObjectDelete will take any resource implementing metav1.Object and deletes
-// it from the shared store if it is a workload and has the reload annotation set.
+// it from the shared store if it is a workload.
 func (c *Controller) handleObjectDelete(obj interface{}) {
 	var object metav1.Object
 	var ok bool
@@ -201,33 +580,94 @@ func (c *Controller) handleObjectDelete(obj interface{}) {
 	}
 
 	var workloadData workload
-	var podTemplateSpec corev1.PodTemplateSpec
 	switch o := object.(type) {
 	case *appsv1.Deployment:
 		workloadData = workload{name: o.GetName(), namespace: o.GetNamespace(), kind: DeploymentKind}
-		podTemplateSpec = o.Spec.Template
 
 	case *appsv1.DaemonSet:
 		workloadData = workload{name: o.GetName(), namespace: o.GetNamespace(), kind: DaemonSetKind}
-		podTemplateSpec = o.Spec.Template
 
 	case *appsv1.StatefulSet:
 		workloadData = workload{name: o.GetName(), namespace: o.GetNamespace(), kind: StatefulSetKind}
-		podTemplateSpec = o.Spec.Template
+
+	case *appsv1.ReplicaSet:
+		if isOwnedByRolloutController(o) {
+			return
+		}
+		workloadData = workload{name: o.GetName(), namespace: o.GetNamespace(), kind: ReplicaSetKind}
+
+	case *batchv1.CronJob:
+		workloadData = workload{name: o.GetName(), namespace: o.GetNamespace(), kind: CronJobKind}
+
+	case *batchv1.Job:
+		if isOwnedByCronJob(o) {
+			return
+		}
+		workloadData = workload{name: o.GetName(), namespace: o.GetNamespace(), kind: JobKind}
 
 	case *corev1.Secret:
 		workloadData = workload{name: o.Name, namespace: o.Namespace, kind: SecretsKind}
-		c.collectKindSecrets(workloadData, o)
+
+	case *corev1.Pod:
+		if isControlledByCollectedWorkload(o) {
+			return
+		}
+		workloadData = workload{name: o.Name, namespace: o.Namespace, kind: PodKind}
+
+	case *unstructured.Unstructured:
+		if o.GetKind() != RolloutKind {
+			c.logger.Error("error decoding object, invalid type")
+			return
+		}
+		workloadData = workload{name: o.GetName(), namespace: o.GetNamespace(), kind: RolloutKind}
 
 	default:
 		c.logger.Error("error decoding object, invalid type")
 		return
 	}
 
-	// Delete workload, skip if reload annotation not present
-	if podTemplateSpec.GetAnnotations()[SecretReloadAnnotationName] != "true" {
-		return
-	}
+	// Workloads without reloading enabled are never stored, so deleting them is a no-op
 	c.logger.Debug(fmt.Sprintf("Deleting workload from store: %#v", workloadData))
 	c.workloadSecrets.Delete(workloadData)
 }
+
+// handleSecretUpdate reloads the workloads consuming a watched Secret when its data
+// changes, e.g. after the webhook injected the new version of its Vault secrets
+func (c *Controller) handleSecretUpdate(old, new interface{}) {
+	oldSecret, ok := old.(*corev1.Secret)
+	if !ok {
+		return
+	}
+	newSecret, ok := new.(*corev1.Secret)
+	if !ok {
+		return
+	}
+	if maps.EqualFunc(oldSecret.Data, newSecret.Data, bytes.Equal) {
+		return
+	}
+
+	secret := workload{name: newSecret.Name, namespace: newSecret.Namespace, kind: SecretsKind}
+	secretPaths, ok := c.workloadSecrets.GetSecrets(secret)
+	if !ok || !c.isLeader() {
+		return
+	}
+
+	consumers := c.workloadSecrets.GetSecretConsumersMap()[secret]
+	for _, consumer := range consumers {
+		c.logger.Info(fmt.Sprintf("Data of %s changed, queuing reload of consuming workload: %s", secret, consumer))
+	}
+	c.queueSecretReloads(consumers, secretPaths)
+}
+
+func isOwnedByCronJob(job *batchv1.Job) bool {
+	owner := metav1.GetControllerOf(job)
+	return owner != nil && owner.Kind == CronJobKind
+}
+
+// isOwnedByRolloutController reports whether a ReplicaSet has an owner reference to a
+// Deployment or an Argo Rollout
+func isOwnedByRolloutController(replicaSet *appsv1.ReplicaSet) bool {
+	return slices.ContainsFunc(replicaSet.GetOwnerReferences(), func(owner metav1.OwnerReference) bool {
+		return owner.Kind == DeploymentKind || owner.Kind == RolloutKind
+	})
+}
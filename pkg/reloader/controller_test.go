@@ -0,0 +1,535 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace/noop"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func newTestController(kubeClient kubernetes.Interface) *Controller {
+	metrics := newMetrics(prometheus.NewRegistry())
+	return &Controller{
+		kubeClient:       kubeClient,
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		metrics:          metrics,
+		tracer:           noop.NewTracerProvider().Tracer(tracerName),
+		workloadSecrets:  newWorkloadSecrets(),
+		kvMounts:         make(map[string]KVMount),
+		vaultClients:     make(map[string]*pooledVaultClient),
+		wildcardSecrets:  make(map[string][]string),
+		deferredReloads:  make(map[workload][]string),
+		secretReloads:    make(reloadQueue),
+		reloadSemaphore:  newReloadSemaphore(0),
+		pendingReloads:   newPendingReloads(metrics.pendingReloads),
+		eventSink:        NoopEventSink{},
+		intervalChecks:   make(map[time.Duration]time.Time),
+		secretsLister:    v1listers.NewSecretLister(newTestIndexer()),
+		configMapsLister: v1listers.NewConfigMapLister(newTestIndexer()),
+	}
+}
+
+func newTestIndexer(objects ...runtime.Object) cache.Indexer {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, object := range objects {
+		_ = indexer.Add(object)
+	}
+	return indexer
+}
+
+func newTestPodTemplate(annotations map[string]string, envValue string) corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "app",
+					Env: []corev1.EnvVar{
+						{
+							Name:  "SECRET",
+							Value: envValue,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func newTestDeployment(name string, annotations map[string]string, envValue string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: newTestPodTemplate(annotations, envValue),
+		},
+	}
+}
+
+func TestNewControllerMetricsRegisterer(t *testing.T) {
+	newController := func(registerer prometheus.Registerer) *Controller {
+		informerFactory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+		return NewController(
+			slog.New(slog.NewTextHandler(io.Discard, nil)), fake.NewSimpleClientset(), nil,
+			CollectorConfig{}, ReloaderConfig{MetricsRegisterer: registerer},
+			informerFactory.Apps().V1().Deployments(),
+			informerFactory.Apps().V1().DaemonSets(),
+			informerFactory.Apps().V1().StatefulSets(),
+			informerFactory.Apps().V1().ReplicaSets(),
+			informerFactory.Batch().V1().CronJobs(),
+			informerFactory.Batch().V1().Jobs(),
+			informerFactory.Core().V1().Secrets(),
+			informerFactory.Core().V1().ConfigMaps(),
+		)
+	}
+
+	// Controllers with their own registerer have their own metrics
+	first, second := newController(prometheus.NewRegistry()), newController(prometheus.NewRegistry())
+	assert.NotSame(t, first.metrics.missingSecrets, second.metrics.missingSecrets)
+
+	// Controllers sharing a registerer share the metrics instead of panicking
+	registry := prometheus.NewRegistry()
+	assert.NotPanics(t, func() {
+		first, second = newController(registry), newController(registry)
+	})
+	assert.Same(t, first.metrics.missingSecrets, second.metrics.missingSecrets)
+}
+
+func TestHandleObjectStatefulSet(t *testing.T) {
+	controller := newTestController(nil)
+
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "postgres",
+			Namespace: "db",
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Template: newTestPodTemplate(
+				map[string]string{SecretReloadAnnotationName: "true"},
+				"vault:secret/data/postgres#password",
+			),
+		},
+	}
+
+	controller.handleObject(statefulSet)
+
+	assert.Equal(t,
+		map[workload][]string{
+			{name: "postgres", namespace: "db", kind: StatefulSetKind}: {"secret/data/postgres"},
+		},
+		controller.workloadSecrets.GetWorkloadSecretsMap(),
+	)
+}
+
+func TestHandleObjectReplicaSet(t *testing.T) {
+	controller := newTestController(nil)
+
+	newReplicaSet := func(name string, owners ...metav1.OwnerReference) *appsv1.ReplicaSet {
+		return &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "default",
+				OwnerReferences: owners,
+			},
+			Spec: appsv1.ReplicaSetSpec{
+				Template: newTestPodTemplate(
+					map[string]string{SecretReloadAnnotationName: "true"},
+					"vault:secret/data/app#password",
+				),
+			},
+		}
+	}
+	standalone := newReplicaSet("standalone")
+	owned := newReplicaSet("app-5d8f7b9c4", metav1.OwnerReference{APIVersion: "apps/v1", Kind: DeploymentKind, Name: "app"})
+
+	controller.handleObject(standalone)
+	controller.handleObject(owned)
+
+	// The ReplicaSets of a Deployment are tracked through it
+	assert.Equal(t,
+		map[workload][]string{
+			{name: "standalone", namespace: "default", kind: ReplicaSetKind}: {"secret/data/app"},
+		},
+		controller.workloadSecrets.GetWorkloadSecretsMap(),
+	)
+
+	controller.handleObjectDelete(owned)
+	assert.Len(t, controller.workloadSecrets.GetWorkloadSecretsMap(), 1)
+	controller.handleObjectDelete(standalone)
+	assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+}
+
+func TestHandleObjectDaemonSet(t *testing.T) {
+	controller := newTestController(nil)
+
+	daemonSet := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "node-agent",
+			Namespace: "monitoring",
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Template: newTestPodTemplate(
+				map[string]string{SecretReloadAnnotationName: "true"},
+				"vault:secret/data/agent#token",
+			),
+		},
+	}
+
+	controller.handleObject(daemonSet)
+
+	assert.Equal(t,
+		map[workload][]string{
+			{name: "node-agent", namespace: "monitoring", kind: DaemonSetKind}: {"secret/data/agent"},
+		},
+		controller.workloadSecrets.GetWorkloadSecretsMap(),
+	)
+}
+
+func TestHandleObjectBatchWorkloads(t *testing.T) {
+	annotations := map[string]string{SecretReloadAnnotationName: "true"}
+
+	t.Run("CronJob", func(t *testing.T) {
+		controller := newTestController(nil)
+
+		cronJob := &batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "backup",
+				Namespace: "default",
+			},
+			Spec: batchv1.CronJobSpec{
+				JobTemplate: batchv1.JobTemplateSpec{
+					Spec: batchv1.JobSpec{
+						Template: newTestPodTemplate(annotations, "vault:secret/data/backup#token"),
+					},
+				},
+			},
+		}
+
+		controller.handleObject(cronJob)
+
+		assert.Equal(t,
+			map[workload][]string{
+				{name: "backup", namespace: "default", kind: CronJobKind}: {"secret/data/backup"},
+			},
+			controller.workloadSecrets.GetWorkloadSecretsMap(),
+		)
+	})
+
+	t.Run("Job", func(t *testing.T) {
+		controller := newTestController(nil)
+
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "migrate",
+				Namespace: "default",
+			},
+			Spec: batchv1.JobSpec{
+				Template: newTestPodTemplate(annotations, "vault:secret/data/db#password"),
+			},
+		}
+
+		controller.handleObject(job)
+
+		assert.Equal(t,
+			map[workload][]string{
+				{name: "migrate", namespace: "default", kind: JobKind}: {"secret/data/db"},
+			},
+			controller.workloadSecrets.GetWorkloadSecretsMap(),
+		)
+	})
+
+	t.Run("Job owned by a CronJob", func(t *testing.T) {
+		controller := newTestController(nil)
+
+		isController := true
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "backup-28000000",
+				Namespace: "default",
+				OwnerReferences: []metav1.OwnerReference{
+					{Kind: CronJobKind, Name: "backup", Controller: &isController},
+				},
+			},
+			Spec: batchv1.JobSpec{
+				Template: newTestPodTemplate(annotations, "vault:secret/data/backup#token"),
+			},
+		}
+
+		controller.handleObject(job)
+
+		assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+	})
+}
+
+func TestHandleObjectDelete(t *testing.T) {
+	newDeployment := func(name string, envValue string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: appsv1.DeploymentSpec{
+				Template: newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, envValue),
+			},
+		}
+	}
+	app := newDeployment("app", "vault:secret/data/shared#password vault:secret/data/app#token")
+	worker := newDeployment("worker", "vault:secret/data/shared#password")
+	appWorkload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	workerWorkload := workload{name: "worker", namespace: "default", kind: DeploymentKind}
+
+	controller := newTestController(nil)
+	controller.handleObject(app)
+	controller.handleObject(worker)
+	assert.Equal(t, map[string][]workload{
+		"secret/data/app":    {appWorkload},
+		"secret/data/shared": {appWorkload, workerWorkload},
+	}, sortedSecretWorkloads(controller.workloadSecrets.GetSecretWorkloadsMap()))
+
+	// The secret only used by the deleted workload is not tracked anymore
+	controller.handleObjectDelete(app)
+	assert.NotContains(t, controller.workloadSecrets.GetWorkloadSecretsMap(), appWorkload)
+	assert.Equal(t, map[string][]workload{
+		"secret/data/shared": {workerWorkload},
+	}, controller.workloadSecrets.GetSecretWorkloadsMap())
+
+	// Deletions missed by the informer are recovered from the tombstone
+	controller.handleObjectDelete(cache.DeletedFinalStateUnknown{Key: "default/worker", Obj: worker})
+	assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+	assert.Empty(t, controller.workloadSecrets.GetSecretWorkloadsMap())
+}
+
+func TestHandleSecretUpdate(t *testing.T) {
+	template := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "plain")
+	template.Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{
+		{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app-secrets"}}},
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Template: template},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-secrets", Namespace: "default"},
+		Data:       map[string][]byte{"secret/data/app": []byte("old")},
+	}
+	deploymentWorkload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	secretWorkload := workload{name: "app-secrets", namespace: "default", kind: SecretsKind}
+
+	kubeClient := fake.NewSimpleClientset(deployment, secret)
+	controller := newTestController(kubeClient)
+	controller.handleObject(deployment)
+	controller.handleObject(secret)
+
+	// The Deployment references no Vault secret, but is indexed as a consumer of the Secret
+	assert.NotContains(t, controller.workloadSecrets.GetWorkloadSecretsMap(), deploymentWorkload)
+	assert.Equal(t,
+		map[workload][]workload{secretWorkload: {deploymentWorkload}},
+		controller.workloadSecrets.GetSecretConsumersMap(),
+	)
+
+	reloadCount := func() string {
+		deployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+		assert.NoError(t, err)
+		return deployment.Spec.Template.Annotations[ReloadCountAnnotationName]
+	}
+
+	t.Run("unchanged data", func(t *testing.T) {
+		updated := secret.DeepCopy()
+		updated.Annotations = map[string]string{ReloadCountAnnotationName: "1"}
+		controller.handleSecretUpdate(secret, updated)
+		assert.Empty(t, controller.secretReloads)
+		assert.Zero(t, controller.reloadSecretConsumers(context.Background(), controller.logger))
+		assert.Empty(t, reloadCount())
+	})
+
+	t.Run("changed data", func(t *testing.T) {
+		updated := secret.DeepCopy()
+		updated.Data["secret/data/app"] = []byte("new")
+		controller.handleSecretUpdate(secret, updated)
+		// The reload is queued for the reloader loop instead of running in the handler
+		assert.Equal(t, reloadQueue{deploymentWorkload: {"secret/data/app"}}, controller.secretReloads)
+		assert.Empty(t, reloadCount())
+
+		assert.Equal(t, 1, controller.reloadSecretConsumers(context.Background(), controller.logger))
+		assert.Equal(t, "1", reloadCount())
+		assert.Empty(t, controller.secretReloads)
+	})
+
+	t.Run("within the cooldown", func(t *testing.T) {
+		controller.reloaderConfig.ReloadCooldown = time.Hour
+		defer func() { controller.reloaderConfig.ReloadCooldown = 0 }()
+
+		updated := secret.DeepCopy()
+		updated.Data["secret/data/app"] = []byte("newest")
+		controller.handleSecretUpdate(secret, updated)
+		assert.Zero(t, controller.reloadSecretConsumers(context.Background(), controller.logger))
+		assert.Equal(t, "1", reloadCount())
+		// The reload stays queued until the cooldown is over
+		assert.Equal(t, reloadQueue{deploymentWorkload: {"secret/data/app"}}, controller.secretReloads)
+		controller.secretReloads = make(reloadQueue)
+	})
+
+	t.Run("no more consumers", func(t *testing.T) {
+		controller.handleObjectDelete(deployment)
+		assert.Empty(t, controller.workloadSecrets.GetSecretConsumersMap())
+
+		updated := secret.DeepCopy()
+		updated.Data["secret/data/app"] = []byte("newer")
+		controller.handleSecretUpdate(secret, updated)
+		assert.Zero(t, controller.reloadSecretConsumers(context.Background(), controller.logger))
+		assert.Equal(t, "1", reloadCount())
+	})
+}
+
+func TestResyncWorkloads(t *testing.T) {
+	annotations := map[string]string{SecretReloadAnnotationName: "true"}
+	kubeClient := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Spec:       appsv1.DeploymentSpec{Template: newTestPodTemplate(annotations, "vault:secret/data/app#password")},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+			Spec:       appsv1.StatefulSetSpec{Template: newTestPodTemplate(annotations, "vault:secret/data/db#password")},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "untracked", Namespace: "default"},
+			Spec:       appsv1.DeploymentSpec{Template: newTestPodTemplate(nil, "vault:secret/data/app#password")},
+		},
+	)
+
+	// No event handlers are registered, so only the resync populates the store
+	informerFactory := informers.NewSharedInformerFactory(kubeClient, 0)
+	controller := newTestController(kubeClient)
+	controller.deploymentsLister = informerFactory.Apps().V1().Deployments().Lister()
+	controller.daemonSetsLister = informerFactory.Apps().V1().DaemonSets().Lister()
+	controller.statefulSetsLister = informerFactory.Apps().V1().StatefulSets().Lister()
+	controller.replicaSetsLister = informerFactory.Apps().V1().ReplicaSets().Lister()
+	controller.cronJobsLister = informerFactory.Batch().V1().CronJobs().Lister()
+	controller.jobsLister = informerFactory.Batch().V1().Jobs().Lister()
+	controller.secretsLister = informerFactory.Core().V1().Secrets().Lister()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	informerFactory.Start(ctx.Done())
+	informerFactory.WaitForCacheSync(ctx.Done())
+	assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+
+	controller.resyncWorkloads()
+	assert.Equal(t, map[workload][]string{
+		{name: "app", namespace: "default", kind: DeploymentKind}: {"secret/data/app"},
+		{name: "db", namespace: "default", kind: StatefulSetKind}: {"secret/data/db"},
+	}, controller.workloadSecrets.GetWorkloadSecretsMap())
+}
+
+func TestWaitForCacheSyncRetry(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Template: newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")},
+	})
+	// The API server is unavailable for the first list of Deployments
+	var deploymentLists atomic.Int32
+	kubeClient.PrependReactor("list", "deployments", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if deploymentLists.Add(1) == 1 {
+			return true, nil, apierrors.NewServiceUnavailable("starting up")
+		}
+		return false, nil, nil
+	})
+
+	informerFactory := informers.NewSharedInformerFactory(kubeClient, 0)
+	controller := newTestController(kubeClient)
+	controller.collectorConfig.StartupSyncAttempts = 10
+	controller.collectorConfig.StartupSyncTimeout = 100 * time.Millisecond
+	controller.deploymentsLister = informerFactory.Apps().V1().Deployments().Lister()
+	controller.deploymentsSynced = informerFactory.Apps().V1().Deployments().Informer().HasSynced
+	controller.daemonSetsLister = informerFactory.Apps().V1().DaemonSets().Lister()
+	controller.daemonSetsSynced = informerFactory.Apps().V1().DaemonSets().Informer().HasSynced
+	controller.statefulSetsLister = informerFactory.Apps().V1().StatefulSets().Lister()
+	controller.statefulSetsSynced = informerFactory.Apps().V1().StatefulSets().Informer().HasSynced
+	controller.replicaSetsLister = informerFactory.Apps().V1().ReplicaSets().Lister()
+	controller.replicaSetsSynced = informerFactory.Apps().V1().ReplicaSets().Informer().HasSynced
+	controller.cronJobsLister = informerFactory.Batch().V1().CronJobs().Lister()
+	controller.cronJobsSynced = informerFactory.Batch().V1().CronJobs().Informer().HasSynced
+	controller.jobsLister = informerFactory.Batch().V1().Jobs().Lister()
+	controller.jobsSynced = informerFactory.Batch().V1().Jobs().Informer().HasSynced
+	controller.secretsLister = informerFactory.Core().V1().Secrets().Lister()
+	controller.secretsSynced = informerFactory.Core().V1().Secrets().Informer().HasSynced
+	controller.configMapsLister = informerFactory.Core().V1().ConfigMaps().Lister()
+	controller.configMapsSynced = informerFactory.Core().V1().ConfigMaps().Informer().HasSynced
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	informerFactory.Start(ctx.Done())
+
+	assert.NoError(t, controller.waitForCacheSync(ctx))
+	assert.True(t, controller.cachesSynced.Load())
+	assert.GreaterOrEqual(t, deploymentLists.Load(), int32(2))
+
+	controller.resyncWorkloads()
+	assert.Equal(t, map[workload][]string{
+		{name: "app", namespace: "default", kind: DeploymentKind}: {"secret/data/app"},
+	}, controller.workloadSecrets.GetWorkloadSecretsMap())
+
+	t.Run("giving up", func(t *testing.T) {
+		controller := newTestController(kubeClient)
+		controller.collectorConfig.StartupSyncAttempts = 2
+		controller.collectorConfig.StartupSyncTimeout = 10 * time.Millisecond
+		controller.deploymentsSynced = func() bool { return false }
+		controller.daemonSetsSynced = controller.deploymentsSynced
+		controller.statefulSetsSynced = controller.deploymentsSynced
+		controller.replicaSetsSynced = controller.deploymentsSynced
+		controller.cronJobsSynced = controller.deploymentsSynced
+		controller.jobsSynced = controller.deploymentsSynced
+		controller.secretsSynced = controller.deploymentsSynced
+		controller.configMapsSynced = controller.deploymentsSynced
+
+		assert.EqualError(t, controller.waitForCacheSync(context.Background()),
+			"giving up after 2 attempts: failed to wait for caches to sync")
+		assert.False(t, controller.cachesSynced.Load())
+	})
+}
+
+// sortedSecretWorkloads sorts the workloads of every secret, which are in map iteration order
+func sortedSecretWorkloads(secretWorkloads map[string][]workload) map[string][]workload {
+	for _, workloads := range secretWorkloads {
+		slices.SortFunc(workloads, func(a, b workload) int {
+			return strings.Compare(a.key(), b.key())
+		})
+	}
+	return secretWorkloads
+}
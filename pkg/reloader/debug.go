@@ -0,0 +1,69 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// WorkloadsHandler returns a read-only handler listing the tracked workloads,
+// keyed by namespace/kind/name, with the Vault secret paths they use
+func (c *Controller) WorkloadsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		workloads := make(map[string][]string)
+		for workload, secretPaths := range c.workloadSecrets.GetWorkloadSecretsMap() {
+			workloads[workload.key()] = secretPaths
+		}
+
+		writeJSON(w, workloads)
+	})
+}
+
+// SecretsHandler returns a read-only handler listing the tracked Vault secret paths
+// with the namespace/kind/name of the workloads depending on them
+func (c *Controller) SecretsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		secrets := make(map[string][]string)
+		for secretPath, workloads := range c.workloadSecrets.GetSecretWorkloadsMap() {
+			keys := make([]string, 0, len(workloads))
+			for _, workload := range workloads {
+				keys = append(keys, workload.key())
+			}
+			slices.Sort(keys)
+			secrets[secretPath] = keys
+		}
+
+		writeJSON(w, secrets)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
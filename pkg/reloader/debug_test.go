@@ -0,0 +1,85 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkloadsHandler(t *testing.T) {
+	controller := newTestController(nil)
+	controller.workloadSecrets.Store(
+		workload{name: "app", namespace: "default", kind: DeploymentKind},
+		[]string{"secret/data/accounts/aws", "secret/data/mysql"},
+	)
+	controller.workloadSecrets.Store(
+		workload{name: "agent", namespace: "monitoring", kind: DaemonSetKind},
+		[]string{"secret/data/agent"},
+	)
+
+	t.Run("GET", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		controller.WorkloadsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/workloads", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{
+			"default/Deployment/app": ["secret/data/accounts/aws", "secret/data/mysql"],
+			"monitoring/DaemonSet/agent": ["secret/data/agent"]
+		}`, recorder.Body.String())
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		controller.WorkloadsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/debug/workloads", nil))
+
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	})
+}
+
+func TestSecretsHandler(t *testing.T) {
+	controller := newTestController(nil)
+	controller.workloadSecrets.Store(
+		workload{name: "app", namespace: "default", kind: DeploymentKind},
+		[]string{"secret/data/accounts/aws", "secret/data/mysql"},
+	)
+	controller.workloadSecrets.Store(
+		workload{name: "agent", namespace: "monitoring", kind: DaemonSetKind},
+		[]string{"secret/data/accounts/aws"},
+	)
+
+	t.Run("GET", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		controller.SecretsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/secrets", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{
+			"secret/data/accounts/aws": ["default/Deployment/app", "monitoring/DaemonSet/agent"],
+			"secret/data/mysql": ["default/Deployment/app"]
+		}`, recorder.Body.String())
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		controller.SecretsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/debug/secrets", nil))
+
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	})
+}
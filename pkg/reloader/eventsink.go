@@ -0,0 +1,144 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// reloadOutcomeDryRun is the outcome of the reload events of dry run mode
+const reloadOutcomeDryRun = "dry-run"
+
+// ReloadEvent describes a reload decision, published to the EventSink
+type ReloadEvent struct {
+	Namespace   string         `json:"namespace"`
+	Kind        string         `json:"kind"`
+	Name        string         `json:"name"`
+	SecretPaths []string       `json:"secretPaths"`
+	Versions    map[string]int `json:"versions,omitempty"`
+	Timestamp   time.Time      `json:"timestamp"`
+	// Outcome is success, error or dry-run
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// EventSink receives the reload decisions, e.g. to stream them to the event bus of a platform,
+// Publish is called synchronously by the reload with its context, cancelled on shutdown
+type EventSink interface {
+	Publish(ctx context.Context, event ReloadEvent) error
+}
+
+// NoopEventSink drops the reload events, it is the EventSink of the controller by default
+type NoopEventSink struct{}
+
+// Publish does nothing
+func (NoopEventSink) Publish(context.Context, ReloadEvent) error {
+	return nil
+}
+
+// WebhookEventSink POSTs the reload events as JSON to an URL
+type WebhookEventSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhookEventSink returns a WebhookEventSink POSTing the reload events to the URL
+func NewWebhookEventSink(url string) *WebhookEventSink {
+	return &WebhookEventSink{URL: url, Client: &http.Client{Timeout: reloadHookTimeout}}
+}
+
+// Publish POSTs the event, failing on non-2xx responses
+func (s *WebhookEventSink) Publish(ctx context.Context, event ReloadEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("event sink responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// BrokerPublisher sends a message to a topic of a message broker, like Kafka or NATS,
+// it is implemented by wrapping the client of the broker
+type BrokerPublisher interface {
+	Publish(ctx context.Context, topic string, message []byte) error
+}
+
+// BrokerEventSink publishes the reload events as JSON messages to a topic of a message broker
+type BrokerEventSink struct {
+	Topic     string
+	Publisher BrokerPublisher
+}
+
+// Publish sends the event to the topic
+func (s *BrokerEventSink) Publish(ctx context.Context, event ReloadEvent) error {
+	message, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.Publisher.Publish(ctx, s.Topic, message)
+}
+
+// SetEventSink sets the EventSink the reload decisions are published to
+func (c *Controller) SetEventSink(sink EventSink) {
+	c.eventSink = sink
+}
+
+// publishReloadEvent publishes the reload decision of a workload along with the last observed
+// versions of its changed secret paths, logging the failures
+func (c *Controller) publishReloadEvent(ctx context.Context, workload workload, changedSecretPaths []string, outcome string, reloadErr error) {
+	event := ReloadEvent{
+		Namespace:   workload.namespace,
+		Kind:        workload.kind,
+		Name:        workload.name,
+		SecretPaths: changedSecretPaths,
+		Timestamp:   time.Now().UTC(),
+		Outcome:     outcome,
+	}
+	for _, secretPath := range changedSecretPaths {
+		if version, ok := c.workloadSecrets.GetVersion(secretPath); ok {
+			if event.Versions == nil {
+				event.Versions = make(map[string]int)
+			}
+			event.Versions[secretPath] = version
+		}
+	}
+	if reloadErr != nil {
+		event.Error = reloadErr.Error()
+	}
+
+	if err := c.eventSink.Publish(ctx, event); err != nil {
+		c.logger.Warn(fmt.Errorf("failed to publish reload event of workload %s: %w", workload, err).Error())
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testEventSink captures the published reload events
+type testEventSink struct {
+	events []ReloadEvent
+}
+
+func (s *testEventSink) Publish(_ context.Context, event ReloadEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+// testBrokerPublisher captures the messages sent to each topic
+type testBrokerPublisher struct {
+	messages map[string][][]byte
+}
+
+func (p *testBrokerPublisher) Publish(_ context.Context, topic string, message []byte) error {
+	p.messages[topic] = append(p.messages[topic], message)
+	return nil
+}
+
+func TestReloadEvents(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Template: newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password"),
+		},
+	}
+	appWorkload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+
+	t.Run("success", func(t *testing.T) {
+		sink := &testEventSink{}
+		controller := newTestController(fake.NewSimpleClientset(deployment))
+		controller.SetEventSink(sink)
+		controller.workloadSecrets.SetVersion("secret/data/app", 3)
+
+		assert.NoError(t, controller.triggerReload(context.Background(), appWorkload, []string{"secret/data/app", "secret/data/db"}))
+		assert.Len(t, sink.events, 1)
+		event := sink.events[0]
+		assert.Equal(t, "default", event.Namespace)
+		assert.Equal(t, DeploymentKind, event.Kind)
+		assert.Equal(t, "app", event.Name)
+		assert.Equal(t, []string{"secret/data/app", "secret/data/db"}, event.SecretPaths)
+		assert.Equal(t, map[string]int{"secret/data/app": 3}, event.Versions)
+		assert.Equal(t, reloadOutcomeSuccess, event.Outcome)
+		assert.Empty(t, event.Error)
+		assert.False(t, event.Timestamp.IsZero())
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		sink := &testEventSink{}
+		controller := newTestController(fake.NewSimpleClientset())
+		controller.SetEventSink(sink)
+
+		assert.Error(t, controller.triggerReload(context.Background(), appWorkload, []string{"secret/data/app"}))
+		assert.Len(t, sink.events, 1)
+		assert.Equal(t, reloadOutcomeError, sink.events[0].Outcome)
+		assert.Contains(t, sink.events[0].Error, "not found")
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		sink := &testEventSink{}
+		controller := newTestController(fake.NewSimpleClientset(deployment))
+		controller.SetEventSink(sink)
+		controller.reloaderConfig.DryRun = true
+
+		assert.NoError(t, controller.triggerReload(context.Background(), appWorkload, []string{"secret/data/app"}))
+		assert.Len(t, sink.events, 1)
+		assert.Equal(t, reloadOutcomeDryRun, sink.events[0].Outcome)
+	})
+
+	t.Run("no-op by default", func(t *testing.T) {
+		controller := newTestController(fake.NewSimpleClientset(deployment))
+		assert.NoError(t, controller.triggerReload(context.Background(), appWorkload, []string{"secret/data/app"}))
+	})
+}
+
+func TestWebhookEventSink(t *testing.T) {
+	var received []ReloadEvent
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event ReloadEvent
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		received = append(received, event)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookEventSink(server.URL)
+	event := ReloadEvent{Namespace: "default", Kind: DeploymentKind, Name: "app", SecretPaths: []string{"secret/data/app"}, Outcome: reloadOutcomeSuccess}
+	assert.NoError(t, sink.Publish(context.Background(), event))
+	assert.Equal(t, []ReloadEvent{event}, received)
+
+	status = http.StatusInternalServerError
+	assert.EqualError(t, sink.Publish(context.Background(), event), "event sink responded with status 500")
+
+	// Publishing is cancelled along with the reload on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, sink.Publish(ctx, event), context.Canceled)
+	assert.Len(t, received, 2)
+}
+
+func TestBrokerEventSink(t *testing.T) {
+	publisher := &testBrokerPublisher{messages: make(map[string][][]byte)}
+	sink := &BrokerEventSink{Topic: "infra.reloads", Publisher: publisher}
+
+	event := ReloadEvent{Namespace: "default", Kind: DeploymentKind, Name: "app", SecretPaths: []string{"secret/data/app"}, Outcome: reloadOutcomeSuccess}
+	assert.NoError(t, sink.Publish(context.Background(), event))
+	assert.Len(t, publisher.messages["infra.reloads"], 1)
+
+	var published ReloadEvent
+	assert.NoError(t, json.Unmarshal(publisher.messages["infra.reloads"][0], &published))
+	assert.Equal(t, event, published)
+}
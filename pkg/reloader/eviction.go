@@ -0,0 +1,166 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// evictStaleWorkloads drops the stored workloads that no longer exist, in case their
+// deletion was missed by the informers, so that their secrets are not polled forever
+func (c *Controller) evictStaleWorkloads(_ context.Context) {
+	evictorLogger := c.logger.With(slog.String("worker", "evictor"))
+
+	// The informer caches are read after the snapshot, so every workload in it that
+	// still exists is found there
+	snapshotAt := time.Now()
+	storedWorkloads := c.workloadSecrets.GetWorkloadSecretsMap()
+	liveWorkloads := make(map[string]map[workload]bool)
+	for workload := range storedWorkloads {
+		if _, ok := liveWorkloads[workload.kind]; ok {
+			continue
+		}
+		workloads, err := c.listWorkloads(workload.kind)
+		if err != nil {
+			evictorLogger.Error(fmt.Errorf("failed to list %s workloads: %w", workload.kind, err).Error())
+		}
+		// Workloads of a kind that could not be listed are kept
+		liveWorkloads[workload.kind] = workloads
+	}
+
+	for workload := range storedWorkloads {
+		live := liveWorkloads[workload.kind]
+		if live == nil || live[workload] {
+			continue
+		}
+		// Workloads recreated after the snapshot are stored again and must be kept
+		if firstSeen, ok := c.workloadSecrets.GetFirstSeen(workload); ok && firstSeen.After(snapshotAt) {
+			continue
+		}
+		evictorLogger.Info(fmt.Sprintf("Evicting workload from store, it does not exist anymore: %s", workload))
+		c.workloadSecrets.Delete(workload)
+		c.metrics.storeEvicted.Inc()
+	}
+}
+
+// listWorkloads returns the existing workloads of a kind in all namespaces from the
+// informer caches
+func (c *Controller) listWorkloads(kind string) (map[workload]bool, error) {
+	var objects []metav1.Object
+	switch kind {
+	case DeploymentKind:
+		list, err := c.deploymentsLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range list {
+			objects = append(objects, object)
+		}
+
+	case DaemonSetKind:
+		list, err := c.daemonSetsLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range list {
+			objects = append(objects, object)
+		}
+
+	case StatefulSetKind:
+		list, err := c.statefulSetsLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range list {
+			objects = append(objects, object)
+		}
+
+	case ReplicaSetKind:
+		list, err := c.replicaSetsLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range list {
+			objects = append(objects, object)
+		}
+
+	case CronJobKind:
+		list, err := c.cronJobsLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range list {
+			objects = append(objects, object)
+		}
+
+	case JobKind:
+		list, err := c.jobsLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range list {
+			objects = append(objects, object)
+		}
+
+	case SecretsKind:
+		list, err := c.secretsLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range list {
+			objects = append(objects, object)
+		}
+
+	case PodKind:
+		if c.podsLister == nil {
+			return nil, fmt.Errorf("Pods are not watched")
+		}
+		list, err := c.podsLister.List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range list {
+			objects = append(objects, object)
+		}
+
+	case RolloutKind:
+		if c.rolloutsStore == nil {
+			return nil, fmt.Errorf("Argo Rollouts are not watched")
+		}
+		for _, item := range c.rolloutsStore.List() {
+			object, err := meta.Accessor(item)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, object)
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown object type: %s", kind)
+	}
+
+	workloads := make(map[workload]bool, len(objects))
+	for _, object := range objects {
+		workloads[workload{name: object.GetName(), namespace: object.GetNamespace(), kind: kind}] = true
+	}
+	return workloads, nil
+}
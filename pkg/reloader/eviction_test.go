@@ -0,0 +1,62 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+)
+
+func TestEvictStaleWorkloads(t *testing.T) {
+	live := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	deleted := workload{name: "deleted", namespace: "default", kind: DeploymentKind}
+	deletedStatefulSet := workload{name: "db", namespace: "default", kind: StatefulSetKind}
+	rollout := workload{name: "canary", namespace: "default", kind: RolloutKind}
+	recreated := workload{name: "recreated", namespace: "default", kind: DeploymentKind}
+
+	kubeClient := fake.NewSimpleClientset()
+	controller := newTestController(kubeClient)
+	controller.deploymentsLister = appslisters.NewDeploymentLister(newTestIndexer(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+	}))
+	controller.statefulSetsLister = appslisters.NewStatefulSetLister(newTestIndexer())
+	controller.workloadSecrets.Store(live, []string{"secret/data/app"})
+	controller.workloadSecrets.Store(deleted, []string{"secret/data/app"})
+	controller.workloadSecrets.Store(deletedStatefulSet, []string{"secret/data/db"})
+	// Rollouts can't be listed without the dynamic client, so they are kept
+	controller.workloadSecrets.Store(rollout, []string{"secret/data/canary"})
+	// Missing from the informer cache, but stored again after the snapshot is taken
+	controller.workloadSecrets.Store(recreated, []string{"secret/data/app"})
+	controller.workloadSecrets.SetFirstSeen(recreated, time.Now().Add(time.Minute))
+
+	controller.evictStaleWorkloads(context.Background())
+
+	assert.Equal(t, map[workload][]string{
+		live:      {"secret/data/app"},
+		rollout:   {"secret/data/canary"},
+		recreated: {"secret/data/app"},
+	}, controller.workloadSecrets.GetWorkloadSecretsMap())
+	assert.Equal(t, float64(2), testutil.ToFloat64(controller.metrics.storeEvicted))
+	// The workloads are listed from the informer caches
+	assert.Empty(t, kubeClient.Actions())
+}
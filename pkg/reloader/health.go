@@ -0,0 +1,34 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"net/http"
+)
+
+// ReadyHandler returns a handler responding 200 once the informer caches have synced
+// and the Vault client has authenticated at least once, unless in collect only mode, 503 until then
+func (c *Controller) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !c.cachesSynced.Load():
+			http.Error(w, "informer caches not synced", http.StatusServiceUnavailable)
+		case !c.reloaderConfig.CollectOnly && !c.vaultAuthenticated.Load():
+			http.Error(w, "Vault client not authenticated", http.StatusServiceUnavailable)
+		default:
+			_, _ = w.Write([]byte("ok"))
+		}
+	})
+}
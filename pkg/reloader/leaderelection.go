@@ -0,0 +1,84 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// LeaderElectionConfig holds the settings of electing the replica that reloads workloads
+type LeaderElectionConfig struct {
+	// Enabled makes only the replica holding the Lease reload workloads and flush the
+	// store, the other replicas keep collecting workloads and checking their secrets to
+	// take over quickly
+	Enabled        bool
+	LeaseName      string
+	LeaseNamespace string
+	// Identity is the unique name of the replica, e.g. its pod name
+	Identity string
+}
+
+// isLeader tells whether this replica may reload workloads and flush the store
+func (c *Controller) isLeader() bool {
+	return !c.reloaderConfig.LeaderElection.Enabled || c.leader.Load()
+}
+
+// runLeaderElection campaigns for the Lease until the context is cancelled,
+// campaigning again whenever the leadership is lost
+func (c *Controller) runLeaderElection(ctx context.Context) {
+	config := c.reloaderConfig.LeaderElection
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock: &resourcelock.LeaseLock{
+				LeaseMeta: metav1.ObjectMeta{
+					Name:      config.LeaseName,
+					Namespace: config.LeaseNamespace,
+				},
+				Client:     c.kubeClient.CoordinationV1(),
+				LockConfig: resourcelock.ResourceLockConfig{Identity: config.Identity},
+			},
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   renewDeadline,
+			RetryPeriod:     retryPeriod,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					c.logger.Info("Started leading, reloading workloads")
+					c.leader.Store(true)
+				},
+				OnStoppedLeading: func() {
+					c.logger.Info("Stopped leading, standing by")
+					c.leader.Store(false)
+				},
+				OnNewLeader: func(identity string) {
+					if identity != config.Identity {
+						c.logger.Info("New leader elected: " + identity)
+					}
+				},
+			},
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	slogmulti "github.com/samber/slog-multi"
+)
+
+// LogFormat is the format the log records are written in
+type LogFormat string
+
+const (
+	LogFormatText LogFormat = "text"
+	LogFormatJSON LogFormat = "json"
+)
+
+// LoggingConfig holds the format and the level of the logs
+type LoggingConfig struct {
+	LogFormat LogFormat
+	// LogLevel can be changed while running to adjust the verbosity of the handler built from this config
+	LogLevel *slog.LevelVar
+}
+
+// NewLogHandler builds the slog.Handler matching the config, sending warnings and
+// errors to stderr, and info and debug logs to stdout
+func NewLogHandler(config LoggingConfig, stdout io.Writer, stderr io.Writer) (slog.Handler, error) {
+	var level slog.Leveler = slog.LevelInfo
+	if config.LogLevel != nil {
+		level = config.LogLevel
+	}
+
+	var newHandler func(w io.Writer, opts *slog.HandlerOptions) slog.Handler
+	switch config.LogFormat {
+	case LogFormatText, "":
+		newHandler = func(w io.Writer, opts *slog.HandlerOptions) slog.Handler {
+			return slog.NewTextHandler(w, opts)
+		}
+	case LogFormatJSON:
+		newHandler = func(w io.Writer, opts *slog.HandlerOptions) slog.Handler {
+			return slog.NewJSONHandler(w, opts)
+		}
+	default:
+		return nil, fmt.Errorf("unknown log format %q", config.LogFormat)
+	}
+
+	levelFilter := func(levels ...slog.Level) func(ctx context.Context, r slog.Record) bool {
+		return func(ctx context.Context, r slog.Record) bool {
+			return slices.Contains(levels, r.Level)
+		}
+	}
+
+	return slogmulti.Router().
+		// Send logs with level higher than warning to stderr
+		Add(
+			newHandler(stderr, &slog.HandlerOptions{Level: slog.LevelWarn}),
+			levelFilter(slog.LevelWarn, slog.LevelError),
+		).
+		// Send info and debug logs to stdout
+		Add(
+			newHandler(stdout, &slog.HandlerOptions{Level: level}),
+			levelFilter(slog.LevelDebug, slog.LevelInfo),
+		).
+		Handler(), nil
+}
+
+// LogLevelHandler returns a handler reporting the current log level on GET
+// and changing it live on PUT, e.g. PUT /debug/loglevel?level=debug
+func LogLevelHandler(logLevel *slog.LevelVar) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var level slog.Level
+			if err := level.UnmarshalText([]byte(r.URL.Query().Get("level"))); err != nil {
+				http.Error(w, fmt.Errorf("invalid log level: %w", err).Error(), http.StatusBadRequest)
+				return
+			}
+			logLevel.Set(level)
+		default:
+			w.Header().Set("Allow", http.MethodGet+", "+http.MethodPut)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, map[string]string{"level": logLevel.Level().String()})
+	})
+}
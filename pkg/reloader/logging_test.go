@@ -0,0 +1,97 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLogHandler(t *testing.T) {
+	t.Run("JSON records are parseable", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		handler, err := NewLogHandler(LoggingConfig{LogFormat: LogFormatJSON}, &stdout, &stderr)
+		assert.NoError(t, err)
+
+		logger := slog.New(handler)
+		logger.Info("reloading workload", slog.String("name", "app"))
+		logger.Error("vault unreachable")
+
+		var record map[string]any
+		assert.NoError(t, json.Unmarshal(stdout.Bytes(), &record))
+		assert.Equal(t, "INFO", record["level"])
+		assert.Equal(t, "reloading workload", record["msg"])
+		assert.Equal(t, "app", record["name"])
+
+		record = nil
+		assert.NoError(t, json.Unmarshal(stderr.Bytes(), &record))
+		assert.Equal(t, "ERROR", record["level"])
+		assert.Equal(t, "vault unreachable", record["msg"])
+	})
+
+	t.Run("level gates debug lines", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		logLevel := new(slog.LevelVar)
+		handler, err := NewLogHandler(LoggingConfig{LogFormat: LogFormatText, LogLevel: logLevel}, &stdout, &stderr)
+		assert.NoError(t, err)
+
+		logger := slog.New(handler)
+		logger.Debug("hidden")
+		logger.Info("shown")
+		assert.NotContains(t, stdout.String(), "hidden")
+		assert.Contains(t, stdout.String(), "shown")
+
+		logLevel.Set(slog.LevelDebug)
+		logger.Debug("now shown")
+		assert.Contains(t, stdout.String(), "now shown")
+		assert.Empty(t, stderr.String())
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		_, err := NewLogHandler(LoggingConfig{LogFormat: "xml"}, &bytes.Buffer{}, &bytes.Buffer{})
+		assert.EqualError(t, err, `unknown log format "xml"`)
+	})
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	logLevel := new(slog.LevelVar)
+	handler := LogLevelHandler(logLevel)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/loglevel", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"level":"INFO"}`, recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/debug/loglevel?level=debug", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"level":"DEBUG"}`, recorder.Body.String())
+	assert.Equal(t, slog.LevelDebug, logLevel.Level())
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/debug/loglevel?level=verbose", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, slog.LevelDebug, logLevel.Level())
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/debug/loglevel", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
@@ -0,0 +1,336 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	reloadOutcomeSuccess = "success"
+	reloadOutcomeError   = "error"
+)
+
+const (
+	vaultLookupErrorNotFound  = "notfound"
+	vaultLookupErrorAuth      = "auth"
+	vaultLookupErrorTransport = "transport"
+)
+
+type metrics struct {
+	trackedWorkloads     *prometheus.GaugeVec
+	trackedSecretPaths   prometheus.Gauge
+	orphanedSecretPaths  prometheus.Gauge
+	reloadsTriggered     *prometheus.CounterVec
+	reloadDuration       *prometheus.HistogramVec
+	reloadsSkippedDryRun *prometheus.CounterVec
+	reloadsSkippedPaused *prometheus.CounterVec
+	reloadRetries        *prometheus.CounterVec
+	reloadRetriesFailed  *prometheus.CounterVec
+	missingSecrets       prometheus.Counter
+	storeEvicted         prometheus.Counter
+	vaultLookupErrors    *prometheus.CounterVec
+	vaultUnavailable     prometheus.Counter
+	pendingReloads       prometheus.Gauge
+	truncatedSecretPaths *prometheus.CounterVec
+}
+
+func newMetrics(registerer prometheus.Registerer) *metrics {
+	m := &metrics{
+		trackedWorkloads: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "reloader_tracked_workloads",
+			Help: "Number of workloads tracked by the collector",
+		}, []string{"namespace", "kind"}),
+		trackedSecretPaths: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "reloader_tracked_secret_paths",
+			Help: "Number of unique Vault secret paths tracked by the collector",
+		}),
+		orphanedSecretPaths: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "reloader_orphaned_secret_paths",
+			Help: "Number of Vault secret paths no longer referenced by any workload since their last workload was deleted",
+		}),
+		reloadsTriggered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "reloader_reload_triggered_total",
+			Help: "Number of workload reloads triggered by the reloader",
+		}, []string{"namespace", "kind", "outcome"}),
+		reloadDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "reloader_reload_duration_seconds",
+			Help:    "Time it took to update a workload when reloading it",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"kind"}),
+		reloadsSkippedDryRun: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "reloader_reload_skipped_dryrun_total",
+			Help: "Number of workload reloads skipped in dry run mode",
+		}, []string{"namespace", "kind"}),
+		reloadsSkippedPaused: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "reloader_reload_skipped_paused_total",
+			Help: "Number of workload reloads skipped as the rollout of the workload is paused",
+		}, []string{"namespace", "kind"}),
+		reloadRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "reloader_reload_retries_total",
+			Help: "Number of workload reloads retried after a transient error",
+		}, []string{"namespace", "kind"}),
+		reloadRetriesFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "reloader_reload_retries_exhausted_total",
+			Help: "Number of workload reloads that failed after all retry attempts",
+		}, []string{"namespace", "kind"}),
+		missingSecrets: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "reloader_missing_secrets_total",
+			Help: "Number of lookups of tracked Vault secret paths that were not found",
+		}),
+		storeEvicted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "reloader_store_evicted_total",
+			Help: "Number of stored workloads evicted since they did not exist anymore",
+		}),
+		vaultLookupErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "reloader_vault_lookup_errors_total",
+			Help: "Number of failed lookups of tracked Vault secret paths",
+		}, []string{"mount", "error_type"}),
+		vaultUnavailable: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "reloader_vault_unavailable_cycles_total",
+			Help: "Number of reloader runs that could not reach Vault and were requeued",
+		}),
+		pendingReloads: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "reloader_pending_reloads",
+			Help: "Number of tracked Vault secret paths that changed since the last successful reload of one of their workloads",
+		}),
+		truncatedSecretPaths: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "reloader_truncated_secret_paths_total",
+			Help: "Number of workload collections dropping Vault secret paths beyond the limit per annotation or per workload",
+		}, []string{"namespace", "kind", "limit"}),
+	}
+
+	// Registering the metrics again returns the registered ones instead of panicking
+	m.trackedWorkloads = register(registerer, m.trackedWorkloads)
+	m.trackedSecretPaths = register(registerer, m.trackedSecretPaths)
+	m.orphanedSecretPaths = register(registerer, m.orphanedSecretPaths)
+	m.reloadsTriggered = register(registerer, m.reloadsTriggered)
+	m.reloadDuration = register(registerer, m.reloadDuration)
+	m.reloadsSkippedDryRun = register(registerer, m.reloadsSkippedDryRun)
+	m.reloadsSkippedPaused = register(registerer, m.reloadsSkippedPaused)
+	m.reloadRetries = register(registerer, m.reloadRetries)
+	m.reloadRetriesFailed = register(registerer, m.reloadRetriesFailed)
+	m.missingSecrets = register(registerer, m.missingSecrets)
+	m.storeEvicted = register(registerer, m.storeEvicted)
+	m.vaultLookupErrors = register(registerer, m.vaultLookupErrors)
+	m.vaultUnavailable = register(registerer, m.vaultUnavailable)
+	m.pendingReloads = register(registerer, m.pendingReloads)
+	m.truncatedSecretPaths = register(registerer, m.truncatedSecretPaths)
+
+	return m
+}
+
+// register registers a metric with the registerer, returning the metric already
+// registered with it under the same name if there is one
+func register[T prometheus.Collector](registerer prometheus.Registerer, metric T) T {
+	if err := registerer.Register(metric); err != nil {
+		var alreadyRegistered prometheus.AlreadyRegisteredError
+		if errors.As(err, &alreadyRegistered) {
+			if existing, ok := alreadyRegistered.ExistingCollector.(T); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return metric
+}
+
+// countVaultLookupError counts a failed lookup of a secret path by the path of its mount
+// and by whether it was not found, denied or could not be read
+func (m *metrics) countVaultLookupError(mountPath string, err error) {
+	m.vaultLookupErrors.WithLabelValues(mountPath, vaultLookupErrorType(err)).Inc()
+}
+
+// vaultLookupErrorType tells whether a failed lookup of a secret path was not found,
+// denied or could not be read
+func vaultLookupErrorType(err error) string {
+	var responseErr *vaultapi.ResponseError
+	switch {
+	case errors.As(err, &ErrSecretNotFound{}):
+		return vaultLookupErrorNotFound
+	case errors.As(err, &responseErr):
+		switch responseErr.StatusCode {
+		case http.StatusNotFound:
+			return vaultLookupErrorNotFound
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return vaultLookupErrorAuth
+		}
+	}
+	return vaultLookupErrorTransport
+}
+
+// instrumentedWorkloadSecrets updates the store gauges on every change of the
+// underlying workloadSecretsStore, keeping the counts up to date with the changed
+// workload only instead of recounting the whole store
+type instrumentedWorkloadSecrets struct {
+	workloadSecretsStore
+	metrics *metrics
+	logger  *slog.Logger
+
+	// mu serializes the changes of the store with the updates of the counts,
+	// so that the gauges reflect the latest change
+	mu sync.Mutex
+	// workloadSecretPaths holds the unique secret paths of each stored workload
+	workloadSecretPaths map[workload][]string
+	// secretPathWorkloads holds the number of stored workloads referencing each secret path
+	secretPathWorkloads map[string]int
+	// orphanedSecretPaths holds the secret paths whose last workload was deleted,
+	// until a workload references them again
+	orphanedSecretPaths map[string]struct{}
+	// trackedWorkloads holds the number of stored workloads by namespace and kind
+	trackedWorkloads map[[2]string]int
+}
+
+func newInstrumentedWorkloadSecrets(store workloadSecretsStore, metrics *metrics, logger *slog.Logger) workloadSecretsStore {
+	w := &instrumentedWorkloadSecrets{
+		workloadSecretsStore: store,
+		metrics:              metrics,
+		logger:               logger,
+		orphanedSecretPaths:  make(map[string]struct{}),
+	}
+	w.recountStoreGauges()
+	return w
+}
+
+func (w *instrumentedWorkloadSecrets) Store(workload workload, secrets []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.workloadSecretsStore.Store(workload, secrets)
+	w.updateStoreGauges(workload, false)
+}
+
+func (w *instrumentedWorkloadSecrets) StoreTrackedPaths(workload workload, trackedPaths []trackedPath) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.workloadSecretsStore.StoreTrackedPaths(workload, trackedPaths)
+	w.updateStoreGauges(workload, false)
+}
+
+func (w *instrumentedWorkloadSecrets) Delete(workload workload) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.workloadSecretsStore.Delete(workload)
+	w.updateStoreGauges(workload, true)
+}
+
+func (w *instrumentedWorkloadSecrets) UntrackSecretPath(secretPath string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.workloadSecretsStore.UntrackSecretPath(secretPath)
+	for workload, secretPaths := range w.workloadSecretPaths {
+		if slices.Contains(secretPaths, secretPath) {
+			w.updateStoreGauges(workload, false)
+		}
+	}
+}
+
+func (w *instrumentedWorkloadSecrets) Restore(snapshot []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.workloadSecretsStore.Restore(snapshot)
+	w.recountStoreGauges()
+	return err
+}
+
+// updateStoreGauges updates the counts with the stored secret paths of a changed workload,
+// marking its dropped secret paths no longer referenced by any workload as orphaned if it
+// was deleted, and clearing the orphaned ones it references again, w.mu has to be held
+func (w *instrumentedWorkloadSecrets) updateStoreGauges(workload workload, deleted bool) {
+	secretPaths, stored := w.workloadSecretsStore.GetSecrets(workload)
+	secretPaths = uniqueSecretPaths(secretPaths)
+	oldSecretPaths, wasStored := w.workloadSecretPaths[workload]
+
+	for _, secretPath := range secretPaths {
+		w.secretPathWorkloads[secretPath]++
+		delete(w.orphanedSecretPaths, secretPath)
+	}
+	for _, secretPath := range oldSecretPaths {
+		w.secretPathWorkloads[secretPath]--
+		if w.secretPathWorkloads[secretPath] > 0 {
+			continue
+		}
+		delete(w.secretPathWorkloads, secretPath)
+		if deleted {
+			w.logger.Info(fmt.Sprintf("Vault secret path %s is no longer referenced by any workload", secretPath))
+			w.orphanedSecretPaths[secretPath] = struct{}{}
+		}
+	}
+
+	labels := [2]string{workload.namespace, workload.kind}
+	switch {
+	case stored:
+		w.workloadSecretPaths[workload] = secretPaths
+		if !wasStored {
+			w.trackedWorkloads[labels]++
+		}
+	case wasStored:
+		delete(w.workloadSecretPaths, workload)
+		w.trackedWorkloads[labels]--
+	}
+	// The series of the namespaces and kinds without workloads anymore are deleted
+	// instead of resetting all of them, so that a scrape never sees them missing
+	if count := w.trackedWorkloads[labels]; count > 0 {
+		w.metrics.trackedWorkloads.WithLabelValues(labels[0], labels[1]).Set(float64(count))
+	} else {
+		delete(w.trackedWorkloads, labels)
+		w.metrics.trackedWorkloads.DeleteLabelValues(labels[0], labels[1])
+	}
+	w.metrics.trackedSecretPaths.Set(float64(len(w.secretPathWorkloads)))
+	w.metrics.orphanedSecretPaths.Set(float64(len(w.orphanedSecretPaths)))
+}
+
+// recountStoreGauges counts the whole store again from a single copy of the stored
+// workloads, when the store is first wrapped or restored from a snapshot, w.mu has to be held
+func (w *instrumentedWorkloadSecrets) recountStoreGauges() {
+	oldTrackedWorkloads := w.trackedWorkloads
+	w.workloadSecretPaths = make(map[workload][]string)
+	w.secretPathWorkloads = make(map[string]int)
+	w.trackedWorkloads = make(map[[2]string]int)
+	for workload, secretPaths := range w.workloadSecretsStore.GetWorkloadSecretsMap() {
+		secretPaths = uniqueSecretPaths(secretPaths)
+		w.workloadSecretPaths[workload] = secretPaths
+		w.trackedWorkloads[[2]string{workload.namespace, workload.kind}]++
+		for _, secretPath := range secretPaths {
+			w.secretPathWorkloads[secretPath]++
+			delete(w.orphanedSecretPaths, secretPath)
+		}
+	}
+
+	for labels := range oldTrackedWorkloads {
+		if _, ok := w.trackedWorkloads[labels]; !ok {
+			w.metrics.trackedWorkloads.DeleteLabelValues(labels[0], labels[1])
+		}
+	}
+	for labels, count := range w.trackedWorkloads {
+		w.metrics.trackedWorkloads.WithLabelValues(labels[0], labels[1]).Set(float64(count))
+	}
+	w.metrics.trackedSecretPaths.Set(float64(len(w.secretPathWorkloads)))
+	w.metrics.orphanedSecretPaths.Set(float64(len(w.orphanedSecretPaths)))
+}
+
+// uniqueSecretPaths sorts the secret paths of a workload, removing the duplicates
+// of the paths collected from several sources
+func uniqueSecretPaths(secretPaths []string) []string {
+	slices.Sort(secretPaths)
+	return slices.Compact(secretPaths)
+}
@@ -0,0 +1,184 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrumentedWorkloadSecretsStore(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := newMetrics(registry)
+	store := newInstrumentedWorkloadSecrets(newWorkloadSecrets(), metrics, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	deployment := workload{name: "test", namespace: "default", kind: DeploymentKind}
+	daemonSet := workload{name: "test2", namespace: "default", kind: DaemonSetKind}
+
+	store.Store(deployment, []string{"secret/data/accounts/aws", "secret/data/mysql"})
+	store.Store(daemonSet, []string{"secret/data/accounts/aws", "secret/data/docker"})
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.trackedWorkloads.WithLabelValues("default", DeploymentKind)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.trackedWorkloads.WithLabelValues("default", DaemonSetKind)))
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.trackedSecretPaths))
+
+	store.Delete(deployment)
+
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.trackedWorkloads))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.trackedSecretPaths))
+}
+
+func TestInstrumentedWorkloadSecretsStoreConcurrentUpdates(t *testing.T) {
+	metrics := newMetrics(prometheus.NewRegistry())
+	store := newInstrumentedWorkloadSecrets(newWorkloadSecrets(), metrics, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Store(workload{name: fmt.Sprintf("app-%d", i), namespace: "default", kind: DeploymentKind}, []string{"secret/data/app"})
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, float64(50), testutil.ToFloat64(metrics.trackedWorkloads.WithLabelValues("default", DeploymentKind)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.trackedSecretPaths))
+}
+
+// copyCountingStore counts the copies of the whole store
+type copyCountingStore struct {
+	workloadSecretsStore
+	copies int
+}
+
+func (s *copyCountingStore) GetWorkloadSecretsMap() map[workload][]string {
+	s.copies++
+	return s.workloadSecretsStore.GetWorkloadSecretsMap()
+}
+
+func TestInstrumentedWorkloadSecretsStoreIncrementalUpdates(t *testing.T) {
+	metrics := newMetrics(prometheus.NewRegistry())
+	countingStore := &copyCountingStore{workloadSecretsStore: newWorkloadSecrets()}
+	store := newInstrumentedWorkloadSecrets(countingStore, metrics, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	countingStore.copies = 0
+
+	deployment := workload{name: "test", namespace: "default", kind: DeploymentKind}
+	daemonSet := workload{name: "test2", namespace: "default", kind: DaemonSetKind}
+
+	// The same path collected from several sources is counted once
+	store.StoreTrackedPaths(deployment, []trackedPath{
+		{Path: "secret/data/app", Source: pathSourceEnv},
+		{Path: "secret/data/app", Source: pathSourceAnnotation},
+		{Path: "secret/data/mysql", Source: pathSourceEnv},
+	})
+	store.Store(daemonSet, []string{"secret/data/app"})
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.trackedSecretPaths))
+
+	// Updating a workload replaces its paths, the dropped ones are not orphaned
+	store.Store(deployment, []string{"secret/data/app"})
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.trackedSecretPaths))
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.orphanedSecretPaths))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.trackedWorkloads.WithLabelValues("default", DeploymentKind)))
+
+	// Untracking the last path of the workloads drops them
+	store.UntrackSecretPath("secret/data/app")
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.trackedSecretPaths))
+	assert.Equal(t, 0, testutil.CollectAndCount(metrics.trackedWorkloads))
+
+	// None of the changes copied the whole store
+	assert.Equal(t, 0, countingStore.copies)
+}
+
+func TestInstrumentedWorkloadSecretsStoreRestore(t *testing.T) {
+	source := newWorkloadSecrets()
+	source.Store(workload{name: "test", namespace: "default", kind: DeploymentKind}, []string{"secret/data/app", "secret/data/mysql"})
+	snapshot, err := source.Snapshot()
+	assert.NoError(t, err)
+
+	metrics := newMetrics(prometheus.NewRegistry())
+	store := newInstrumentedWorkloadSecrets(newWorkloadSecrets(), metrics, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	store.Store(workload{name: "test2", namespace: "default", kind: DaemonSetKind}, []string{"secret/data/docker"})
+
+	// The restored workloads are added to the collected ones
+	assert.NoError(t, store.Restore(snapshot))
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.trackedSecretPaths))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.trackedWorkloads.WithLabelValues("default", DeploymentKind)))
+	assert.Equal(t, 2, testutil.CollectAndCount(metrics.trackedWorkloads))
+}
+
+func TestOrphanedSecretPaths(t *testing.T) {
+	metrics := newMetrics(prometheus.NewRegistry())
+	store := newInstrumentedWorkloadSecrets(newWorkloadSecrets(), metrics, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	deployment := workload{name: "test", namespace: "default", kind: DeploymentKind}
+	daemonSet := workload{name: "test2", namespace: "default", kind: DaemonSetKind}
+
+	store.Store(deployment, []string{"secret/data/accounts/aws", "secret/data/mysql"})
+	store.Store(daemonSet, []string{"secret/data/accounts/aws"})
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.orphanedSecretPaths))
+
+	// the shared path is still referenced by the DaemonSet
+	store.Delete(deployment)
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.trackedSecretPaths))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.orphanedSecretPaths))
+
+	// deleting the last workload referencing a path orphans it
+	store.Delete(daemonSet)
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.trackedSecretPaths))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.orphanedSecretPaths))
+
+	// referencing a path again clears it
+	store.Store(deployment, []string{"secret/data/mysql"})
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.trackedSecretPaths))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.orphanedSecretPaths))
+}
+
+func TestVaultLookupErrorsMetric(t *testing.T) {
+	vault := newTestVault(t)
+	vault.setVersion("app", 1)
+	vault.forbidden = []string{"denied"}
+	vault.failing = []string{"broken"}
+
+	controller := newTestController(nil)
+	vaultClient := newSDKVaultClient(vault.client(t), "")
+	lookupErrors := func(errorType string) float64 {
+		return testutil.ToFloat64(controller.metrics.vaultLookupErrors.WithLabelValues("secret", errorType))
+	}
+
+	for _, secretPath := range []string{"secret/data/app", "secret/data/missing", "secret/data/denied", "secret/data/broken"} {
+		_, _ = controller.checkSecret(context.Background(), controller.logger, vaultClient, secretPath, secretPath)
+	}
+
+	assert.Equal(t, float64(1), lookupErrors(vaultLookupErrorNotFound))
+	assert.Equal(t, float64(1), lookupErrors(vaultLookupErrorAuth))
+	assert.Equal(t, float64(1), lookupErrors(vaultLookupErrorTransport))
+	assert.Equal(t, 3, testutil.CollectAndCount(controller.metrics.vaultLookupErrors))
+
+	// Nested mounts are labelled with their detected path instead of their first segment
+	vault.setMountVersion("team/kv", "app", 1)
+	_, _ = controller.checkSecret(context.Background(), controller.logger, vaultClient, "team/kv/data/missing", "team/kv/data/missing")
+	assert.Equal(t, float64(1), testutil.ToFloat64(controller.metrics.vaultLookupErrors.WithLabelValues("team/kv", vaultLookupErrorNotFound)))
+	assert.Equal(t, 4, testutil.CollectAndCount(controller.metrics.vaultLookupErrors))
+}
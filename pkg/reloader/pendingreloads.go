@@ -0,0 +1,83 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pendingReloads tracks the secret paths that changed since the last successful reload of
+// their workloads, reloads failing or being skipped make it grow until they succeed
+type pendingReloads struct {
+	mu sync.Mutex
+	// paths holds the workloads whose reload for a changed secret path is pending
+	paths map[string]map[workload]struct{}
+	gauge prometheus.Gauge
+}
+
+func newPendingReloads(gauge prometheus.Gauge) *pendingReloads {
+	return &pendingReloads{
+		paths: make(map[string]map[workload]struct{}),
+		gauge: gauge,
+	}
+}
+
+// add marks the reload of a workload pending for its changed secret paths
+func (p *pendingReloads) add(pending workload, secretPaths []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, secretPath := range secretPaths {
+		if p.paths[secretPath] == nil {
+			p.paths[secretPath] = make(map[workload]struct{})
+		}
+		p.paths[secretPath][pending] = struct{}{}
+	}
+	p.gauge.Set(float64(len(p.paths)))
+}
+
+// done clears the pending reloads of a workload once it was reloaded with the current
+// versions of all its secrets
+func (p *pendingReloads) done(reloaded workload) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.deleteFunc(func(workload workload) bool { return workload == reloaded })
+}
+
+// prune clears the pending reloads of the workloads that are not tracked anymore
+func (p *pendingReloads) prune(tracked func(workload) bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.deleteFunc(func(workload workload) bool { return !tracked(workload) })
+}
+
+// deleteFunc clears the pending reloads of the workloads del returns true for
+func (p *pendingReloads) deleteFunc(del func(workload) bool) {
+	for secretPath, workloads := range p.paths {
+		for workload := range workloads {
+			if del(workload) {
+				delete(workloads, workload)
+			}
+		}
+		if len(workloads) == 0 {
+			delete(p.paths, secretPath)
+		}
+	}
+	p.gauge.Set(float64(len(p.paths)))
+}
@@ -0,0 +1,103 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRunReloaderPendingReloads(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Template: newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password"),
+		},
+	}
+	vault := newTestVault(t)
+	vault.setVersion("app", 1)
+
+	kubeClient := fake.NewSimpleClientset(deployment)
+	failing := true
+	kubeClient.PrependReactor("patch", "deployments", func(k8stesting.Action) (bool, runtime.Object, error) {
+		if failing {
+			return true, nil, errors.New("admission webhook denied the request")
+		}
+		return false, nil, nil
+	})
+	controller := newTestController(kubeClient)
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	app := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	controller.collectWorkloadSecrets(app, nil, deployment.Spec.Template)
+	pending := func() float64 { return testutil.ToFloat64(controller.metrics.pendingReloads) }
+
+	controller.runReloader(context.Background())
+	assert.Zero(t, pending())
+
+	t.Run("failed reload", func(t *testing.T) {
+		vault.setVersion("app", 2)
+		controller.runReloader(context.Background())
+		assert.Equal(t, float64(1), pending())
+
+		// The change is not detected again, the reload stays pending
+		controller.runReloader(context.Background())
+		assert.Equal(t, float64(1), pending())
+	})
+
+	t.Run("successful reload", func(t *testing.T) {
+		failing = false
+		vault.setVersion("app", 3)
+		controller.runReloader(context.Background())
+		assert.Zero(t, pending())
+	})
+
+	t.Run("deleted workload", func(t *testing.T) {
+		failing = true
+		vault.setVersion("app", 4)
+		controller.runReloader(context.Background())
+		assert.Equal(t, float64(1), pending())
+
+		controller.workloadSecrets.Delete(app)
+		controller.reloadWorkloads(context.Background(), controller.logger, map[workload][]string{})
+		assert.Zero(t, pending())
+	})
+}
+
+func TestPendingReloads(t *testing.T) {
+	controller := newTestController(nil)
+	pending := controller.pendingReloads
+	app := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	worker := workload{name: "worker", namespace: "default", kind: DeploymentKind}
+
+	pending.add(app, []string{"secret/data/shared", "secret/data/app"})
+	pending.add(worker, []string{"secret/data/shared"})
+	assert.Equal(t, float64(2), testutil.ToFloat64(controller.metrics.pendingReloads))
+
+	// A shared path is pending until all of its workloads are reloaded
+	pending.done(app)
+	assert.Equal(t, float64(1), testutil.ToFloat64(controller.metrics.pendingReloads))
+	pending.done(worker)
+	assert.Zero(t, testutil.ToFloat64(controller.metrics.pendingReloads))
+}
@@ -0,0 +1,163 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const storeConfigMapKey = "workloads.json"
+
+// DefaultStoreFlushPeriod is the period the store is flushed at if StoreFlushPeriod is not set
+const DefaultStoreFlushPeriod = time.Minute
+
+// restoreStore loads the collected data flushed to the store ConfigMap by a
+// previous run, a missing ConfigMap means there is nothing to restore
+func (c *Controller) restoreStore(ctx context.Context) error {
+	var configMap *corev1.ConfigMap
+	err := c.retryStartup(ctx, "Reading the store ConfigMap", func(ctx context.Context) error {
+		var err error
+		configMap, err = c.kubeClient.CoreV1().ConfigMaps(c.collectorConfig.StoreNamespace).Get(
+			ctx,
+			c.collectorConfig.StoreConfigMap,
+			metav1.GetOptions{},
+		)
+		if apierrors.IsNotFound(err) {
+			configMap = nil
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read store ConfigMap: %w", err)
+	}
+	if configMap == nil {
+		c.logger.Info("No store ConfigMap found, skipping restore")
+		return nil
+	}
+
+	if err := c.workloadSecrets.Restore([]byte(configMap.Data[storeConfigMapKey])); err != nil {
+		return fmt.Errorf("failed to restore store from ConfigMap: %w", err)
+	}
+
+	// Drop workloads from namespaces that got excluded since the snapshot was taken
+	for workload := range c.workloadSecrets.GetWorkloadSecretsMap() {
+		if !c.collectorConfig.namespaceAllowed(workload.namespace) {
+			c.workloadSecrets.Delete(workload)
+		}
+	}
+
+	c.logger.Info(fmt.Sprintf("Restored store from ConfigMap %s/%s", configMap.Namespace, configMap.Name))
+	return nil
+}
+
+// flushStore writes the collected data to the store ConfigMap, creating it if needed
+func (c *Controller) flushStore(ctx context.Context) {
+	flusherLogger := c.logger.With(slog.String("worker", "flusher"))
+
+	// Only the leader writes the store, so replicas don't overwrite each other
+	if !c.isLeader() {
+		return
+	}
+
+	snapshot, err := c.workloadSecrets.Snapshot()
+	if err != nil {
+		flusherLogger.Error(fmt.Errorf("failed to snapshot store: %w", err).Error())
+		return
+	}
+
+	configMaps := c.kubeClient.CoreV1().ConfigMaps(c.collectorConfig.StoreNamespace)
+	configMap, err := configMaps.Get(ctx, c.collectorConfig.StoreConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      c.collectorConfig.StoreConfigMap,
+				Namespace: c.collectorConfig.StoreNamespace,
+			},
+			Data: map[string]string{storeConfigMapKey: string(snapshot)},
+		}, metav1.CreateOptions{})
+	} else if err == nil {
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		configMap.Data[storeConfigMapKey] = string(snapshot)
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		flusherLogger.Error(fmt.Errorf("failed to flush store to ConfigMap: %w", err).Error())
+		return
+	}
+
+	flusherLogger.Debug("Store flushed to ConfigMap")
+}
+
+// ExportSnapshot writes the tracked workloads to the writer as a single line of JSON,
+// in the format of the store ConfigMap with sorted secret paths, so that the dump is
+// stable and can be restored by a reloader running in another cluster
+func (c *Controller) ExportSnapshot(w io.Writer) error {
+	snapshot, err := c.workloadSecrets.Snapshot()
+	if err != nil {
+		return fmt.Errorf("failed to snapshot store: %w", err)
+	}
+
+	var workloads map[string][]string
+	if err := json.Unmarshal(snapshot, &workloads); err != nil {
+		return fmt.Errorf("failed to snapshot store: %w", err)
+	}
+	for _, secretPaths := range workloads {
+		slices.Sort(secretPaths)
+	}
+
+	// The keys of maps are sorted by the encoder
+	return json.NewEncoder(w).Encode(workloads)
+}
+
+// ExportSnapshotOnSignal starts writing a snapshot of the tracked workloads to the writer
+// every time one of the signals is received, without stopping the controller, until the
+// context is done. The signals are handled once it returns.
+func (c *Controller) ExportSnapshotOnSignal(ctx context.Context, w io.Writer, signals ...os.Signal) <-chan struct{} {
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, signals...)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer signal.Stop(signalCh)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-signalCh:
+				c.logger.Info(fmt.Sprintf("Received %s, exporting store snapshot", sig))
+				if err := c.ExportSnapshot(w); err != nil {
+					c.logger.Error(fmt.Errorf("failed to export store snapshot: %w", err).Error())
+				}
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,158 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestStorePersistence(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	deployment := workload{name: "app", namespace: "default", kind: DeploymentKind}
+
+	newController := func() *Controller {
+		controller := newTestController(kubeClient)
+		controller.collectorConfig.StoreConfigMap = "vault-secrets-reloader-store"
+		controller.collectorConfig.StoreNamespace = "bank-vaults-infra"
+		return controller
+	}
+
+	t.Run("restore without ConfigMap", func(t *testing.T) {
+		controller := newController()
+		assert.NoError(t, controller.restoreStore(context.Background()))
+		assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+	})
+
+	t.Run("flush and restore", func(t *testing.T) {
+		controller := newController()
+		controller.workloadSecrets.Store(deployment, []string{"secret/data/app"})
+		// flushing twice covers both creating and updating the ConfigMap
+		controller.flushStore(context.Background())
+		controller.flushStore(context.Background())
+
+		restarted := newController()
+		assert.NoError(t, restarted.restoreStore(context.Background()))
+		assert.Equal(t,
+			map[workload][]string{deployment: {"secret/data/app"}},
+			restarted.workloadSecrets.GetWorkloadSecretsMap(),
+		)
+	})
+
+	t.Run("restore retries failed reads", func(t *testing.T) {
+		failedReads := 0
+		kubeClient.PrependReactor("get", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+			if failedReads++; failedReads == 1 {
+				return true, nil, apierrors.NewServiceUnavailable("starting up")
+			}
+			return false, nil, nil
+		})
+
+		restarted := newController()
+		restarted.collectorConfig.StartupSyncAttempts = 3
+		restarted.collectorConfig.StartupSyncTimeout = 10 * time.Millisecond
+		assert.NoError(t, restarted.restoreStore(context.Background()))
+		assert.Equal(t,
+			map[workload][]string{deployment: {"secret/data/app"}},
+			restarted.workloadSecrets.GetWorkloadSecretsMap(),
+		)
+	})
+}
+
+func TestExportSnapshot(t *testing.T) {
+	controller := newTestController(nil)
+	deployment := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	daemonSet := workload{name: "agent", namespace: "monitoring", kind: DaemonSetKind}
+	controller.workloadSecrets.Store(deployment, []string{"secret/data/db", "secret/data/app"})
+	controller.workloadSecrets.Store(daemonSet, []string{"secret/data/agent"})
+
+	// validateSnapshot checks that the export is a single line mapping
+	// namespace/kind/name workload keys to sorted lists of secret paths
+	validateSnapshot := func(t *testing.T, line []byte) map[string][]string {
+		assert.Equal(t, 1, bytes.Count(line, []byte("\n")))
+		assert.True(t, bytes.HasSuffix(line, []byte("\n")))
+
+		var workloads map[string][]string
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		assert.NoError(t, decoder.Decode(&workloads))
+		for key, secretPaths := range workloads {
+			_, err := parseWorkloadKey(key)
+			assert.NoError(t, err)
+			assert.NotEmpty(t, secretPaths)
+			assert.True(t, slices.IsSorted(secretPaths))
+		}
+		return workloads
+	}
+
+	t.Run("export", func(t *testing.T) {
+		var buf bytes.Buffer
+		assert.NoError(t, controller.ExportSnapshot(&buf))
+
+		workloads := validateSnapshot(t, buf.Bytes())
+		assert.Equal(t, map[string][]string{
+			"default/Deployment/app":     {"secret/data/app", "secret/data/db"},
+			"monitoring/DaemonSet/agent": {"secret/data/agent"},
+		}, workloads)
+
+		// The export is stable and can be restored
+		var again bytes.Buffer
+		assert.NoError(t, controller.ExportSnapshot(&again))
+		assert.Equal(t, buf.String(), again.String())
+		restored := newWorkloadSecrets()
+		assert.NoError(t, restored.Restore(buf.Bytes()))
+		assert.Len(t, restored.GetWorkloadSecretsMap(), 2)
+	})
+
+	t.Run("on signal", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		reader, writer := io.Pipe()
+		done := controller.ExportSnapshotOnSignal(ctx, writer, syscall.SIGUSR1)
+		t.Cleanup(func() {
+			cancel()
+			<-done
+		})
+
+		line := make(chan []byte)
+		go func() {
+			l, _ := bufio.NewReader(reader).ReadBytes('\n')
+			line <- l
+		}()
+		assert.Eventually(t, func() bool {
+			_ = syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+			select {
+			case l := <-line:
+				validateSnapshot(t, l)
+				return true
+			case <-time.After(50 * time.Millisecond):
+				return false
+			}
+		}, 2*time.Second, 10*time.Millisecond)
+		// Unblock the pending writes of the repeated signals
+		go func() { _, _ = io.Copy(io.Discard, reader) }()
+	})
+}
@@ -0,0 +1,79 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const PodKind = "Pod"
+
+// podControllerKinds are the kinds of the collected workloads whose pods are tracked through them
+var podControllerKinds = []string{ReplicaSetKind, DaemonSetKind, StatefulSetKind, JobKind}
+
+// WatchPods collects the secrets of the Pods not controlled by a collected workload
+// from the informer, it has to be called before Run
+func (c *Controller) WatchPods(podInformer coreinformers.PodInformer) {
+	c.podsLister = podInformer.Lister()
+	c.podsSynced = podInformer.Informer().HasSynced
+
+	_, _ = podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.handleObject,
+		UpdateFunc: func(old, new interface{}) { c.handleObject(new) },
+		DeleteFunc: c.handleObjectDelete,
+	})
+}
+
+// isControlledByCollectedWorkload reports whether a Pod is controlled by a workload
+// of a kind that is collected, and so reloaded through it
+func isControlledByCollectedWorkload(pod *corev1.Pod) bool {
+	owner := metav1.GetControllerOf(pod)
+	return owner != nil && slices.Contains(podControllerKinds, owner.Kind)
+}
+
+// podTemplate returns the metadata and spec of a Pod as a pod template, to collect its secrets
+func podTemplate(pod *corev1.Pod) corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec}
+}
+
+// reloadPod deletes a Pod controlled by another workload, so that its controller recreates
+// it with the current secrets, bare Pods would be gone for good and are only logged
+func (c *Controller) reloadPod(workload workload) (runtime.Object, error) {
+	pod, err := c.kubeClient.CoreV1().Pods(workload.namespace).Get(context.Background(), workload.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	if metav1.GetControllerOf(pod) == nil {
+		c.logger.Warn(fmt.Sprintf("Skipping reload of %s, it has no controller to recreate it, it has to be recreated manually", workload))
+		return nil, nil
+	}
+
+	err = c.kubeClient.CoreV1().Pods(workload.namespace).Delete(context.Background(), workload.name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return pod, err
+	}
+	c.logger.Info(fmt.Sprintf("Deleted pod of workload: %s", workload))
+	return pod, nil
+}
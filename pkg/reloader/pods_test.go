@@ -0,0 +1,107 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestPod(name string, owners ...metav1.OwnerReference) *corev1.Pod {
+	template := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "default",
+			Annotations:     template.Annotations,
+			OwnerReferences: owners,
+		},
+		Spec: template.Spec,
+	}
+}
+
+func controllerReference(kind string, name string) metav1.OwnerReference {
+	controller := true
+	return metav1.OwnerReference{APIVersion: "v1", Kind: kind, Name: name, Controller: &controller}
+}
+
+func TestHandleObjectPod(t *testing.T) {
+	controller := newTestController(nil)
+
+	bare := newTestPod("bare")
+	operated := newTestPod("operated", controllerReference("Workflow", "nightly"))
+	replicated := newTestPod("app-5d8f7b9c4-x2x4z", controllerReference(ReplicaSetKind, "app-5d8f7b9c4"))
+
+	controller.handleObject(bare)
+	controller.handleObject(operated)
+	controller.handleObject(replicated)
+
+	// The Pods of a ReplicaSet are tracked through it
+	assert.Equal(t,
+		map[workload][]string{
+			{name: "bare", namespace: "default", kind: PodKind}:     {"secret/data/app"},
+			{name: "operated", namespace: "default", kind: PodKind}: {"secret/data/app"},
+		},
+		controller.workloadSecrets.GetWorkloadSecretsMap(),
+	)
+
+	controller.handleObjectDelete(replicated)
+	assert.Len(t, controller.workloadSecrets.GetWorkloadSecretsMap(), 2)
+	controller.handleObjectDelete(bare)
+	controller.handleObjectDelete(operated)
+	assert.Empty(t, controller.workloadSecrets.GetWorkloadSecretsMap())
+}
+
+func TestReloadPod(t *testing.T) {
+	findPod := func(kubeClient *fake.Clientset, name string) bool {
+		_, err := kubeClient.CoreV1().Pods("default").Get(context.Background(), name, metav1.GetOptions{})
+		return err == nil
+	}
+
+	t.Run("owned", func(t *testing.T) {
+		kubeClient := fake.NewSimpleClientset(newTestPod("operated", controllerReference("Workflow", "nightly")))
+		controller := newTestController(kubeClient)
+
+		obj, err := controller.reloadWorkload(workload{name: "operated", namespace: "default", kind: PodKind}, "")
+		assert.NoError(t, err)
+		assert.NotNil(t, obj)
+
+		// The Pod is deleted for its controller to recreate it
+		assert.False(t, findPod(kubeClient, "operated"))
+	})
+
+	t.Run("bare", func(t *testing.T) {
+		kubeClient := fake.NewSimpleClientset(newTestPod("bare"))
+		controller := newTestController(kubeClient)
+		var logs bytes.Buffer
+		controller.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+		obj, err := controller.reloadWorkload(workload{name: "bare", namespace: "default", kind: PodKind}, "")
+		assert.NoError(t, err)
+		assert.Nil(t, obj)
+
+		// Nothing would recreate the Pod, so it is kept
+		assert.True(t, findPod(kubeClient, "bare"))
+		assert.Contains(t, logs.String(), "level=WARN")
+		assert.Contains(t, logs.String(), "it has no controller to recreate it")
+	})
+}
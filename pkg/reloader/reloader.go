@@ -16,159 +16,1326 @@ package reloader
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 )
 
-func (c *Controller) runReloader(ctx context.Context) { //nolint:revive
+// CronJobReloadStrategy determines what happens to a CronJob when its secrets change
+type CronJobReloadStrategy string
+
+const (
+	// CronJobReloadNone only collects CronJobs, as every scheduled Job gets
+	// the current secret versions injected anyway
+	CronJobReloadNone CronJobReloadStrategy = "none"
+	// CronJobReloadNextSchedule increments the reload count in the job template,
+	// so the Job created on the next schedule is recreated from an updated template
+	CronJobReloadNextSchedule CronJobReloadStrategy = "next-schedule"
+)
+
+// ReloadStrategy determines how the pods of a Deployment, DaemonSet or StatefulSet
+// are restarted when its secrets change
+type ReloadStrategy string
+
+const (
+	// ReloadRolloutRestart increments the reload count annotation in the pod template,
+	// so that the pods are replaced by a rollout
+	ReloadRolloutRestart ReloadStrategy = "RolloutRestart"
+	// ReloadDeletePods deletes the pods of the workload, so that they are recreated at once
+	ReloadDeletePods ReloadStrategy = "DeletePods"
+)
+
+// ChangeDetection determines how changes of the secrets in KV version 2 mounts are detected,
+// the secrets in KV version 1 mounts have no version so their content hash is always compared
+type ChangeDetection string
+
+const (
+	// ChangeDetectionVersion compares the version in the metadata of the secrets
+	ChangeDetectionVersion ChangeDetection = "version"
+	// ChangeDetectionContentHash compares the SHA-256 hash of the data of the secrets,
+	// for backends not bumping the version on every change
+	ChangeDetectionContentHash ChangeDetection = "content-hash"
+)
+
+// PatchStrategy determines the type of the patches recording the reloads in the workloads,
+// as admission controllers and cluster setups handle them differently
+type PatchStrategy string
+
+const (
+	// PatchServerSideApply applies the reload annotations with server-side apply, so that
+	// their ownership is recorded for the field manager
+	PatchServerSideApply PatchStrategy = "server-side-apply"
+	// PatchStrategicMerge sends the reload annotations in a strategic merge patch
+	PatchStrategicMerge PatchStrategy = "strategic-merge"
+	// PatchJSONMerge sends the reload annotations in a JSON merge patch
+	PatchJSONMerge PatchStrategy = "json-merge"
+)
+
+// MissingSecretPolicy determines what happens when a tracked secret path is not found in Vault
+type MissingSecretPolicy string
+
+const (
+	// MissingSecretIgnore only logs missing secrets at debug level and keeps tracking them
+	MissingSecretIgnore MissingSecretPolicy = "ignore"
+	// MissingSecretWarn logs missing secrets as warnings and counts them in a metric
+	MissingSecretWarn MissingSecretPolicy = "warn"
+	// MissingSecretUntrack removes missing secrets from all workloads
+	MissingSecretUntrack MissingSecretPolicy = "untrack"
+)
+
+const (
+	secretReloadedEventReason = "SecretReloaded"
+	reloadFailedEventReason   = "SecretReloadFailed"
+
+	defaultReconcileInterval = 60 * time.Second
+	defaultShutdownTimeout   = 30 * time.Second
+	// minReconcileInterval is the shortest reconcile interval a workload can set
+	minReconcileInterval = 10 * time.Second
+)
+
+// ReloaderConfig holds the settings of the reloader worker
+type ReloaderConfig struct {
+	// ReconcileInterval is the minimum time between two reloader runs, a random
+	// duration of up to ReconcileJitter is added to it to spread Vault requests
+	// of multiple replicas
+	ReconcileInterval time.Duration
+	ReconcileJitter   time.Duration
+	// NamespaceReconcileIntervals override ReconcileInterval for the workloads of the
+	// listed namespaces, the reloader runs as often as the shortest interval requires
+	// and only checks the secrets of the workloads whose interval elapsed
+	NamespaceReconcileIntervals map[string]time.Duration
+	CronJobReloadStrategy       CronJobReloadStrategy
+	// ReloadStrategy is the way workloads are reloaded, it can be overridden per
+	// workload with ReloadStrategyAnnotationName, defaults to ReloadRolloutRestart
+	ReloadStrategy ReloadStrategy
+	// ChangeDetection is the way changes of secrets are detected, defaults to ChangeDetectionVersion
+	ChangeDetection ChangeDetection
+	// ReloadMetadataKey is a custom metadata key of KV version 2 secrets whose value changing
+	// reloads the workloads like a new version does, when changes are detected by version
+	ReloadMetadataKey string
+	// DryRun only logs the workloads that would be reloaded without updating them
+	DryRun bool
+	// MissingSecretPolicy is applied to the tracked secret paths not found in Vault, if empty
+	// they are logged as errors unless VAULT_IGNORE_MISSING_SECRETS is set
+	MissingSecretPolicy MissingSecretPolicy
+	// ReloadCooldown is the minimum time between two reloads of the same workload,
+	// reloads within it are deferred until it elapses
+	ReloadCooldown time.Duration
+	LeaderElection LeaderElectionConfig
+	// ReloadMaxAttempts is the number of times a reload failing with a transient
+	// API error is attempted, waiting ReloadRetryBackoff doubled on each retry
+	ReloadMaxAttempts  int
+	ReloadRetryBackoff time.Duration
+	// MaxConcurrentReloads is the number of workloads reloaded at the same time by the
+	// controller, the other ones wait for a reload to finish, one at a time if not set
+	MaxConcurrentReloads int
+	// ReloadHooks are notified before and after every reload
+	ReloadHooks ReloadHooksConfig
+	// ShutdownTimeout is the time given to the reload in progress to finish
+	// and to the store to be flushed on shutdown
+	ShutdownTimeout time.Duration
+	// PatchStrategy is the type of the reload patches, defaults to PatchServerSideApply
+	PatchStrategy PatchStrategy
+	// FieldManager owns the reload annotations applied to the workloads, so that GitOps
+	// tools can exclude them from drift detection, defaults to defaultFieldManager
+	FieldManager string
+	// RestartAnnotation is the pod template annotation holding the reload count that is
+	// bumped to restart the pods, defaults to ReloadCountAnnotationName. Other tools setting
+	// it, like kubectl rollout restart does for kubectl.kubernetes.io/restartedAt, restart
+	// the pods too, and lose its ownership to the reloader on the next reload
+	RestartAnnotation string
+	// InitialGracePeriod is the time after a workload created while the reloader runs is
+	// first collected during which it is not reloaded, the versions of its secrets are
+	// only recorded as its pods were just started with them
+	InitialGracePeriod time.Duration
+	// VersionCacheTTL is the time the versions of the secrets looked up in Vault are served
+	// from a cache of up to VersionCacheSize versions, nothing is cached if it is not set
+	VersionCacheTTL  time.Duration
+	VersionCacheSize int
+	// VaultUnavailableBackoff is the time waited before retrying a reloader run Vault was
+	// unavailable in, doubled on each consecutive one, instead of the reconcile interval
+	VaultUnavailableBackoff time.Duration
+	// CollectOnly only collects the secrets of the workloads, the reloader neither connects
+	// to Vault nor reloads any workload
+	CollectOnly bool
+	// MetricsRegisterer registers the metrics of the controller, defaults to
+	// prometheus.DefaultRegisterer. Controllers sharing a registerer share their metrics.
+	MetricsRegisterer prometheus.Registerer
+}
+
+// defaultFieldManager is the field manager of the reload patches if none is configured
+const defaultFieldManager = "vault-secrets-reloader"
+
+// restartAnnotation returns the pod template annotation bumped to restart the pods
+func (c ReloaderConfig) restartAnnotation() string {
+	if c.RestartAnnotation == "" {
+		return ReloadCountAnnotationName
+	}
+	return c.RestartAnnotation
+}
+
+// fieldManager returns the field manager the reload patches are applied with
+func (c ReloaderConfig) fieldManager() string {
+	if c.FieldManager == "" {
+		return defaultFieldManager
+	}
+	return c.FieldManager
+}
+
+// vaultUnavailableBackoff returns the time to wait before retrying a reloader run after the
+// given number of consecutive runs Vault was unavailable in, 0 if the runs are not retried
+func (c ReloaderConfig) vaultUnavailableBackoff(unavailableRuns int) time.Duration {
+	if c.VaultUnavailableBackoff <= 0 {
+		return 0
+	}
+	// Stop doubling once the backoff exceeds any sensible reconcile interval
+	return c.VaultUnavailableBackoff << min(unavailableRuns, 16)
+}
+
+// nextReconcileInterval returns the time to wait before the next reloader run,
+// randomized between ReconcileInterval and ReconcileInterval+ReconcileJitter
+func (c ReloaderConfig) nextReconcileInterval() time.Duration {
+	interval := c.reconcileInterval("")
+	for _, namespaceInterval := range c.NamespaceReconcileIntervals {
+		if namespaceInterval > 0 {
+			interval = min(interval, namespaceInterval)
+		}
+	}
+	if c.ReconcileJitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Int63n(int64(c.ReconcileJitter)+1))
+}
+
+// reconcileInterval returns the interval the secrets of the workloads of a namespace are checked at
+func (c ReloaderConfig) reconcileInterval(namespace string) time.Duration {
+	if interval := c.NamespaceReconcileIntervals[namespace]; interval > 0 {
+		return interval
+	}
+	if c.ReconcileInterval <= 0 {
+		return defaultReconcileInterval
+	}
+	return c.ReconcileInterval
+}
+
+// nextReconcileInterval returns the time to wait before the next reloader run, which is
+// shortened to the shortest reconcile interval set by a workload
+func (c *Controller) nextReconcileInterval() time.Duration {
+	interval := c.reloaderConfig.nextReconcileInterval()
+	for _, workloadInterval := range c.workloadSecrets.GetReconcileIntervals() {
+		interval = min(interval, workloadInterval)
+	}
+	return interval
+}
+
+// dueSecretWorkloads returns the secret paths with a workload whose reconcile interval
+// elapsed since the secrets of its interval were last checked, the workloads are bucketed
+// by interval so that the ones of the namespaces and workloads sharing an interval are
+// checked together
+func (c *Controller) dueSecretWorkloads(now time.Time, secretWorkloads map[string][]workload) map[string][]workload {
+	workloadIntervals := c.workloadSecrets.GetReconcileIntervals()
+	if len(c.reloaderConfig.NamespaceReconcileIntervals) == 0 && len(workloadIntervals) == 0 {
+		return secretWorkloads
+	}
+
+	dueIntervals := make(map[time.Duration]bool)
+	isDue := func(workload workload) bool {
+		interval, ok := workloadIntervals[workload]
+		if !ok {
+			interval = c.reloaderConfig.reconcileInterval(workload.namespace)
+		}
+		due, ok := dueIntervals[interval]
+		if !ok {
+			lastCheck, checked := c.intervalChecks[interval]
+			due = !checked || now.Sub(lastCheck) >= interval
+			dueIntervals[interval] = due
+		}
+		return due
+	}
+
+	due := make(map[string][]workload, len(secretWorkloads))
+	for secretPath, workloads := range secretWorkloads {
+		// A changed secret reloads all of its workloads, even the ones that are not due,
+		// as its new version is stored
+		if slices.ContainsFunc(workloads, isDue) {
+			due[secretPath] = workloads
+		}
+	}
+
+	for interval, intervalDue := range dueIntervals {
+		if intervalDue {
+			c.intervalChecks[interval] = now
+		}
+	}
+	return due
+}
+
+// runReloaderLoop runs the reloader until the context is cancelled, waiting a
+// jittered interval after each run, or a backoff after the runs Vault was unavailable in
+func (c *Controller) runReloaderLoop(ctx context.Context) {
 	reloaderLogger := c.logger.With(slog.String("worker", "reloader"))
-	reloaderLogger.Info("Reloader started")
+	var unavailableRuns int
+	for ctx.Err() == nil {
+		interval := c.nextReconcileInterval()
+		if c.runReloader(ctx) {
+			c.metrics.vaultUnavailable.Inc()
+			if backoff := c.reloaderConfig.vaultUnavailableBackoff(unavailableRuns); backoff > 0 && backoff < interval {
+				reloaderLogger.Info(fmt.Sprintf("Vault is unavailable, retrying the reloader run in %s", backoff))
+				interval = backoff
+			}
+			unavailableRuns++
+		} else {
+			unavailableRuns = 0
+		}
 
-	if len(c.workloadSecrets.GetWorkloadSecretsMap()) == 0 {
-		reloaderLogger.Info("No workloads to reload")
+		c.reloadSecretConsumers(ctx, reloaderLogger)
+
+		// The consumers of the Secrets whose data changed are reloaded while waiting
+		timer := time.NewTimer(interval)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-c.secretReloadsReady:
+				c.reloadSecretConsumers(ctx, reloaderLogger)
+			case <-timer.C:
+				break wait
+			}
+		}
+	}
+}
+
+// runReloader checks the secrets of the workloads due for it and reloads the ones whose
+// secrets changed, it reports whether Vault was unavailable so that the run is retried
+func (c *Controller) runReloader(ctx context.Context) (vaultUnavailable bool) { //nolint:revive
+	reloaderLogger := c.logger.With(slog.String("worker", "reloader"))
+	if c.reloaderConfig.CollectOnly {
+		reloaderLogger.Debug("Collect only mode, skipping reloader run")
 		return
 	}
 
+	// The Vault client is initialized even without anything to reload,
+	// since the controller is only ready once it authenticated
 	err := c.initVaultClient()
 	if err != nil {
-		reloaderLogger.Error("failed to initialize Vault client: ", err)
+		err = fmt.Errorf("failed to initialize Vault client: %w", err)
+		reloaderLogger.Error(err.Error())
+		c.status.recordReconcile(err)
+		return true
+	}
+
+	reloaderLogger.Info("Reloader started")
+
+	// The cycle failed if any secret could not be checked, the last error is reported
+	var reconcileErr error
+	defer func() { c.status.recordReconcile(reconcileErr) }()
+
+	ctx, span := c.tracer.Start(ctx, "reconcile")
+	defer span.End()
+
+	// Summarize the cycle in a single line once it is over
+	start := time.Now()
+	var workloadsChecked, secretsQueried, reloadsTriggered int
+	defer func() {
+		reloaderLogger.Info("Reconcile finished",
+			slog.Int("workloads_checked", workloadsChecked),
+			slog.Int("secrets_queried", secretsQueried),
+			slog.Int("reloads_triggered", reloadsTriggered),
+			slog.Duration("duration", time.Since(start)))
+	}()
+
+	if len(c.workloadSecrets.GetWorkloadSecretsMap()) == 0 {
+		reloaderLogger.Info("No workloads to reload")
 		return
 	}
 
 	// Create a secretWorkloads map and compare the currently used secrets' version
-	// with the one stored in the secretVersions map, while creating a new secretVersions map
-	workloadsToReload := make(map[workload]bool)
-	newSecretVersions := make(map[string]int)
-	for secretPath, workloads := range c.workloadSecrets.GetSecretWorkloadsMap() {
+	// with the one kept in the store
+	workloadsToReload := make(reloadQueue)
+	trackedSecretWorkloads := c.workloadSecrets.GetSecretWorkloadsMap()
+	secretWorkloads := c.expandWildcardSecrets(ctx, reloaderLogger, trackedSecretWorkloads, workloadsToReload)
+	// checkSecretWorkloads marks the workloads of a secret path for reload if the secret changed
+	checkSecretWorkloads := func(secretPath string, workloads []workload) {
 		reloaderLogger.Debug(fmt.Sprintf("Checking secret: %s", secretPath))
-		// Get current secret version
-		currentVersion, err := getSecretVersionFromVault(c.vaultClient.Logical(), secretPath)
+		if !c.secretMountAllowed(secretPath) {
+			reloaderLogger.Debug(ErrMountNotAllowed{secretPath: secretPath}.Error())
+			return
+		}
+		secretsQueried++
+		vaultClient, path, err := c.secretClient(secretPath)
+		if err != nil {
+			reloaderLogger.Error(err.Error())
+			reconcileErr = err
+			vaultUnavailable = true
+			return
+		}
+		changed, err := c.checkSecret(ctx, reloaderLogger, vaultClient, secretPath, path)
 		if err != nil {
 			switch err.(type) {
 			case ErrSecretNotFound:
-				if !c.vaultConfig.IgnoreMissingSecrets {
-					reloaderLogger.Error(err.Error())
+				c.handleMissingSecret(reloaderLogger, secretPath, err)
+				return
+
+			default:
+				reconcileErr = fmt.Errorf("failed to get secret version from Vault: %w", err)
+				reloaderLogger.Error(reconcileErr.Error())
+				if vaultLookupErrorType(err) == vaultLookupErrorTransport {
+					vaultUnavailable = true
 				}
-				if c.vaultConfig.IgnoreMissingSecrets {
-					reloaderLogger.Warn(fmt.Sprintf(
-						"Path not found: %s - We couldn't find a secret path. This is not an error since missing secrets can be ignored according to the configuration you've set (env: VAULT_IGNORE_MISSING_SECRETS).",
-						secretPath,
-					))
+				return
+			}
+		}
+		if changed {
+			for _, workload := range workloads {
+				if c.inInitialGracePeriod(workload) {
+					reloaderLogger.Info(fmt.Sprintf("Skipping reload of %s, it was created less than %s ago", workload, c.reloaderConfig.InitialGracePeriod),
+						slog.String("secret_path", secretPath))
+					continue
 				}
-				continue
+				workloadsToReload.add(workload, secretPath)
+			}
+		}
+	}
 
-			default:
-				reloaderLogger.Error("failed to get secret version from Vault: ", err)
-				continue
+	checkStart := time.Now()
+	dueSecretWorkloads := c.dueSecretWorkloads(checkStart, secretWorkloads)
+	checkedWorkloads := make(map[workload]bool)
+	for secretPath, workloads := range dueSecretWorkloads {
+		checkSecretWorkloads(secretPath, workloads)
+		for _, workload := range workloads {
+			checkedWorkloads[workload] = true
+		}
+	}
+	workloadsChecked = len(checkedWorkloads)
+
+	// The versions served from the cache may be outdated, they are looked up again for the
+	// workloads about to be reloaded anyway, so that a single reload picks up all their changes
+	if len(workloadsToReload) > 0 {
+		for secretPath, workloads := range dueSecretWorkloads {
+			reloaded := slices.ContainsFunc(workloads, func(workload workload) bool {
+				return workloadsToReload.has(workload)
+			})
+			if reloaded && c.versionCache.invalidateBefore(secretPath, checkStart) {
+				checkSecretWorkloads(secretPath, workloads)
 			}
 		}
+	}
+
+	// Standbys keep the versions current and the workloads they found changed pending, so
+	// that the changes during a failover are not missed, the versioned reloads started once
+	// leading skip the workloads the previous leader already reloaded for these versions
+	if !c.isLeader() {
+		reloaderLogger.Debug("Not the leader, deferring the reloads until leading")
+		for workload, changedSecretPaths := range workloadsToReload {
+			c.deferredReloads.add(workload, changedSecretPaths...)
+		}
+	} else {
+		// Reloading workloads
+		reloadsTriggered = c.reloadWorkloads(ctx, reloaderLogger, workloadsToReload)
+	}
+
+	// Drop the versions and hashes of secrets that are not used anymore
+	checkedSecretPaths := make([]string, 0, len(secretWorkloads))
+	for secretPath := range secretWorkloads {
+		checkedSecretPaths = append(checkedSecretPaths, secretPath)
+	}
+	c.workloadSecrets.PruneVersions(checkedSecretPaths)
+	for secretPath := range c.kvMounts {
+		_, tracked := trackedSecretWorkloads[secretPath]
+		if _, expanded := secretWorkloads[secretPath]; !tracked && !expanded {
+			delete(c.kvMounts, secretPath)
+		}
+	}
+
+	if len(workloadsToReload) == 0 {
+		reloaderLogger.Info("No workloads to reload")
+	}
+
+	return vaultUnavailable
+}
+
+// secretMountAllowed tells whether a tracked secret path is in one of AllowedMounts, as the
+// paths restored from the store may be of a mount that is not allowed anymore
+func (c *Controller) secretMountAllowed(secretPath string) bool {
+	_, namespacedPath := splitVaultAddrSecretPath(secretPath)
+	_, path := splitNamespacedSecretPath(namespacedPath)
+	return c.collectorConfig.mountAllowed(path)
+}
+
+// inInitialGracePeriod tells whether a workload was first collected within InitialGracePeriod
+func (c *Controller) inInitialGracePeriod(workload workload) bool {
+	firstSeen, ok := c.workloadSecrets.GetFirstSeen(workload)
+	return ok && time.Since(firstSeen) < c.reloaderConfig.InitialGracePeriod
+}
+
+// checkSecret compares the current version of a tracked secret, or the hash of its contents
+// if it has no version or ChangeDetectionContentHash is set, with the one kept in the store,
+// storing the current one. It reports whether the secret changed since the previous check.
+func (c *Controller) checkSecret(ctx context.Context, logger *slog.Logger, vaultClient VaultClient, secretPath string, path string) (bool, error) {
+	_, lookupSpan := c.tracer.Start(ctx, "vault.lookup", trace.WithAttributes(attribute.String("secret_path", secretPath)))
+	defer lookupSpan.End()
+
+	var currentVersion int
+	var currentHash string
+	var metadataChanged bool
+	var err error
+	mount := c.kvMount(ctx, logger, vaultClient, secretPath, path)
+	if mount.Version == notKVMount {
+		// Dynamic secrets rotate with their lease, not with a version, so there is nothing to compare
+		logger.Debug(fmt.Sprintf("Secret %s is not a KV secret, skipping it", secretPath))
+		return false, nil
+	}
+	if mount.Version == 1 || c.reloaderConfig.ChangeDetection == ChangeDetectionContentHash {
+		currentHash, err = vaultClient.SecretHash(ctx, path, mount.Version)
+	} else if c.reloaderConfig.ReloadMetadataKey != "" {
+		// The custom metadata changes without a new version, so it is never served from the cache
+		var customMetadata map[string]string
+		currentVersion, customMetadata, err = vaultClient.SecretMetadata(ctx, path, mount.Path)
+		if err == nil {
+			c.versionCache.add(secretPath, currentVersion, time.Now())
+			metadataChanged = c.checkMetadataValue(logger, secretPath, customMetadata)
+		}
+	} else if version, ok := c.versionCache.get(secretPath, time.Now()); ok {
+		logger.Debug(fmt.Sprintf("Using the cached version of secret %s", secretPath))
+		currentVersion = version
+	} else {
+		currentVersion, err = vaultClient.SecretVersion(ctx, path, mount.Path)
+		if err == nil {
+			c.versionCache.add(secretPath, currentVersion, time.Now())
+		}
+	}
+	if err != nil {
+		lookupSpan.RecordError(err)
+		lookupSpan.SetStatus(codes.Error, err.Error())
+		c.metrics.countVaultLookupError(mount.mountPathOf(path), err)
+		return false, err
+	}
+
+	// KV version 1 secrets have no version, compare their hash with the one kept in the store
+	if currentHash != "" {
+		previousHash, ok := c.workloadSecrets.GetHash(secretPath)
+		c.workloadSecrets.SetHash(secretPath, currentHash)
+		if !ok {
+			logger.Debug(fmt.Sprintf("Secret %s has no stored hash, storing it", secretPath))
+			return false, nil
+		}
+		if previousHash == currentHash {
+			logger.Debug(fmt.Sprintf("Secret %s did not change", secretPath))
+			return false, nil
+		}
+		logger.Info(fmt.Sprintf("Secret %s contents changed", secretPath), slog.String("secret_path", secretPath))
+		return true, nil
+	}
+
+	// Compare current version with the one kept in the store
+	storedVersion, ok := c.workloadSecrets.GetVersion(secretPath)
+	c.workloadSecrets.SetVersion(secretPath, currentVersion)
+	if !ok {
+		logger.Debug(fmt.Sprintf("Secret %s has no stored version, storing it", secretPath))
+		return metadataChanged, nil
+	}
+	if storedVersion == currentVersion {
+		if !metadataChanged {
+			logger.Debug(fmt.Sprintf("Secret %s did not change", secretPath))
+		}
+		return metadataChanged, nil
+	}
+	logger.Info(fmt.Sprintf("Secret %s changed, version stored: %d current: %d", secretPath, storedVersion, currentVersion),
+		slog.String("secret_path", secretPath),
+		slog.Int("old_version", storedVersion),
+		slog.Int("new_version", currentVersion),
+	)
+	return true, nil
+}
+
+// checkMetadataValue stores the value of the ReloadMetadataKey custom metadata of a secret,
+// returning whether it changed since it was last stored, a missing key having an empty value
+func (c *Controller) checkMetadataValue(logger *slog.Logger, secretPath string, customMetadata map[string]string) bool {
+	key := c.reloaderConfig.ReloadMetadataKey
+	currentValue := customMetadata[key]
+	storedValue, ok := c.workloadSecrets.GetMetadataValue(secretPath)
+	c.workloadSecrets.SetMetadataValue(secretPath, currentValue)
+	if !ok || storedValue == currentValue {
+		return false
+	}
+	logger.Info(fmt.Sprintf("Secret %s custom metadata %s changed", secretPath, key), slog.String("secret_path", secretPath))
+	return true
+}
+
+// handleMissingSecret applies the MissingSecretPolicy to a tracked secret path not found in Vault
+func (c *Controller) handleMissingSecret(logger *slog.Logger, secretPath string, err error) {
+	switch c.reloaderConfig.MissingSecretPolicy {
+	case MissingSecretIgnore:
+		logger.Debug(err.Error())
+
+	case MissingSecretWarn:
+		logger.Warn(err.Error(), slog.String("secret_path", secretPath))
+		c.metrics.missingSecrets.Inc()
+
+	case MissingSecretUntrack:
+		logger.Info(fmt.Sprintf("Untracking secret path %s, it was not found in Vault", secretPath), slog.String("secret_path", secretPath))
+		c.workloadSecrets.UntrackSecretPath(secretPath)
+
+	default:
+		if !c.vaultConfig.IgnoreMissingSecrets {
+			logger.Error(err.Error())
+			return
+		}
+		logger.Warn(fmt.Sprintf(
+			"Path not found: %s - We couldn't find a secret path. This is not an error since missing secrets can be ignored according to the configuration you've set (env: VAULT_IGNORE_MISSING_SECRETS).",
+			secretPath,
+		))
+	}
+}
 
-		// Compare current version with the secretVersions map
-		if c.secretVersions[secretPath] == 0 {
-			reloaderLogger.Debug(fmt.Sprintf("Secret %s not found in secretVersions map, creating it", secretPath))
-			newSecretVersions[secretPath] = currentVersion
+// expandWildcardSecrets replaces the tracked secret paths ending with "/*" with the
+// paths of the secrets below them, listed from Vault on every run. Workloads using
+// a wildcard path are reloaded if a secret appears below it or disappears from it.
+func (c *Controller) expandWildcardSecrets(ctx context.Context, logger *slog.Logger, secretWorkloads map[string][]workload, workloadsToReload reloadQueue) map[string][]workload {
+	expanded := make(map[string][]workload, len(secretWorkloads))
+	wildcardSecrets := make(map[string][]string)
+	for secretPath, workloads := range secretWorkloads {
+		if !isWildcardSecretPath(secretPath) {
+			expanded[secretPath] = append(expanded[secretPath], workloads...)
 			continue
 		}
-		if c.secretVersions[secretPath] == currentVersion {
-			reloaderLogger.Debug(fmt.Sprintf("Secret %s did not change", secretPath))
-			newSecretVersions[secretPath] = currentVersion
+		if !c.secretMountAllowed(secretPath) {
+			logger.Debug(ErrMountNotAllowed{secretPath: secretPath}.Error())
 			continue
 		}
-		reloaderLogger.Debug(fmt.Sprintf("Secret version stored: %d current: %d", c.secretVersions[secretPath], currentVersion))
-		for _, workload := range workloads {
-			workloadsToReload[workload] = true
+
+		_, listSpan := c.tracer.Start(ctx, "vault.list", trace.WithAttributes(attribute.String("secret_path", secretPath)))
+		vaultClient, path, err := c.secretClient(secretPath)
+		var childPaths []string
+		if err == nil {
+			childPaths, err = vaultClient.ListSecrets(ctx, strings.TrimSuffix(path, "/*"), c.kvMount(ctx, logger, vaultClient, secretPath, path))
+		}
+		if err != nil {
+			listSpan.RecordError(err)
+			listSpan.SetStatus(codes.Error, err.Error())
+		}
+		listSpan.End()
+		previousChildPaths, listedBefore := c.wildcardSecrets[secretPath]
+		if err != nil {
+			logger.Error(fmt.Errorf("failed to list secrets below %s: %w", secretPath, err).Error())
+			// Keep checking the secrets found by the previous run
+			if listedBefore {
+				wildcardSecrets[secretPath] = previousChildPaths
+			}
+			for _, childPath := range previousChildPaths {
+				expanded[childPath] = append(expanded[childPath], workloads...)
+			}
+			continue
+		}
+		// Track the secrets with the Vault server and namespace of the wildcard path
+		if trackedPrefix := strings.TrimSuffix(secretPath, path); trackedPrefix != "" {
+			for i, childPath := range childPaths {
+				childPaths[i] = trackedPrefix + childPath
+			}
+		}
+		wildcardSecrets[secretPath] = childPaths
+
+		if listedBefore && !slices.Equal(previousChildPaths, childPaths) {
+			logger.Info(fmt.Sprintf("Secrets below %s changed", secretPath), slog.String("secret_path", secretPath))
+			for _, workload := range workloads {
+				workloadsToReload.add(workload, secretPath)
+			}
+		}
+
+		for _, childPath := range childPaths {
+			expanded[childPath] = append(expanded[childPath], workloads...)
+		}
+	}
+	c.wildcardSecrets = wildcardSecrets
+
+	return expanded
+}
+
+// notKVMount is the version of the mount kvMount returns for secret paths of other secrets engines
+const notKVMount = 0
+
+// dynamicSecretPathRegexp matches the paths of the dynamic secrets of the common secrets
+// engines, like database/creds/readonly or aws/sts/deploy
+var dynamicSecretPathRegexp = regexp.MustCompile(`^[^/]+/(creds|static-creds|sts|issue|sign)/`)
+
+func isDynamicSecretPath(secretPath string) bool {
+	return dynamicSecretPathRegexp.MatchString(secretPath)
+}
+
+// kvMount returns the cached KV secrets engine mount of a tracked secret path, with the
+// notKVMount version for dynamic secrets, assuming version 2 of an unknown mount path
+// if it cannot be detected
+func (c *Controller) kvMount(ctx context.Context, logger *slog.Logger, vaultClient VaultClient, secretPath string, path string) KVMount {
+	if mount, ok := c.kvMounts[secretPath]; ok {
+		return mount
+	}
+
+	mount, err := vaultClient.KVMount(ctx, path)
+	if err != nil {
+		if _, ok := err.(ErrNotKVMount); ok {
+			logger.Debug(err.Error())
+			c.kvMounts[secretPath] = KVMount{Version: notKVMount}
+			return KVMount{Version: notKVMount}
+		}
+		// Without access to the mounts, fall back to recognizing dynamic secrets by their path
+		if isDynamicSecretPath(path) {
+			logger.Debug(fmt.Errorf("failed to detect KV version of secret %s, assuming a dynamic secret: %w", secretPath, err).Error())
+			return KVMount{Version: notKVMount}
+		}
+		logger.Debug(fmt.Errorf("failed to detect KV version of secret %s, assuming version 2: %w", secretPath, err).Error())
+		return KVMount{Version: 2}
+	}
+	c.kvMounts[secretPath] = mount
+	return mount
+}
+
+// reloadQueue holds the workloads to reload in a reloader run keyed by workload, so that
+// a workload is reloaded once with all its changed secret paths, however many of them
+// changed and however many times they were found changed in the run
+type reloadQueue map[workload][]string
+
+// add queues the reload of a workload for the changed secret paths, merging them
+// with the ones it is already queued for
+func (q reloadQueue) add(pending workload, changedSecretPaths ...string) {
+	changedSecretPaths = append(slices.Clone(q[pending]), changedSecretPaths...)
+	slices.Sort(changedSecretPaths)
+	q[pending] = slices.Compact(changedSecretPaths)
+}
+
+// has reports whether the reload of a workload is queued
+func (q reloadQueue) has(pending workload) bool {
+	_, ok := q[pending]
+	return ok
+}
+
+// reloadWorkloads reloads the given workloads along with the ones deferred by a
+// previous run, deferring the ones that were reloaded within the cooldown
+func (c *Controller) reloadWorkloads(ctx context.Context, logger *slog.Logger, workloadsToReload reloadQueue) int {
+	for workload, changedSecretPaths := range c.deferredReloads {
+		// Skip workloads that got deleted in the meantime
+		if !c.workloadSecrets.Has(workload) {
+			continue
+		}
+		workloadsToReload.add(workload, changedSecretPaths...)
+	}
+	c.deferredReloads = make(reloadQueue)
+	c.pendingReloads.prune(c.workloadSecrets.Has)
+	for workload, changedSecretPaths := range workloadsToReload {
+		c.pendingReloads.add(workload, changedSecretPaths)
+	}
+
+	reloads := make(map[workload][]string, len(workloadsToReload))
+	for workload, changedSecretPaths := range workloadsToReload {
+		if c.inReloadCooldown(workload) {
+			logger.Info(fmt.Sprintf("Deferring reload of workload: %s, it was reloaded less than %s ago", workload, c.reloaderConfig.ReloadCooldown))
+			c.deferredReloads[workload] = changedSecretPaths
+			continue
 		}
-		newSecretVersions[secretPath] = currentVersion
+		reloads[workload] = changedSecretPaths
 	}
 
-	// Reloading workloads
-	for workload := range workloadsToReload {
-		reloaderLogger.Info(fmt.Sprintf("Reloading workload: %s", workload))
-		err := c.reloadWorkload(workload)
+	return c.reloadConcurrently(ctx, logger, reloads, true)
+}
+
+// inReloadCooldown reports whether a workload was reloaded less than ReloadCooldown ago
+func (c *Controller) inReloadCooldown(pending workload) bool {
+	lastReload, ok := c.workloadSecrets.GetLastReload(pending)
+	return ok && time.Since(lastReload) < c.reloaderConfig.ReloadCooldown
+}
+
+// queueSecretReloads queues the reload of the consumers of a Secret whose data changed
+// for its secret paths, and wakes up the reloader loop to reload them
+func (c *Controller) queueSecretReloads(consumers []workload, secretPaths []string) {
+	if len(consumers) == 0 {
+		return
+	}
+
+	c.secretReloadsMu.Lock()
+	for _, consumer := range consumers {
+		c.secretReloads.add(consumer, secretPaths...)
+	}
+	c.secretReloadsMu.Unlock()
+
+	select {
+	case c.secretReloadsReady <- struct{}{}:
+	default:
+	}
+}
+
+// reloadSecretConsumers reloads the queued consumers of the Secrets whose data changed,
+// queuing the ones that were reloaded within the cooldown again. The reloads are not
+// versioned, since the versions of the secrets in Vault did not change.
+func (c *Controller) reloadSecretConsumers(ctx context.Context, logger *slog.Logger) int {
+	if !c.isLeader() {
+		return 0
+	}
+
+	c.secretReloadsMu.Lock()
+	queued := c.secretReloads
+	c.secretReloads = make(reloadQueue)
+	c.secretReloadsMu.Unlock()
+
+	reloads := make(map[workload][]string, len(queued))
+	deferred := make(reloadQueue)
+	for consumer, secretPaths := range queued {
+		if c.inReloadCooldown(consumer) {
+			logger.Info(fmt.Sprintf("Deferring reload of workload: %s, it was reloaded less than %s ago", consumer, c.reloaderConfig.ReloadCooldown))
+			deferred[consumer] = secretPaths
+			continue
+		}
+		reloads[consumer] = secretPaths
+	}
+	if len(deferred) > 0 {
+		c.secretReloadsMu.Lock()
+		for consumer, secretPaths := range deferred {
+			c.secretReloads.add(consumer, secretPaths...)
+		}
+		c.secretReloadsMu.Unlock()
+	}
+
+	return c.reloadConcurrently(ctx, logger, reloads, false)
+}
+
+// newReloadSemaphore returns the semaphore every reload of the controller holds a slot of,
+// so that at most maxConcurrentReloads are in flight at the same time
+func newReloadSemaphore(maxConcurrentReloads int) chan struct{} {
+	return make(chan struct{}, max(maxConcurrentReloads, 1))
+}
+
+// reloadConcurrently reloads the workloads, sharing the MaxConcurrentReloads slots of the
+// controller with the other reloads in flight, and waits for the reloads to finish. Versioned reloads are skipped for the workloads that were
+// already reloaded for the current versions of their changed secrets. It returns the number
+// of reloads started.
+func (c *Controller) reloadConcurrently(ctx context.Context, logger *slog.Logger, workloadsToReload map[workload][]string, versioned bool) int {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	var started int
+
+	for pending, changedSecretPaths := range workloadsToReload {
+		// Don't start new reloads while shutting down
+		if ctx.Err() != nil {
+			logger.Info("Shutting down, skipping remaining reloads")
+			return started
+		}
+
+		// Limit the reloads in flight, so that a mass rotation doesn't overwhelm the cluster
+		select {
+		case c.reloadSemaphore <- struct{}{}:
+		case <-ctx.Done():
+			logger.Info("Shutting down, skipping remaining reloads")
+			return started
+		}
+		wg.Add(1)
+		started++
+		go func(pending workload, changedSecretPaths []string) {
+			defer func() {
+				<-c.reloadSemaphore
+				wg.Done()
+			}()
+			var secretVersions string
+			if versioned {
+				secretVersions = c.secretVersionsHash(changedSecretPaths)
+			}
+			err := c.triggerVersionedReload(ctx, pending, changedSecretPaths, secretVersions)
+			if err != nil {
+				logger.Error(fmt.Errorf("failed reloading workload: %s: %w", pending, err).Error())
+			}
+		}(pending, changedSecretPaths)
+	}
+
+	return started
+}
+
+// triggerReload reloads a workload while recording the outcome and duration of the reload,
+// or only logs it in dry run mode
+func (c *Controller) triggerReload(ctx context.Context, workload workload, changedSecretPaths []string) error {
+	return c.triggerVersionedReload(ctx, workload, changedSecretPaths, "")
+}
+
+// triggerVersionedReload is triggerReload skipping the workload if it was already reloaded
+// for the secretVersions hash of the changed secrets, unless it is empty
+func (c *Controller) triggerVersionedReload(ctx context.Context, workload workload, changedSecretPaths []string, secretVersions string) error {
+	_, span := c.tracer.Start(ctx, "reload", trace.WithAttributes(
+		attribute.String("workload.namespace", workload.namespace),
+		attribute.String("workload.kind", workload.kind),
+		attribute.String("workload.name", workload.name),
+		attribute.StringSlice("secret_paths", changedSecretPaths),
+		attribute.Bool("dry_run", c.reloaderConfig.DryRun),
+	))
+	defer span.End()
+
+	// Workloads restored from the store may be of a kind that is not enabled anymore
+	if !c.collectorConfig.kindEnabled(workload.kind) {
+		c.logger.Info(fmt.Sprintf("Skipping reload of %s, the %s kind is not enabled", workload, workload.kind))
+		return nil
+	}
+
+	if c.reloaderConfig.CollectOnly {
+		c.logger.Info(fmt.Sprintf("Collect only mode, skipping reload of workload: %s, changed secrets: %v", workload, changedSecretPaths),
+			slog.String("secret_path", strings.Join(changedSecretPaths, ",")))
+		return nil
+	}
+
+	if c.reloaderConfig.DryRun {
+		c.logger.Info(fmt.Sprintf("Dry run, skipping reload of workload: %s, changed secrets: %v", workload, changedSecretPaths),
+			slog.String("secret_path", strings.Join(changedSecretPaths, ",")))
+		c.metrics.reloadsSkippedDryRun.WithLabelValues(workload.namespace, workload.kind).Inc()
+		c.publishReloadEvent(ctx, workload, changedSecretPaths, reloadOutcomeDryRun, nil)
+		return nil
+	}
+
+	if hooks := c.reloaderConfig.ReloadHooks; hooks.PreReloadURL != "" {
+		err := callReloadHook(ctx, hooks.PreReloadURL, c.newReloadHookPayload(reloadHookStagePre, workload, changedSecretPaths))
+		if err != nil && hooks.BlockOnPreReloadFailure {
+			err = fmt.Errorf("reload aborted by the pre-reload hook: %w", err)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
 		if err != nil {
-			reloaderLogger.Error(fmt.Errorf("failed reloading workload: %s: %w", workload, err).Error())
+			c.logger.Warn(fmt.Errorf("pre-reload hook of workload %s failed: %w", workload, err).Error())
 		}
 	}
 
-	// Replace secretVersions map with the new one so we don't keep deleted secrets in the map
-	c.secretVersions = newSecretVersions
-	reloaderLogger.Debug(fmt.Sprintf("Updated secretVersions map: %#v", newSecretVersions))
+	c.logger.Info(fmt.Sprintf("Reloading workload: %s", workload), slog.String("secret_path", strings.Join(changedSecretPaths, ",")))
+	start := time.Now()
+	obj, err := c.reloadWorkloadWithRetry(ctx, workload, secretVersions)
+	if errors.Is(err, errAlreadyReloaded) {
+		c.logger.Info(fmt.Sprintf("Workload %s was already reloaded for the current secret versions, skipping it", workload))
+		c.pendingReloads.done(workload)
+		return nil
+	}
+	if errors.Is(err, errWorkloadPaused) {
+		c.logger.Info(fmt.Sprintf("Skipping reload of %s, its rollout is paused", workload))
+		c.metrics.reloadsSkippedPaused.WithLabelValues(workload.namespace, workload.kind).Inc()
+		return nil
+	}
+	c.metrics.reloadDuration.WithLabelValues(workload.kind).Observe(time.Since(start).Seconds())
+
+	outcome := reloadOutcomeSuccess
+	if err != nil {
+		outcome = reloadOutcomeError
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	c.metrics.reloadsTriggered.WithLabelValues(workload.namespace, workload.kind, outcome).Inc()
+	c.status.recordReload()
+	c.publishReloadEvent(ctx, workload, changedSecretPaths, outcome, err)
 
-	if len(workloadsToReload) == 0 {
-		reloaderLogger.Info("No workloads to reload")
+	// Record the reason of the rollout on the workload, visible with kubectl describe,
+	// no changed secrets means the reload was forced
+	if obj != nil && c.recorder != nil {
+		reason := "Vault secrets changed: " + strings.Join(changedSecretPaths, ", ")
+		if len(changedSecretPaths) == 0 {
+			reason = "reload was requested"
+		}
+		if err != nil {
+			c.recorder.Eventf(obj, corev1.EventTypeWarning, reloadFailedEventReason, "Failed to reload after %s: %s", reason, err)
+		} else {
+			c.recorder.Eventf(obj, corev1.EventTypeNormal, secretReloadedEventReason, "Reloaded after %s", reason)
+		}
+	}
+
+	if err == nil {
+		c.workloadSecrets.SetLastReload(workload, time.Now())
+		c.pendingReloads.done(workload)
+	}
+
+	if hookURL := c.reloaderConfig.ReloadHooks.PostReloadURL; hookURL != "" {
+		payload := c.newReloadHookPayload(reloadHookStagePost, workload, changedSecretPaths)
+		payload.Outcome = outcome
+		if err != nil {
+			payload.Error = err.Error()
+		}
+		if hookErr := callReloadHook(ctx, hookURL, payload); hookErr != nil {
+			c.logger.Warn(fmt.Errorf("post-reload hook of workload %s failed: %w", workload, hookErr).Error())
+		}
+	}
+
+	return err
+}
+
+// reloadWorkloadWithRetry reloads a workload, retrying transient API errors
+// with an exponential backoff until ReloadMaxAttempts is reached or the context is done
+func (c *Controller) reloadWorkloadWithRetry(ctx context.Context, workload workload, secretVersions string) (runtime.Object, error) {
+	maxAttempts := max(c.reloaderConfig.ReloadMaxAttempts, 1)
+	backoff := c.reloaderConfig.ReloadRetryBackoff
+
+	for attempt := 1; ; attempt++ {
+		obj, err := c.reloadWorkload(workload, secretVersions)
+		if err == nil || !isTransientError(err) {
+			return obj, err
+		}
+		if attempt >= maxAttempts {
+			if maxAttempts > 1 {
+				c.metrics.reloadRetriesFailed.WithLabelValues(workload.namespace, workload.kind).Inc()
+				err = fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			}
+			return obj, err
+		}
+
+		c.logger.Warn(fmt.Sprintf("Reloading workload: %s failed, retrying in %s: %s", workload, backoff, err))
+		c.metrics.reloadRetries.WithLabelValues(workload.namespace, workload.kind).Inc()
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return obj, fmt.Errorf("%w, stopped retrying: %w", err, ctx.Err())
+		case <-timer.C:
+		}
+		backoff *= 2
 	}
 }
 
-func (c *Controller) reloadWorkload(workload workload) error {
+// isTransientError tells whether an API error may go away by retrying the request,
+// conflicts are retried as every attempt reads the latest version of the workload
+func isTransientError(err error) bool {
+	return apierrors.IsConflict(err) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsTooManyRequests(err) ||
+		apierrors.IsInternalError(err) ||
+		apierrors.IsServiceUnavailable(err)
+}
+
+// reloadWorkload reloads a workload, returning the reloaded object, which is nil
+// if there was nothing to reload or it could not be read, errAlreadyReloaded
+// if its pod template already carries the non-empty secretVersions hash, and
+// errWorkloadPaused if its rollout is paused
+func (c *Controller) reloadWorkload(workload workload, secretVersions string) (runtime.Object, error) {
+	restartAnnotation := c.reloaderConfig.restartAnnotation()
+
 	// Reload object based on its type
 	switch workload.kind {
 	case DeploymentKind:
 		deployment, err := c.kubeClient.AppsV1().Deployments(workload.namespace).Get(context.Background(), workload.name, metav1.GetOptions{})
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		incrementReloadCountAnnotation(&deployment.Spec.Template)
+		// The rollout of a paused Deployment doesn't proceed, so neither its pod template is
+		// patched, to not pile up changes applied all at once when it is resumed, nor its pods
+		// are deleted
+		if deployment.Spec.Paused {
+			return deployment, errWorkloadPaused
+		}
 
-		_, err = c.kubeClient.AppsV1().Deployments(workload.namespace).Update(context.Background(), deployment, metav1.UpdateOptions{})
+		if c.reloadStrategy(deployment.Spec.Template) == ReloadDeletePods {
+			return deployment, c.deleteWorkloadPods(workload, deployment.Spec.Selector)
+		}
+
+		if err := reloadPodTemplate(&deployment.Spec.Template, restartAnnotation, secretVersions); err != nil {
+			return deployment, err
+		}
+
+		patch, patchType, err := c.reloadPatch(workload, "apps/v1", restartAnnotation, deployment.Spec.Template.Annotations, "spec", "template", "metadata", "annotations")
 		if err != nil {
-			return err
+			return deployment, err
 		}
+		_, err = c.kubeClient.AppsV1().Deployments(workload.namespace).Patch(context.Background(), workload.name, patchType, patch, c.reloadPatchOptions(patchType))
+		return deployment, err
 
 	case DaemonSetKind:
 		daemonSet, err := c.kubeClient.AppsV1().DaemonSets(workload.namespace).Get(context.Background(), workload.name, metav1.GetOptions{})
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		incrementReloadCountAnnotation(&daemonSet.Spec.Template)
+		if c.reloadStrategy(daemonSet.Spec.Template) == ReloadDeletePods {
+			return daemonSet, c.deleteWorkloadPods(workload, daemonSet.Spec.Selector)
+		}
+
+		if err := reloadPodTemplate(&daemonSet.Spec.Template, restartAnnotation, secretVersions); err != nil {
+			return daemonSet, err
+		}
 
-		_, err = c.kubeClient.AppsV1().DaemonSets(workload.namespace).Update(context.Background(), daemonSet, metav1.UpdateOptions{})
+		patch, patchType, err := c.reloadPatch(workload, "apps/v1", restartAnnotation, daemonSet.Spec.Template.Annotations, "spec", "template", "metadata", "annotations")
 		if err != nil {
-			return err
+			return daemonSet, err
 		}
+		_, err = c.kubeClient.AppsV1().DaemonSets(workload.namespace).Patch(context.Background(), workload.name, patchType, patch, c.reloadPatchOptions(patchType))
+		return daemonSet, err
 
 	case StatefulSetKind:
 		statefulSet, err := c.kubeClient.AppsV1().StatefulSets(workload.namespace).Get(context.Background(), workload.name, metav1.GetOptions{})
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		if c.reloadStrategy(statefulSet.Spec.Template) == ReloadDeletePods {
+			return statefulSet, c.deleteWorkloadPods(workload, statefulSet.Spec.Selector)
+		}
+
+		if err := reloadPodTemplate(&statefulSet.Spec.Template, restartAnnotation, secretVersions); err != nil {
+			return statefulSet, err
 		}
 
-		incrementReloadCountAnnotation(&statefulSet.Spec.Template)
+		patch, patchType, err := c.reloadPatch(workload, "apps/v1", restartAnnotation, statefulSet.Spec.Template.Annotations, "spec", "template", "metadata", "annotations")
+		if err != nil {
+			return statefulSet, err
+		}
+		_, err = c.kubeClient.AppsV1().StatefulSets(workload.namespace).Patch(context.Background(), workload.name, patchType, patch, c.reloadPatchOptions(patchType))
+		return statefulSet, err
 
-		_, err = c.kubeClient.AppsV1().StatefulSets(workload.namespace).Update(context.Background(), statefulSet, metav1.UpdateOptions{})
+	case ReplicaSetKind:
+		replicaSet, err := c.kubeClient.AppsV1().ReplicaSets(workload.namespace).Get(context.Background(), workload.name, metav1.GetOptions{})
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		// A ReplicaSet doesn't replace its pods when its pod template changes, they are
+		// deleted after recording the reload so that they are recreated with the new template
+		if err := reloadPodTemplate(&replicaSet.Spec.Template, restartAnnotation, secretVersions); err != nil {
+			return replicaSet, err
+		}
+
+		patch, patchType, err := c.reloadPatch(workload, "apps/v1", restartAnnotation, replicaSet.Spec.Template.Annotations, "spec", "template", "metadata", "annotations")
+		if err != nil {
+			return replicaSet, err
+		}
+		_, err = c.kubeClient.AppsV1().ReplicaSets(workload.namespace).Patch(context.Background(), workload.name, patchType, patch, c.reloadPatchOptions(patchType))
+		if err != nil {
+			return replicaSet, err
 		}
-		
+		return replicaSet, c.deleteWorkloadPods(workload, replicaSet.Spec.Selector)
+
+	case CronJobKind:
+		if c.reloaderConfig.CronJobReloadStrategy != CronJobReloadNextSchedule {
+			c.logger.Info(fmt.Sprintf("Skipping reload of %s, it will use the new secret version on its next schedule", workload))
+			return nil, nil
+		}
+
+		cronJob, err := c.kubeClient.BatchV1().CronJobs(workload.namespace).Get(context.Background(), workload.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := reloadPodTemplate(&cronJob.Spec.JobTemplate.Spec.Template, restartAnnotation, secretVersions); err != nil {
+			return cronJob, err
+		}
+
+		patch, patchType, err := c.reloadPatch(workload, "batch/v1", restartAnnotation, cronJob.Spec.JobTemplate.Spec.Template.Annotations, "spec", "jobTemplate", "spec", "template", "metadata", "annotations")
+		if err != nil {
+			return cronJob, err
+		}
+		_, err = c.kubeClient.BatchV1().CronJobs(workload.namespace).Patch(context.Background(), workload.name, patchType, patch, c.reloadPatchOptions(patchType))
+		return cronJob, err
+
+	case JobKind:
+		// The pod template of a Job is immutable, so there is nothing to reload
+		c.logger.Info(fmt.Sprintf("Skipping reload of %s, the pod template of a Job is immutable", workload))
+		return nil, nil
+
+	case RolloutKind:
+		return c.reloadRollout(workload, secretVersions)
+
+	case PodKind:
+		return c.reloadPod(workload)
+
 	case SecretsKind:
 		secrets, err := c.kubeClient.CoreV1().Secrets(workload.namespace).Get(context.Background(), workload.name, metav1.GetOptions{})
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		incrementReloadCountAnnotationSecret(secrets)
 
-		_, err = c.kubeClient.CoreV1().Secrets(workload.namespace).Update(context.Background(), secrets, metav1.UpdateOptions{})
+		patch, patchType, err := c.reloadPatch(workload, "v1", ReloadCountAnnotationName, secrets.Annotations, "metadata", "annotations")
 		if err != nil {
-			return err
+			return secrets, err
 		}
+		_, err = c.kubeClient.CoreV1().Secrets(workload.namespace).Patch(context.Background(), workload.name, patchType, patch, c.reloadPatchOptions(patchType))
+		return secrets, err
 
 	default:
-		return fmt.Errorf("unknown object type: %s", workload.kind)
+		return nil, fmt.Errorf("unknown object type: %s", workload.kind)
+	}
+}
+
+// reloadPatch returns the patch of a workload setting the restart and secret versions
+// annotations found in annotations at the fields path, with its type set by PatchStrategy
+func (c *Controller) reloadPatch(workload workload, apiVersion string, restartAnnotation string, annotations map[string]string, fields ...string) ([]byte, types.PatchType, error) {
+	switch c.reloaderConfig.PatchStrategy {
+	case PatchStrategicMerge:
+		patch, err := mergeReloadPatch(restartAnnotation, annotations, fields...)
+		return patch, types.StrategicMergePatchType, err
+	case PatchJSONMerge:
+		patch, err := mergeReloadPatch(restartAnnotation, annotations, fields...)
+		return patch, types.MergePatchType, err
+	default:
+		patch, err := applyReloadPatch(workload, apiVersion, restartAnnotation, annotations, fields...)
+		return patch, types.ApplyPatchType, err
+	}
+}
+
+// applyReloadPatch returns a server-side apply patch of a workload setting the restart
+// and secret versions annotations found in annotations at the fields path, the recorded
+// secret versions are applied again with every reload so that the field manager keeps owning them
+func applyReloadPatch(workload workload, apiVersion string, restartAnnotation string, annotations map[string]string, fields ...string) ([]byte, error) {
+	kind := workload.kind
+	if kind == SecretsKind {
+		kind = "Secret"
+	}
+	patch := map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"name":      workload.name,
+			"namespace": workload.namespace,
+		},
+	}
+	if err := unstructured.SetNestedStringMap(patch, reloadAnnotations(restartAnnotation, annotations), fields...); err != nil {
+		return nil, err
+	}
+	return json.Marshal(patch)
+}
+
+// mergeReloadPatch returns a merge patch setting the restart and secret versions
+// annotations found in annotations at the fields path, the other annotations are kept
+func mergeReloadPatch(restartAnnotation string, annotations map[string]string, fields ...string) ([]byte, error) {
+	patch := map[string]interface{}{}
+	if err := unstructured.SetNestedStringMap(patch, reloadAnnotations(restartAnnotation, annotations), fields...); err != nil {
+		return nil, err
+	}
+	return json.Marshal(patch)
+}
+
+// reloadAnnotations returns the restart and secret versions annotations found in annotations
+func reloadAnnotations(restartAnnotation string, annotations map[string]string) map[string]string {
+	reloadAnnotations := map[string]string{}
+	for _, key := range []string{restartAnnotation, SecretVersionsAnnotationName} {
+		if value, ok := annotations[key]; ok {
+			reloadAnnotations[key] = value
+		}
+	}
+	return reloadAnnotations
+}
+
+// reloadPatchOptions returns the options of the reload patches, forcing the ownership of the
+// reload annotations applied with server-side apply as no other field manager is expected to
+// set them, the API server rejects forcing the other patch types
+func (c *Controller) reloadPatchOptions(patchType types.PatchType) metav1.PatchOptions {
+	options := metav1.PatchOptions{FieldManager: c.reloaderConfig.fieldManager()}
+	if patchType == types.ApplyPatchType {
+		force := true
+		options.Force = &force
+	}
+	return options
+}
+
+// reloadStrategy returns the reload strategy set in the pod template of a workload,
+// or the configured one if it is not set or invalid
+func (c *Controller) reloadStrategy(template corev1.PodTemplateSpec) ReloadStrategy {
+	switch strategy := ReloadStrategy(template.GetAnnotations()[ReloadStrategyAnnotationName]); strategy {
+	case ReloadRolloutRestart, ReloadDeletePods:
+		return strategy
+	}
+	if c.reloaderConfig.ReloadStrategy == ReloadDeletePods {
+		return ReloadDeletePods
+	}
+	return ReloadRolloutRestart
+}
+
+// deleteWorkloadPods deletes the pods matching the selector of a workload, so that
+// they are recreated by its controller with the current secrets
+func (c *Controller) deleteWorkloadPods(workload workload, labelSelector *metav1.LabelSelector) error {
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return fmt.Errorf("invalid selector of %s: %w", workload, err)
+	}
+	if selector.Empty() {
+		return fmt.Errorf("refusing to delete the pods of %s, its selector matches every pod", workload)
 	}
 
+	pods, err := c.kubeClient.CoreV1().Pods(workload.namespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		err := c.kubeClient.CoreV1().Pods(workload.namespace).Delete(context.Background(), pod.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	c.logger.Info(fmt.Sprintf("Deleted %d pods of workload: %s", len(pods.Items), workload))
+	return nil
+}
+
+// errAlreadyReloaded is returned for workloads already reloaded for the changed secret versions
+var errAlreadyReloaded = errors.New("workload already reloaded for the secret versions")
+
+// errWorkloadPaused is returned for workloads not reloaded as their rollout is paused,
+// StatefulSets and DaemonSets cannot be paused
+var errWorkloadPaused = errors.New("workload rollout is paused")
+
+// secretVersionsHash returns a hash of the stored versions of the changed secrets, or of
+// their stored content hashes if they have no version, empty if one of them is unknown
+func (c *Controller) secretVersionsHash(changedSecretPaths []string) string {
+	if len(changedSecretPaths) == 0 {
+		return ""
+	}
+
+	secretPaths := slices.Clone(changedSecretPaths)
+	slices.Sort(secretPaths)
+	hash := sha256.New()
+	for _, secretPath := range secretPaths {
+		if version, ok := c.workloadSecrets.GetVersion(secretPath); ok {
+			// A change of the reload custom metadata alone has to be recorded as a new reload too
+			if value, _ := c.workloadSecrets.GetMetadataValue(secretPath); value != "" {
+				fmt.Fprintf(hash, "%s=%d,%s\n", secretPath, version, value)
+			} else {
+				fmt.Fprintf(hash, "%s=%d\n", secretPath, version)
+			}
+		} else if contentHash, ok := c.workloadSecrets.GetHash(secretPath); ok {
+			fmt.Fprintf(hash, "%s=%s\n", secretPath, contentHash)
+		} else {
+			return ""
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// reloadPodTemplate increments the restart annotation of a pod template and records the
+// secretVersions hash if it is not empty, returning errAlreadyReloaded if it is already recorded
+func reloadPodTemplate(podTemplate *corev1.PodTemplateSpec, restartAnnotation string, secretVersions string) error {
+	if secretVersions != "" && podTemplate.GetAnnotations()[SecretVersionsAnnotationName] == secretVersions {
+		return errAlreadyReloaded
+	}
+
+	incrementReloadCountAnnotation(podTemplate, restartAnnotation)
+	if secretVersions != "" {
+		podTemplate.Annotations[SecretVersionsAnnotationName] = secretVersions
+	}
 	return nil
 }
 
-func incrementReloadCountAnnotation(podTemplate *corev1.PodTemplateSpec) {
+func incrementReloadCountAnnotation(podTemplate *corev1.PodTemplateSpec, restartAnnotation string) {
 	version := "1"
 
-	if reloadCount := podTemplate.GetAnnotations()[ReloadCountAnnotationName]; reloadCount != "" {
+	if reloadCount := podTemplate.GetAnnotations()[restartAnnotation]; reloadCount != "" {
 		count, err := strconv.Atoi(reloadCount)
 		if err == nil {
 			count++
@@ -176,7 +1343,11 @@ func incrementReloadCountAnnotation(podTemplate *corev1.PodTemplateSpec) {
 		}
 	}
 
-	podTemplate.GetAnnotations()[ReloadCountAnnotationName] = version
+	if podTemplate.Annotations == nil {
+		podTemplate.Annotations = make(map[string]string)
+	}
+
+	podTemplate.Annotations[restartAnnotation] = version
 }
 
 func incrementReloadCountAnnotationSecret(secret *corev1.Secret) {
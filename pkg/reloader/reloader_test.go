@@ -15,11 +15,35 @@
 package reloader
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestIncrementReloadCountAnnotation(t *testing.T) {
@@ -29,8 +53,1631 @@ func TestIncrementReloadCountAnnotation(t *testing.T) {
 		},
 	}
 
-	incrementReloadCountAnnotation(&podTemplate)
+	incrementReloadCountAnnotation(&podTemplate, ReloadCountAnnotationName)
 	assert.Equal(t, "1", podTemplate.GetAnnotations()[ReloadCountAnnotationName])
-	incrementReloadCountAnnotation(&podTemplate)
+	incrementReloadCountAnnotation(&podTemplate, ReloadCountAnnotationName)
 	assert.Equal(t, "2", podTemplate.GetAnnotations()[ReloadCountAnnotationName])
 }
+
+func TestReloadWorkloadStatefulSet(t *testing.T) {
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "postgres",
+			Namespace: "db",
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Template: newTestPodTemplate(
+				map[string]string{SecretReloadAnnotationName: "true"},
+				"vault:secret/data/postgres#password",
+			),
+		},
+	}
+	kubeClient := fake.NewSimpleClientset(statefulSet)
+	controller := newTestController(kubeClient)
+
+	_, err := controller.reloadWorkload(workload{name: "postgres", namespace: "db", kind: StatefulSetKind}, "")
+	assert.NoError(t, err)
+
+	reloaded, err := kubeClient.AppsV1().StatefulSets("db").Get(context.Background(), "postgres", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", reloaded.Spec.Template.GetAnnotations()[ReloadCountAnnotationName])
+}
+
+func TestReloadWorkloadDaemonSet(t *testing.T) {
+	daemonSet := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "node-agent",
+			Namespace: "monitoring",
+		},
+		Spec: appsv1.DaemonSetSpec{
+			UpdateStrategy: appsv1.DaemonSetUpdateStrategy{
+				Type: appsv1.RollingUpdateDaemonSetStrategyType,
+			},
+			Template: newTestPodTemplate(
+				map[string]string{SecretReloadAnnotationName: "true"},
+				"vault:secret/data/agent#token",
+			),
+		},
+	}
+	kubeClient := fake.NewSimpleClientset(daemonSet)
+	controller := newTestController(kubeClient)
+
+	_, err := controller.reloadWorkload(workload{name: "node-agent", namespace: "monitoring", kind: DaemonSetKind}, "")
+	assert.NoError(t, err)
+
+	reloaded, err := kubeClient.AppsV1().DaemonSets("monitoring").Get(context.Background(), "node-agent", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", reloaded.Spec.Template.GetAnnotations()[ReloadCountAnnotationName])
+	// the update strategy is left untouched, so the rollout follows it
+	assert.Equal(t, appsv1.RollingUpdateDaemonSetStrategyType, reloaded.Spec.UpdateStrategy.Type)
+}
+
+func TestReloadWorkloadCronJob(t *testing.T) {
+	newCronJob := func() *batchv1.CronJob {
+		return &batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "backup",
+				Namespace: "default",
+			},
+			Spec: batchv1.CronJobSpec{
+				JobTemplate: batchv1.JobTemplateSpec{
+					Spec: batchv1.JobSpec{
+						Template: newTestPodTemplate(
+							map[string]string{SecretReloadAnnotationName: "true"},
+							"vault:secret/data/backup#token",
+						),
+					},
+				},
+			},
+		}
+	}
+	cronJobWorkload := workload{name: "backup", namespace: "default", kind: CronJobKind}
+
+	t.Run("no-op by default", func(t *testing.T) {
+		kubeClient := fake.NewSimpleClientset(newCronJob())
+		controller := newTestController(kubeClient)
+
+		_, err := controller.reloadWorkload(cronJobWorkload, "")
+		assert.NoError(t, err)
+
+		cronJob, err := kubeClient.BatchV1().CronJobs("default").Get(context.Background(), "backup", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.NotContains(t, cronJob.Spec.JobTemplate.Spec.Template.GetAnnotations(), ReloadCountAnnotationName)
+	})
+
+	t.Run("recreate on next schedule", func(t *testing.T) {
+		kubeClient := fake.NewSimpleClientset(newCronJob())
+		controller := newTestController(kubeClient)
+		controller.reloaderConfig.CronJobReloadStrategy = CronJobReloadNextSchedule
+
+		_, err := controller.reloadWorkload(cronJobWorkload, "")
+		assert.NoError(t, err)
+
+		cronJob, err := kubeClient.BatchV1().CronJobs("default").Get(context.Background(), "backup", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "1", cronJob.Spec.JobTemplate.Spec.Template.GetAnnotations()[ReloadCountAnnotationName])
+	})
+}
+
+func TestTriggerReloadMetrics(t *testing.T) {
+	deployment := newTestDeployment("app",
+		map[string]string{SecretReloadAnnotationName: "true"},
+		"vault:secret/data/app#password",
+	)
+	controller := newTestController(fake.NewSimpleClientset(deployment))
+
+	t.Run("success", func(t *testing.T) {
+		err := controller.triggerReload(context.Background(), workload{name: "app", namespace: "default", kind: DeploymentKind}, []string{"secret/data/app"})
+		assert.NoError(t, err)
+
+		assert.Equal(t, float64(1), testutil.ToFloat64(
+			controller.metrics.reloadsTriggered.WithLabelValues("default", DeploymentKind, reloadOutcomeSuccess),
+		))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		err := controller.triggerReload(context.Background(), workload{name: "missing", namespace: "default", kind: DeploymentKind}, []string{"secret/data/app"})
+		assert.Error(t, err)
+
+		assert.Equal(t, float64(1), testutil.ToFloat64(
+			controller.metrics.reloadsTriggered.WithLabelValues("default", DeploymentKind, reloadOutcomeError),
+		))
+	})
+
+	// both reloads are observed by the histogram
+	assert.Equal(t, 1, testutil.CollectAndCount(controller.metrics.reloadDuration))
+	metric := &dto.Metric{}
+	assert.NoError(t, controller.metrics.reloadDuration.WithLabelValues(DeploymentKind).(prometheus.Histogram).Write(metric))
+	assert.Equal(t, uint64(2), metric.GetHistogram().GetSampleCount())
+}
+
+func TestTriggerReloadDryRun(t *testing.T) {
+	deployment := newTestDeployment("app",
+		map[string]string{SecretReloadAnnotationName: "true"},
+		"vault:secret/data/app#password",
+	)
+	kubeClient := fake.NewSimpleClientset(deployment)
+	controller := newTestController(kubeClient)
+	controller.reloaderConfig.DryRun = true
+
+	var logs bytes.Buffer
+	controller.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	err := controller.triggerReload(context.Background(), workload{name: "app", namespace: "default", kind: DeploymentKind}, []string{"secret/data/app"})
+	assert.NoError(t, err)
+
+	// no request is sent to the API server
+	assert.Empty(t, kubeClient.Actions())
+	assert.Contains(t, logs.String(), "Dry run, skipping reload of workload")
+	assert.Contains(t, logs.String(), "secret/data/app")
+	assert.Equal(t, float64(1), testutil.ToFloat64(
+		controller.metrics.reloadsSkippedDryRun.WithLabelValues("default", DeploymentKind),
+	))
+	assert.Equal(t, 0, testutil.CollectAndCount(controller.metrics.reloadsTriggered))
+}
+
+func TestCollectOnly(t *testing.T) {
+	var vaultRequests int
+	vaultServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vaultRequests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer vaultServer.Close()
+	t.Setenv("VAULT_ADDR", vaultServer.URL)
+
+	deployment := newTestDeployment("app",
+		map[string]string{SecretReloadAnnotationName: "true"},
+		"vault:secret/data/app#password",
+	)
+	kubeClient := fake.NewSimpleClientset(deployment)
+	controller := newTestController(kubeClient)
+	controller.reloaderConfig.CollectOnly = true
+	app := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	controller.collectWorkloadSecrets(app, nil, deployment.Spec.Template)
+
+	controller.runReloader(context.Background())
+	err := controller.triggerReload(context.Background(), app, []string{"secret/data/app"})
+	assert.NoError(t, err)
+
+	// the secrets are collected, but Vault is never connected to and nothing is patched
+	assert.Equal(t, map[workload][]string{app: {"secret/data/app"}}, controller.workloadSecrets.GetWorkloadSecretsMap())
+	assert.Nil(t, controller.vaultClient)
+	assert.Zero(t, vaultRequests)
+	assert.Empty(t, kubeClient.Actions())
+
+	// the controller is ready without authenticating to Vault
+	controller.cachesSynced.Store(true)
+	recorder := httptest.NewRecorder()
+	controller.ReadyHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestTriggerReloadPaused(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app",
+			Namespace: "default",
+		},
+		Spec: appsv1.DeploymentSpec{
+			Paused: true,
+			Template: newTestPodTemplate(
+				map[string]string{SecretReloadAnnotationName: "true"},
+				"vault:secret/data/app#password",
+			),
+		},
+	}
+	kubeClient := fake.NewSimpleClientset(deployment)
+	controller := newTestController(kubeClient)
+	appWorkload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+
+	var logs bytes.Buffer
+	controller.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	err := controller.triggerReload(context.Background(), appWorkload, []string{"secret/data/app"})
+	assert.NoError(t, err)
+
+	// the Deployment is read but not patched
+	for _, action := range kubeClient.Actions() {
+		assert.Equal(t, "get", action.GetVerb())
+	}
+	assert.Contains(t, logs.String(), "its rollout is paused")
+	assert.Equal(t, float64(1), testutil.ToFloat64(
+		controller.metrics.reloadsSkippedPaused.WithLabelValues("default", DeploymentKind),
+	))
+	assert.Equal(t, 0, testutil.CollectAndCount(controller.metrics.reloadsTriggered))
+	_, ok := controller.workloadSecrets.GetLastReload(appWorkload)
+	assert.False(t, ok)
+}
+
+func TestTriggerReloadPausedDeletePods(t *testing.T) {
+	template := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	template.Labels = map[string]string{"app": "app"}
+	kubeClient := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Spec: appsv1.DeploymentSpec{
+				Paused:   true,
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}},
+				Template: template,
+			},
+		},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "default", Labels: map[string]string{"app": "app"}}},
+	)
+	controller := newTestController(kubeClient)
+	controller.reloaderConfig.ReloadStrategy = ReloadDeletePods
+
+	err := controller.triggerReload(context.Background(), workload{name: "app", namespace: "default", kind: DeploymentKind}, []string{"secret/data/app"})
+	assert.NoError(t, err)
+
+	// The pods of a paused Deployment are not deleted either
+	pods, err := kubeClient.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, pods.Items, 1)
+	assert.Equal(t, float64(1), testutil.ToFloat64(
+		controller.metrics.reloadsSkippedPaused.WithLabelValues("default", DeploymentKind),
+	))
+}
+
+func TestReloadWorkloadsCooldown(t *testing.T) {
+	deployment := newTestDeployment("app",
+		map[string]string{SecretReloadAnnotationName: "true"},
+		"vault:secret/data/app#password",
+	)
+	kubeClient := fake.NewSimpleClientset(deployment)
+	controller := newTestController(kubeClient)
+	controller.reloaderConfig.ReloadCooldown = time.Hour
+
+	appWorkload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	controller.workloadSecrets.Store(appWorkload, []string{"secret/data/app"})
+
+	reloadCount := func() string {
+		reloaded, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+		assert.NoError(t, err)
+		return reloaded.Spec.Template.GetAnnotations()[ReloadCountAnnotationName]
+	}
+
+	// first change reloads the workload
+	controller.reloadWorkloads(context.Background(), controller.logger, map[workload][]string{appWorkload: {"secret/data/app"}})
+	assert.Equal(t, "1", reloadCount())
+
+	// second change within the cooldown is deferred
+	controller.reloadWorkloads(context.Background(), controller.logger, map[workload][]string{appWorkload: {"secret/data/app"}})
+	assert.Equal(t, "1", reloadCount())
+	assert.Equal(t, reloadQueue{appWorkload: {"secret/data/app"}}, controller.deferredReloads)
+
+	// the deferred reload proceeds once the cooldown elapsed
+	controller.workloadSecrets.SetLastReload(appWorkload, time.Now().Add(-2*time.Hour))
+	controller.reloadWorkloads(context.Background(), controller.logger, map[workload][]string{})
+	assert.Equal(t, "2", reloadCount())
+	assert.Empty(t, controller.deferredReloads)
+}
+
+func TestRunReloaderKVVersions(t *testing.T) {
+	newDeployment := func(name string, envValue string) *appsv1.Deployment {
+		return newTestDeployment(name, map[string]string{SecretReloadAnnotationName: "true"}, envValue)
+	}
+	reloadCount := func(kubeClient *fake.Clientset, name string) string {
+		deployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		return deployment.Spec.Template.GetAnnotations()[ReloadCountAnnotationName]
+	}
+
+	vault := newTestVault(t)
+	vault.setVersion("v2-app", 1)
+	vault.setContents("v1-app", map[string]interface{}{"password": "s3cr3t"})
+
+	v2Deployment := newDeployment("v2-app", "vault:secret/data/v2-app#password")
+	v1Deployment := newDeployment("v1-app", "vault:kv/v1-app#password")
+	kubeClient := fake.NewSimpleClientset(v2Deployment, v1Deployment)
+	controller := newTestController(kubeClient)
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.collectWorkloadSecrets(workload{name: "v2-app", namespace: "default", kind: DeploymentKind}, nil, v2Deployment.Spec.Template)
+	controller.collectWorkloadSecrets(workload{name: "v1-app", namespace: "default", kind: DeploymentKind}, nil, v1Deployment.Spec.Template)
+
+	// The first run only records the current state of the secrets
+	controller.runReloader(context.Background())
+	assertVersion(t, controller.workloadSecrets, "secret/data/v2-app", 1)
+	_, ok := controller.workloadSecrets.GetHash("kv/v1-app")
+	assert.True(t, ok)
+	assert.Equal(t, "", reloadCount(kubeClient, "v2-app"))
+	assert.Equal(t, "", reloadCount(kubeClient, "v1-app"))
+
+	t.Run("v2 version bump", func(t *testing.T) {
+		vault.setVersion("v2-app", 2)
+		controller.runReloader(context.Background())
+		assert.Equal(t, "1", reloadCount(kubeClient, "v2-app"))
+		assert.Equal(t, "", reloadCount(kubeClient, "v1-app"))
+	})
+
+	t.Run("v1 content change", func(t *testing.T) {
+		vault.setContents("v1-app", map[string]interface{}{"password": "n3w"})
+		controller.runReloader(context.Background())
+		assert.Equal(t, "1", reloadCount(kubeClient, "v2-app"))
+		assert.Equal(t, "1", reloadCount(kubeClient, "v1-app"))
+	})
+}
+
+func TestRunReloaderMultipleKVMounts(t *testing.T) {
+	deployment := newTestDeployment("app",
+		map[string]string{SecretReloadAnnotationName: "true"},
+		"vault:secret/data/app#password vault:platform/data/app#token",
+	)
+	reloadCount := func(kubeClient *fake.Clientset) string {
+		deployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+		assert.NoError(t, err)
+		return deployment.Spec.Template.GetAnnotations()[ReloadCountAnnotationName]
+	}
+
+	// The same secret name has independent versions in both mounts
+	vault := newTestVault(t)
+	vault.setVersion("app", 1)
+	vault.setMountVersion("platform", "app", 5)
+
+	kubeClient := fake.NewSimpleClientset(deployment)
+	controller := newTestController(kubeClient)
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.collectWorkloadSecrets(workload{name: "app", namespace: "default", kind: DeploymentKind}, nil, deployment.Spec.Template)
+
+	controller.runReloader(context.Background())
+	assertVersion(t, controller.workloadSecrets, "secret/data/app", 1)
+	assertVersion(t, controller.workloadSecrets, "platform/data/app", 5)
+	assert.Equal(t, KVMount{Path: "secret", Version: 2}, controller.kvMounts["secret/data/app"])
+	assert.Equal(t, KVMount{Path: "platform", Version: 2}, controller.kvMounts["platform/data/app"])
+	assert.Equal(t, "", reloadCount(kubeClient))
+
+	t.Run("platform version bump", func(t *testing.T) {
+		vault.setMountVersion("platform", "app", 6)
+		controller.runReloader(context.Background())
+		assertVersion(t, controller.workloadSecrets, "secret/data/app", 1)
+		assertVersion(t, controller.workloadSecrets, "platform/data/app", 6)
+		assert.Equal(t, "1", reloadCount(kubeClient))
+	})
+
+	t.Run("secret version bump", func(t *testing.T) {
+		vault.setVersion("app", 2)
+		controller.runReloader(context.Background())
+		assertVersion(t, controller.workloadSecrets, "secret/data/app", 2)
+		assertVersion(t, controller.workloadSecrets, "platform/data/app", 6)
+		assert.Equal(t, "2", reloadCount(kubeClient))
+	})
+
+	t.Run("no change", func(t *testing.T) {
+		controller.runReloader(context.Background())
+		assert.Equal(t, "2", reloadCount(kubeClient))
+	})
+}
+
+func TestRunReloaderAllowedMounts(t *testing.T) {
+	vault := newTestVault(t)
+	vault.setVersion("app", 1)
+	vault.setMountVersion("platform", "app", 1)
+
+	deployment := newTestDeployment("app",
+		map[string]string{SecretReloadAnnotationName: "true"},
+		"vault:secret/data/app#password vault:platform/data/app#token",
+	)
+	controller := newTestController(fake.NewSimpleClientset(deployment))
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.collectorConfig.AllowedMounts = []string{"secret"}
+	app := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	controller.collectWorkloadSecrets(app, nil, deployment.Spec.Template)
+
+	// The path of the disallowed mount is not tracked
+	assert.Equal(t, map[workload][]string{app: {"secret/data/app"}}, controller.workloadSecrets.GetWorkloadSecretsMap())
+
+	// nor looked up if it was tracked before, e.g. restored from the store
+	controller.workloadSecrets.Store(app, []string{"platform/data/app", "secret/data/app"})
+	controller.runReloader(context.Background())
+	assertVersion(t, controller.workloadSecrets, "secret/data/app", 1)
+	_, ok := controller.workloadSecrets.GetVersion("platform/data/app")
+	assert.False(t, ok)
+}
+
+func TestRunReloaderContentHashChangeDetection(t *testing.T) {
+	deployment := newTestDeployment("app", map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	reloadCount := func(kubeClient *fake.Clientset) string {
+		deployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+		assert.NoError(t, err)
+		return deployment.Spec.Template.GetAnnotations()[ReloadCountAnnotationName]
+	}
+
+	for _, tt := range []struct {
+		changeDetection ChangeDetection
+		wantReloadCount string
+	}{
+		{changeDetection: "", wantReloadCount: ""},
+		{changeDetection: ChangeDetectionVersion, wantReloadCount: ""},
+		{changeDetection: ChangeDetectionContentHash, wantReloadCount: "1"},
+	} {
+		t.Run(fmt.Sprintf("change detection %q", tt.changeDetection), func(t *testing.T) {
+			// The backend keeps the same version when the contents change
+			vault := newTestVault(t)
+			vault.setVersion("app", 1)
+			vault.setContents("app", map[string]interface{}{"password": "s3cr3t"})
+
+			kubeClient := fake.NewSimpleClientset(deployment.DeepCopy())
+			controller := newTestController(kubeClient)
+			controller.vaultClient = vault.client(t)
+			controller.vaultConfig = &VaultConfig{}
+			controller.reloaderConfig.ChangeDetection = tt.changeDetection
+			controller.collectWorkloadSecrets(workload{name: "app", namespace: "default", kind: DeploymentKind}, nil, deployment.Spec.Template)
+
+			controller.runReloader(context.Background())
+			assert.Equal(t, "", reloadCount(kubeClient))
+
+			// An unchanged secret is not reloaded, even if its metadata changes
+			controller.runReloader(context.Background())
+			assert.Equal(t, "", reloadCount(kubeClient))
+
+			vault.setContents("app", map[string]interface{}{"password": "n3w"})
+			controller.runReloader(context.Background())
+			assert.Equal(t, tt.wantReloadCount, reloadCount(kubeClient))
+		})
+	}
+}
+
+func TestReloadSameSecretVersionsOnce(t *testing.T) {
+	vault := newTestVault(t)
+	vault.setVersion("app", 1)
+
+	deployment := newTestDeployment("app", map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	appWorkload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	kubeClient := fake.NewSimpleClientset(deployment)
+	controller := newTestController(kubeClient)
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.collectWorkloadSecrets(appWorkload, nil, deployment.Spec.Template)
+	reloadedTemplate := func() *corev1.PodTemplateSpec {
+		deployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+		assert.NoError(t, err)
+		return &deployment.Spec.Template
+	}
+
+	controller.runReloader(context.Background())
+	vault.setVersion("app", 2)
+	controller.runReloader(context.Background())
+	assert.Equal(t, "1", reloadedTemplate().GetAnnotations()[ReloadCountAnnotationName])
+	secretVersions := reloadedTemplate().GetAnnotations()[SecretVersionsAnnotationName]
+	assert.Equal(t, controller.secretVersionsHash([]string{"secret/data/app"}), secretVersions)
+
+	// Replaying the reload for the same version doesn't roll the workload out again
+	controller.reloadWorkloads(context.Background(), controller.logger, map[workload][]string{appWorkload: {"secret/data/app"}})
+	assert.Equal(t, "1", reloadedTemplate().GetAnnotations()[ReloadCountAnnotationName])
+	assert.Equal(t, secretVersions, reloadedTemplate().GetAnnotations()[SecretVersionsAnnotationName])
+
+	vault.setVersion("app", 3)
+	controller.runReloader(context.Background())
+	assert.Equal(t, "2", reloadedTemplate().GetAnnotations()[ReloadCountAnnotationName])
+	assert.NotEqual(t, secretVersions, reloadedTemplate().GetAnnotations()[SecretVersionsAnnotationName])
+
+	// Forced reloads have no secret versions to compare
+	assert.NoError(t, controller.triggerReload(context.Background(), appWorkload, nil))
+	assert.Equal(t, "3", reloadedTemplate().GetAnnotations()[ReloadCountAnnotationName])
+}
+
+func TestRunReloaderSkipsDynamicSecrets(t *testing.T) {
+	vault := newTestVault(t)
+	vault.setVersion("app", 1)
+
+	deployment := newTestDeployment("app", map[string]string{SecretReloadAnnotationName: "true"},
+		"vault:secret/data/app#password vault:database/creds/readonly#password vault:aws/sts/deploy#secret_key")
+	kubeClient := fake.NewSimpleClientset(deployment)
+	controller := newTestController(kubeClient)
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.collectWorkloadSecrets(workload{name: "app", namespace: "default", kind: DeploymentKind}, nil, deployment.Spec.Template)
+
+	controller.runReloader(context.Background())
+
+	version, ok := controller.workloadSecrets.GetVersion("secret/data/app")
+	assert.True(t, ok)
+	assert.Equal(t, 1, version)
+	// The database mount is detected, the aws one has no mount info but a dynamic secret path
+	for _, secretPath := range []string{"database/creds/readonly", "aws/sts/deploy"} {
+		_, ok := controller.workloadSecrets.GetVersion(secretPath)
+		assert.False(t, ok, secretPath)
+		_, ok = controller.workloadSecrets.GetHash(secretPath)
+		assert.False(t, ok, secretPath)
+	}
+	assert.Equal(t, KVMount{Version: notKVMount}, controller.kvMounts["database/creds/readonly"])
+}
+
+func TestNextReconcileInterval(t *testing.T) {
+	config := ReloaderConfig{ReconcileInterval: time.Minute, ReconcileJitter: 10 * time.Second}
+	for i := 0; i < 100; i++ {
+		interval := config.nextReconcileInterval()
+		assert.GreaterOrEqual(t, interval, time.Minute)
+		assert.LessOrEqual(t, interval, time.Minute+10*time.Second)
+	}
+
+	assert.Equal(t, time.Minute, ReloaderConfig{ReconcileInterval: time.Minute}.nextReconcileInterval())
+	assert.Equal(t, defaultReconcileInterval, ReloaderConfig{}.nextReconcileInterval())
+}
+
+func TestNamespaceReconcileIntervals(t *testing.T) {
+	controller := newTestController(nil)
+	controller.reloaderConfig = ReloaderConfig{
+		ReconcileInterval:           5 * time.Minute,
+		NamespaceReconcileIntervals: map[string]time.Duration{"payments": time.Minute},
+	}
+	assert.Equal(t, time.Minute, controller.reloaderConfig.nextReconcileInterval())
+
+	payments := workload{name: "api", namespace: "payments", kind: DeploymentKind}
+	reports := workload{name: "job", namespace: "reports", kind: DeploymentKind}
+	secretWorkloads := map[string][]workload{
+		"secret/data/payments": {payments},
+		"secret/data/reports":  {reports},
+		"secret/data/shared":   {payments, reports},
+	}
+
+	checks := make(map[string]int)
+	start := time.Now()
+	for tick := 0; tick < 10; tick++ {
+		for secretPath := range controller.dueSecretWorkloads(start.Add(time.Duration(tick)*time.Minute), secretWorkloads) {
+			checks[secretPath]++
+		}
+	}
+	// The secrets of the payments namespace are checked every run, the other ones every 5 minutes
+	assert.Equal(t, map[string]int{
+		"secret/data/payments": 10,
+		"secret/data/reports":  2,
+		"secret/data/shared":   10,
+	}, checks)
+
+	// Without namespace intervals every secret is checked
+	controller.reloaderConfig.NamespaceReconcileIntervals = nil
+	assert.Equal(t, secretWorkloads, controller.dueSecretWorkloads(start, secretWorkloads))
+}
+
+func TestWorkloadReconcileIntervals(t *testing.T) {
+	newTemplate := func(interval string) corev1.PodTemplateSpec {
+		return newTestPodTemplate(map[string]string{
+			SecretReloadAnnotationName:      "true",
+			ReconcileIntervalAnnotationName: interval,
+		}, "vault:secret/data/"+interval+"#password")
+	}
+	controller := newTestController(nil)
+	controller.reloaderConfig = ReloaderConfig{ReconcileInterval: 5 * time.Minute}
+	critical := workload{name: "critical", namespace: "default", kind: DeploymentKind}
+	invalid := workload{name: "invalid", namespace: "default", kind: DeploymentKind}
+	clamped := workload{name: "clamped", namespace: "default", kind: DeploymentKind}
+	controller.collectWorkloadSecrets(critical, nil, newTemplate("30s"))
+	controller.collectWorkloadSecrets(invalid, nil, newTemplate("soon"))
+	controller.collectWorkloadSecrets(clamped, nil, newTemplate("1s"))
+
+	// The invalid interval falls back to the default one, the too short one is raised to the minimum
+	assert.Equal(t, map[workload]time.Duration{
+		critical: 30 * time.Second,
+		clamped:  minReconcileInterval,
+	}, controller.workloadSecrets.GetReconcileIntervals())
+	assert.Equal(t, minReconcileInterval, controller.nextReconcileInterval())
+
+	checks := make(map[string]int)
+	start := time.Now()
+	secretWorkloads := controller.workloadSecrets.GetSecretWorkloadsMap()
+	for tick := 0; tick < 30; tick++ {
+		for secretPath := range controller.dueSecretWorkloads(start.Add(time.Duration(tick)*minReconcileInterval), secretWorkloads) {
+			checks[secretPath]++
+		}
+	}
+	// Over 5 minutes, the secrets of the annotated workloads are polled at their interval,
+	// the other ones at the default interval
+	assert.Equal(t, map[string]int{
+		"secret/data/30s":  10,
+		"secret/data/soon": 1,
+		"secret/data/1s":   30,
+	}, checks)
+
+	// Removing the annotation restores the default interval
+	controller.collectWorkloadSecrets(critical, nil, newTestPodTemplate(
+		map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/30s#password",
+	))
+	assert.NotContains(t, controller.workloadSecrets.GetReconcileIntervals(), critical)
+}
+
+func TestParseReconcileInterval(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"":    0,
+		"30s": 30 * time.Second,
+		"1h":  time.Hour,
+		"1ms": minReconcileInterval,
+	} {
+		interval, err := parseReconcileInterval(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, want, interval, value)
+	}
+
+	for _, value := range []string{"soon", "30", "0s", "-1m"} {
+		_, err := parseReconcileInterval(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestRunReloaderLeaderElection(t *testing.T) {
+	deployment := newTestDeployment("app", map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	vault := newTestVault(t)
+	vault.setVersion("app", 2)
+
+	kubeClient := fake.NewSimpleClientset(deployment)
+	controller := newTestController(kubeClient)
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.reloaderConfig.LeaderElection.Enabled = true
+	controller.workloadSecrets.Store(workload{name: "app", namespace: "default", kind: DeploymentKind}, []string{"secret/data/app"})
+	controller.workloadSecrets.SetVersion("secret/data/app", 1)
+
+	patches := func() int {
+		var patches int
+		for _, action := range kubeClient.Actions() {
+			if action.GetVerb() == "patch" {
+				patches++
+			}
+		}
+		return patches
+	}
+
+	// A standby replica keeps the secret versions current without updating workloads
+	controller.runReloader(context.Background())
+	assert.Zero(t, patches())
+	assertVersion(t, controller.workloadSecrets, "secret/data/app", 2)
+
+	// The change it found while standing by is reloaded once it leads
+	controller.leader.Store(true)
+	controller.runReloader(context.Background())
+	assert.Equal(t, 1, patches())
+
+	// A change the previous leader already reloaded is not reloaded again after a failover
+	controller.leader.Store(false)
+	vault.setVersion("app", 3)
+	controller.runReloader(context.Background())
+	assert.Equal(t, 1, patches())
+	previousLeader := newTestController(kubeClient)
+	previousLeader.workloadSecrets.SetVersion("secret/data/app", 3)
+	err := previousLeader.triggerVersionedReload(context.Background(), workload{name: "app", namespace: "default", kind: DeploymentKind},
+		[]string{"secret/data/app"}, previousLeader.secretVersionsHash([]string{"secret/data/app"}))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, patches())
+
+	controller.leader.Store(true)
+	controller.runReloader(context.Background())
+	assert.Equal(t, 2, patches())
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	newDeployment := func(name string) *appsv1.Deployment {
+		return newTestDeployment(name, map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	}
+	vault := newTestVault(t)
+	vault.setVersion("app", 2)
+
+	kubeClient := fake.NewSimpleClientset(newDeployment("app1"), newDeployment("app2"))
+	controller := newTestController(kubeClient)
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.reloaderConfig.ReconcileInterval = time.Hour
+	for _, name := range []string{"app1", "app2"} {
+		controller.workloadSecrets.Store(workload{name: name, namespace: "default", kind: DeploymentKind}, []string{"secret/data/app"})
+	}
+	controller.workloadSecrets.SetVersion("secret/data/app", 1)
+
+	// The shutdown signal arrives while the first reload is being applied
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	kubeClient.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		cancel()
+		return false, nil, nil
+	})
+
+	reloaderDone := make(chan struct{})
+	go func() {
+		defer close(reloaderDone)
+		controller.runReloaderLoop(ctx)
+	}()
+
+	select {
+	case <-reloaderDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reloader loop did not exit")
+	}
+	assert.NoError(t, controller.shutdown(reloaderDone))
+
+	// The in-flight reload completed, the remaining one was not started
+	var reloaded int
+	for _, name := range []string{"app1", "app2"} {
+		deployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		if deployment.Spec.Template.GetAnnotations()[ReloadCountAnnotationName] == "1" {
+			reloaded++
+		}
+	}
+	assert.Equal(t, 1, reloaded)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	controller := newTestController(nil)
+	controller.reloaderConfig.ShutdownTimeout = 10 * time.Millisecond
+
+	assert.Error(t, controller.shutdown(make(chan struct{})))
+}
+
+// testEvent is an event recorded by testRecorder
+type testEvent struct {
+	object    runtime.Object
+	eventType string
+	reason    string
+	message   string
+}
+
+// testRecorder is an EventRecorder keeping the recorded events along with their object
+type testRecorder struct {
+	events []testEvent
+}
+
+func (r *testRecorder) Event(object runtime.Object, eventType, reason, message string) {
+	r.events = append(r.events, testEvent{object: object, eventType: eventType, reason: reason, message: message})
+}
+
+func (r *testRecorder) Eventf(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	r.Event(object, eventType, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *testRecorder) AnnotatedEventf(object runtime.Object, _ map[string]string, eventType, reason, messageFmt string, args ...interface{}) {
+	r.Eventf(object, eventType, reason, messageFmt, args...)
+}
+
+func TestTriggerReloadEvents(t *testing.T) {
+	deployment := newTestDeployment("app", map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	appWorkload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+
+	t.Run("success", func(t *testing.T) {
+		recorder := &testRecorder{}
+		controller := newTestController(fake.NewSimpleClientset(deployment))
+		controller.recorder = recorder
+
+		err := controller.triggerReload(context.Background(), appWorkload, []string{"secret/data/app"})
+		assert.NoError(t, err)
+
+		assert.Len(t, recorder.events, 1)
+		event := recorder.events[0]
+		assert.Equal(t, corev1.EventTypeNormal, event.eventType)
+		assert.Equal(t, secretReloadedEventReason, event.reason)
+		assert.Contains(t, event.message, "secret/data/app")
+		object, ok := event.object.(*appsv1.Deployment)
+		assert.True(t, ok)
+		assert.Equal(t, "default", object.Namespace)
+		assert.Equal(t, "app", object.Name)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		recorder := &testRecorder{}
+		kubeClient := fake.NewSimpleClientset(deployment)
+		kubeClient.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, assert.AnError
+		})
+		controller := newTestController(kubeClient)
+		controller.recorder = recorder
+
+		err := controller.triggerReload(context.Background(), appWorkload, []string{"secret/data/app"})
+		assert.Error(t, err)
+
+		assert.Len(t, recorder.events, 1)
+		assert.Equal(t, corev1.EventTypeWarning, recorder.events[0].eventType)
+		assert.Equal(t, reloadFailedEventReason, recorder.events[0].reason)
+	})
+}
+
+func TestRunReloaderLogAttributes(t *testing.T) {
+	deployment := newTestDeployment("app", map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	vault := newTestVault(t)
+	vault.setVersion("app", 4)
+
+	controller := newTestController(fake.NewSimpleClientset(deployment))
+	var logs bytes.Buffer
+	controller.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.workloadSecrets.Store(workload{name: "app", namespace: "default", kind: DeploymentKind}, []string{"secret/data/app"})
+	controller.workloadSecrets.SetVersion("secret/data/app", 3)
+
+	controller.runReloader(context.Background())
+
+	var changed, reloading map[string]interface{}
+	decoder := json.NewDecoder(&logs)
+	for decoder.More() {
+		var record map[string]interface{}
+		assert.NoError(t, decoder.Decode(&record))
+		if _, ok := record["old_version"]; ok {
+			changed = record
+		}
+		if strings.HasPrefix(record["msg"].(string), "Reloading workload") {
+			reloading = record
+		}
+	}
+
+	assert.Equal(t, "secret/data/app", changed["secret_path"])
+	assert.Equal(t, float64(3), changed["old_version"])
+	assert.Equal(t, float64(4), changed["new_version"])
+	assert.Equal(t, "secret/data/app", reloading["secret_path"])
+}
+
+func TestRunReloaderLoopVaultUnavailable(t *testing.T) {
+	vault := newTestVault(t)
+	vault.setVersion("app", 1)
+	vault.failingOnce = []string{"app"}
+
+	controller := newTestController(fake.NewSimpleClientset())
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.reloaderConfig.ReconcileInterval = time.Hour
+	controller.reloaderConfig.VaultUnavailableBackoff = 10 * time.Millisecond
+	controller.workloadSecrets.Store(workload{name: "app", namespace: "default", kind: DeploymentKind}, []string{"secret/data/app"})
+	var logs bytes.Buffer
+	controller.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		controller.runReloaderLoop(ctx)
+	}()
+
+	// The version is only stored by the retried run, long before the reconcile interval
+	assert.Eventually(t, func() bool {
+		_, ok := controller.workloadSecrets.GetVersion("secret/data/app")
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	assertVersion(t, controller.workloadSecrets, "secret/data/app", 1)
+	assert.Equal(t, float64(1), testutil.ToFloat64(controller.metrics.vaultUnavailable))
+	// The retry is logged by the reloader worker like the rest of its run
+	assert.Regexp(t, `msg="Vault is unavailable, retrying the reloader run in 10ms".* worker=reloader`, logs.String())
+}
+
+func TestVaultUnavailableBackoff(t *testing.T) {
+	config := ReloaderConfig{VaultUnavailableBackoff: time.Second}
+	assert.Equal(t, time.Second, config.vaultUnavailableBackoff(0))
+	assert.Equal(t, 2*time.Second, config.vaultUnavailableBackoff(1))
+	assert.Equal(t, 8*time.Second, config.vaultUnavailableBackoff(3))
+	assert.Equal(t, config.vaultUnavailableBackoff(16), config.vaultUnavailableBackoff(100))
+
+	assert.Zero(t, ReloaderConfig{}.vaultUnavailableBackoff(0))
+}
+
+func TestRunReloaderSummaryLog(t *testing.T) {
+	newDeployment := func(name string, envValue string) *appsv1.Deployment {
+		return newTestDeployment(name, map[string]string{SecretReloadAnnotationName: "true"}, envValue)
+	}
+	vault := newTestVault(t)
+	vault.setVersion("app", 4)
+	vault.setVersion("db", 1)
+
+	controller := newTestController(fake.NewSimpleClientset(
+		newDeployment("app", "vault:secret/data/app#password"),
+		newDeployment("worker", "vault:secret/data/app#password"),
+		newDeployment("db", "vault:secret/data/db#password"),
+	))
+	var logs bytes.Buffer
+	controller.logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.workloadSecrets.Store(workload{name: "app", namespace: "default", kind: DeploymentKind}, []string{"secret/data/app"})
+	controller.workloadSecrets.Store(workload{name: "worker", namespace: "default", kind: DeploymentKind}, []string{"secret/data/app"})
+	controller.workloadSecrets.Store(workload{name: "db", namespace: "default", kind: DeploymentKind}, []string{"secret/data/db"})
+	controller.workloadSecrets.SetVersion("secret/data/app", 3)
+	controller.workloadSecrets.SetVersion("secret/data/db", 1)
+
+	controller.runReloader(context.Background())
+
+	var summary map[string]interface{}
+	decoder := json.NewDecoder(&logs)
+	for decoder.More() {
+		var record map[string]interface{}
+		assert.NoError(t, decoder.Decode(&record))
+		if record["msg"] == "Reconcile finished" {
+			summary = record
+		}
+	}
+
+	assert.Equal(t, "INFO", summary["level"])
+	assert.Equal(t, float64(3), summary["workloads_checked"])
+	assert.Equal(t, float64(2), summary["secrets_queried"])
+	assert.Equal(t, float64(2), summary["reloads_triggered"])
+	assert.Contains(t, summary, "duration")
+}
+
+func TestRunReloaderWildcardSecrets(t *testing.T) {
+	deployment := newTestDeployment("app", map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/team/*")
+	reloadCount := func(kubeClient *fake.Clientset) string {
+		deployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+		assert.NoError(t, err)
+		return deployment.Spec.Template.GetAnnotations()[ReloadCountAnnotationName]
+	}
+
+	vault := newTestVault(t)
+	vault.setVersion("team/api", 1)
+	vault.setVersion("team/db/postgres", 1)
+
+	kubeClient := fake.NewSimpleClientset(deployment)
+	controller := newTestController(kubeClient)
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.collectWorkloadSecrets(workload{name: "app", namespace: "default", kind: DeploymentKind}, nil, deployment.Spec.Template)
+
+	t.Run("expansion", func(t *testing.T) {
+		controller.runReloader(context.Background())
+		assertVersion(t, controller.workloadSecrets, "secret/data/team/api", 1)
+		assertVersion(t, controller.workloadSecrets, "secret/data/team/db/postgres", 1)
+		assert.Equal(t, "", reloadCount(kubeClient))
+
+		vault.setVersion("team/db/postgres", 2)
+		controller.runReloader(context.Background())
+		assert.Equal(t, "1", reloadCount(kubeClient))
+	})
+
+	t.Run("new child secret", func(t *testing.T) {
+		vault.setVersion("team/cache", 1)
+		controller.runReloader(context.Background())
+		assert.Equal(t, "2", reloadCount(kubeClient))
+		assertVersion(t, controller.workloadSecrets, "secret/data/team/cache", 1)
+
+		controller.runReloader(context.Background())
+		assert.Equal(t, "2", reloadCount(kubeClient))
+	})
+}
+
+func TestTriggerReloadRetry(t *testing.T) {
+	deployment := newTestDeployment("app", map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	appWorkload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	newFailingClient := func(failures int) *fake.Clientset {
+		kubeClient := fake.NewSimpleClientset(deployment)
+		kubeClient.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if failures > 0 {
+				failures--
+				return true, nil, apierrors.NewConflict(appsv1.Resource("deployments"), "app", assert.AnError)
+			}
+			return false, nil, nil
+		})
+		return kubeClient
+	}
+
+	t.Run("eventual success", func(t *testing.T) {
+		kubeClient := newFailingClient(2)
+		controller := newTestController(kubeClient)
+		controller.reloaderConfig.ReloadMaxAttempts = 3
+		controller.reloaderConfig.ReloadRetryBackoff = time.Millisecond
+
+		err := controller.triggerReload(context.Background(), appWorkload, []string{"secret/data/app"})
+		assert.NoError(t, err)
+
+		assert.Equal(t, float64(2), testutil.ToFloat64(controller.metrics.reloadRetries.WithLabelValues("default", DeploymentKind)))
+		assert.Equal(t, float64(0), testutil.ToFloat64(controller.metrics.reloadRetriesFailed.WithLabelValues("default", DeploymentKind)))
+		reloaded, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, "1", reloaded.Spec.Template.GetAnnotations()[ReloadCountAnnotationName])
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		recorder := &testRecorder{}
+		controller := newTestController(newFailingClient(3))
+		controller.recorder = recorder
+		controller.reloaderConfig.ReloadMaxAttempts = 3
+		controller.reloaderConfig.ReloadRetryBackoff = time.Millisecond
+
+		err := controller.triggerReload(context.Background(), appWorkload, []string{"secret/data/app"})
+		assert.True(t, apierrors.IsConflict(err))
+
+		assert.Equal(t, float64(2), testutil.ToFloat64(controller.metrics.reloadRetries.WithLabelValues("default", DeploymentKind)))
+		assert.Equal(t, float64(1), testutil.ToFloat64(controller.metrics.reloadRetriesFailed.WithLabelValues("default", DeploymentKind)))
+		assert.Len(t, recorder.events, 1)
+		assert.Equal(t, corev1.EventTypeWarning, recorder.events[0].eventType)
+	})
+
+	t.Run("cancelled while backing off", func(t *testing.T) {
+		controller := newTestController(newFailingClient(3))
+		controller.reloaderConfig.ReloadMaxAttempts = 3
+		controller.reloaderConfig.ReloadRetryBackoff = time.Hour
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err := controller.triggerReload(ctx, appWorkload, []string{"secret/data/app"})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.True(t, apierrors.IsConflict(err))
+		assert.Less(t, time.Since(start), time.Minute)
+		assert.Equal(t, float64(1), testutil.ToFloat64(controller.metrics.reloadRetries.WithLabelValues("default", DeploymentKind)))
+	})
+
+	t.Run("permanent error", func(t *testing.T) {
+		controller := newTestController(fake.NewSimpleClientset())
+		controller.reloaderConfig.ReloadMaxAttempts = 3
+
+		err := controller.triggerReload(context.Background(), appWorkload, []string{"secret/data/app"})
+		assert.True(t, apierrors.IsNotFound(err))
+		assert.Equal(t, float64(0), testutil.ToFloat64(controller.metrics.reloadRetries.WithLabelValues("default", DeploymentKind)))
+	})
+}
+
+func assertVersion(t *testing.T, store workloadSecretsStore, secretPath string, expected int) {
+	t.Helper()
+	version, ok := store.GetVersion(secretPath)
+	assert.True(t, ok, "no version stored for %s", secretPath)
+	assert.Equal(t, expected, version)
+}
+
+func TestRunReloaderSingleReloadPerWorkload(t *testing.T) {
+	deployment := newTestDeployment("app", map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	vault := newTestVault(t)
+	vault.setVersion("app", 1)
+	vault.setVersion("db", 1)
+	vault.setVersion("team/api", 1)
+
+	kubeClient := fake.NewSimpleClientset(deployment)
+	var patches int
+	kubeClient.PrependReactor("patch", "deployments", func(k8stesting.Action) (bool, runtime.Object, error) {
+		patches++
+		return false, nil, nil
+	})
+	recorder := &testRecorder{}
+	controller := newTestController(kubeClient)
+	controller.recorder = recorder
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	// secret/data/team/api is tracked both directly and below the wildcard path
+	controller.workloadSecrets.Store(workload{name: "app", namespace: "default", kind: DeploymentKind},
+		[]string{"secret/data/app", "secret/data/db", "secret/data/team/*", "secret/data/team/api"})
+
+	controller.runReloader(context.Background())
+	assert.Zero(t, patches)
+
+	vault.setVersion("app", 2)
+	vault.setVersion("db", 2)
+	vault.setVersion("team/api", 2)
+	controller.runReloader(context.Background())
+	assert.Equal(t, 1, patches)
+	assert.Len(t, recorder.events, 1)
+	assert.Equal(t, "Reloaded after Vault secrets changed: secret/data/app, secret/data/db, secret/data/team/api", recorder.events[0].message)
+}
+
+func TestReloadQueue(t *testing.T) {
+	app := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	db := workload{name: "db", namespace: "default", kind: StatefulSetKind}
+
+	queue := make(reloadQueue)
+	queue.add(app, "secret/data/db")
+	queue.add(app, "secret/data/app", "secret/data/db")
+	queue.add(db, "secret/data/db")
+	assert.True(t, queue.has(app))
+	assert.False(t, queue.has(workload{name: "other", namespace: "default", kind: DeploymentKind}))
+	assert.Equal(t, reloadQueue{
+		app: {"secret/data/app", "secret/data/db"},
+		db:  {"secret/data/db"},
+	}, queue)
+}
+
+func TestRunReloaderVersionIncrement(t *testing.T) {
+	deployment := newTestDeployment("app", map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	vault := newTestVault(t)
+	vault.setVersion("app", 1)
+
+	kubeClient := fake.NewSimpleClientset(deployment)
+	controller := newTestController(kubeClient)
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	appWorkload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	controller.workloadSecrets.Store(appWorkload, []string{"secret/data/app"})
+
+	controller.runReloader(context.Background())
+	assertVersion(t, controller.workloadSecrets, "secret/data/app", 1)
+	_, ok := controller.workloadSecrets.GetLastReload(appWorkload)
+	assert.False(t, ok)
+
+	vault.setVersion("app", 2)
+	controller.runReloader(context.Background())
+	assertVersion(t, controller.workloadSecrets, "secret/data/app", 2)
+	_, ok = controller.workloadSecrets.GetLastReload(appWorkload)
+	assert.True(t, ok)
+
+	// Versions of secrets not used anymore are dropped
+	controller.workloadSecrets.Store(appWorkload, []string{"secret/data/other"})
+	controller.runReloader(context.Background())
+	_, ok = controller.workloadSecrets.GetVersion("secret/data/app")
+	assert.False(t, ok)
+}
+
+func TestRunReloaderCustomMetadata(t *testing.T) {
+	deployment := newTestDeployment("app", map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	vault := newTestVault(t)
+	vault.setVersion("app", 1)
+	vault.setCustomMetadata("app", map[string]string{"reload_token": "a", "owner": "team-a"})
+
+	kubeClient := fake.NewSimpleClientset(deployment)
+	controller := newTestController(kubeClient)
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.reloaderConfig.ReloadMetadataKey = "reload_token"
+	controller.workloadSecrets.Store(workload{name: "app", namespace: "default", kind: DeploymentKind}, []string{"secret/data/app"})
+	reloadCount := func() string {
+		deployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+		assert.NoError(t, err)
+		return deployment.Spec.Template.GetAnnotations()[ReloadCountAnnotationName]
+	}
+
+	controller.runReloader(context.Background())
+	assert.Empty(t, reloadCount())
+
+	// Other custom metadata keys changing doesn't reload the workload
+	vault.setCustomMetadata("app", map[string]string{"reload_token": "a", "owner": "team-b"})
+	controller.runReloader(context.Background())
+	assert.Empty(t, reloadCount())
+
+	// The reload key changing reloads the workload while the version is unchanged
+	vault.setCustomMetadata("app", map[string]string{"reload_token": "b", "owner": "team-b"})
+	controller.runReloader(context.Background())
+	assert.Equal(t, "1", reloadCount())
+	assertVersion(t, controller.workloadSecrets, "secret/data/app", 1)
+	value, ok := controller.workloadSecrets.GetMetadataValue("secret/data/app")
+	assert.True(t, ok)
+	assert.Equal(t, "b", value)
+
+	controller.runReloader(context.Background())
+	assert.Equal(t, "1", reloadCount())
+
+	// Removing the reload key changes its value too
+	vault.setCustomMetadata("app", map[string]string{"owner": "team-b"})
+	controller.runReloader(context.Background())
+	assert.Equal(t, "2", reloadCount())
+}
+
+func TestRunReloaderInitialGracePeriod(t *testing.T) {
+	newDeployment := func(name string) *appsv1.Deployment {
+		return newTestDeployment(name, map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	}
+	vault := newTestVault(t)
+	vault.setVersion("app", 1)
+
+	existing, created := newDeployment("existing"), newDeployment("created")
+	kubeClient := fake.NewSimpleClientset(existing, created)
+	controller := newTestController(kubeClient)
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.reloaderConfig.InitialGracePeriod = time.Hour
+
+	controller.handleObject(existing)
+	controller.existingCollected.Store(true)
+	controller.runReloader(context.Background())
+
+	// The secret changes right as a new workload using it is created
+	vault.setVersion("app", 2)
+	controller.handleObject(created)
+	controller.runReloader(context.Background())
+
+	assertVersion(t, controller.workloadSecrets, "secret/data/app", 2)
+	_, ok := controller.workloadSecrets.GetLastReload(workload{name: "existing", namespace: "default", kind: DeploymentKind})
+	assert.True(t, ok)
+	_, ok = controller.workloadSecrets.GetLastReload(workload{name: "created", namespace: "default", kind: DeploymentKind})
+	assert.False(t, ok)
+
+	// Once the grace period elapsed, the new workload is reloaded like the others
+	controller.workloadSecrets.SetFirstSeen(workload{name: "created", namespace: "default", kind: DeploymentKind}, time.Now().Add(-time.Hour))
+	vault.setVersion("app", 3)
+	controller.runReloader(context.Background())
+	_, ok = controller.workloadSecrets.GetLastReload(workload{name: "created", namespace: "default", kind: DeploymentKind})
+	assert.True(t, ok)
+}
+
+func TestRunReloaderMultipleVaultServers(t *testing.T) {
+	globalVault := newTestVault(t)
+	regionalVault := newTestVault(t)
+	globalVault.setVersion("app", 2)
+	regionalVault.setVersion("app", 7)
+
+	global := newTestDeployment("global", map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	regional := newTestDeployment("regional", map[string]string{
+		SecretReloadAnnotationName: "true",
+		VaultAddrAnnotation:        regionalVault.server.URL,
+	}, "vault:secret/data/app#password")
+
+	kubeClient := fake.NewSimpleClientset(global, regional)
+	controller := newTestController(kubeClient)
+	controller.vaultClient = globalVault.client(t)
+	controller.vaultConfig = &VaultConfig{Addr: globalVault.server.URL}
+	controller.vaultAuthenticator = &tokenAuthenticator{token: "test"}
+	controller.collectorConfig.AllowedVaultAddrs = []string{regionalVault.server.URL}
+	globalWorkload := workload{name: "global", namespace: "default", kind: DeploymentKind}
+	regionalWorkload := workload{name: "regional", namespace: "default", kind: DeploymentKind}
+	controller.collectWorkloadSecrets(globalWorkload, nil, global.Spec.Template)
+	controller.collectWorkloadSecrets(regionalWorkload, nil, regional.Spec.Template)
+	regionalSecretPath := regionalVault.server.URL + "|secret/data/app"
+
+	// Every workload gets the version of its own Vault server
+	controller.runReloader(context.Background())
+	assertVersion(t, controller.workloadSecrets, "secret/data/app", 2)
+	assertVersion(t, controller.workloadSecrets, regionalSecretPath, 7)
+	assert.Len(t, controller.vaultClients, 1)
+
+	// Only the workload of the Vault server the secret changed in is reloaded
+	regionalVault.setVersion("app", 8)
+	controller.runReloader(context.Background())
+	assertVersion(t, controller.workloadSecrets, "secret/data/app", 2)
+	assertVersion(t, controller.workloadSecrets, regionalSecretPath, 8)
+	_, ok := controller.workloadSecrets.GetLastReload(globalWorkload)
+	assert.False(t, ok)
+	_, ok = controller.workloadSecrets.GetLastReload(regionalWorkload)
+	assert.True(t, ok)
+}
+
+func TestReloadWorkloadsMaxConcurrency(t *testing.T) {
+	var deployments []runtime.Object
+	workloadsToReload := make(map[workload][]string)
+	forcedReloads := make(map[workload][]string)
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("app%d", i)
+		deployments = append(deployments, newTestDeployment(name, map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password"))
+		if i%2 == 0 {
+			workloadsToReload[workload{name: name, namespace: "default", kind: DeploymentKind}] = []string{"secret/data/app"}
+		} else {
+			forcedReloads[workload{name: name, namespace: "default", kind: DeploymentKind}] = []string{"secret/data/app"}
+		}
+	}
+
+	kubeClient := fake.NewSimpleClientset(deployments...)
+	controller := newTestController(kubeClient)
+	controller.reloaderConfig.MaxConcurrentReloads = 2
+	controller.reloadSemaphore = newReloadSemaphore(controller.reloaderConfig.MaxConcurrentReloads)
+
+	// A reload is in flight from reading the workload until it is updated
+	var inFlight, maxInFlight int
+	kubeClient.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		return false, nil, nil
+	})
+	kubeClient.PrependReactor("patch", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		time.Sleep(10 * time.Millisecond)
+		inFlight--
+		return false, nil, nil
+	})
+
+	// The forced reloads share the limit with the ones of the reloader
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		controller.reloadConcurrently(context.Background(), controller.logger, forcedReloads, false)
+	}()
+	controller.reloadWorkloads(context.Background(), controller.logger, workloadsToReload)
+	wg.Wait()
+
+	assert.LessOrEqual(t, maxInFlight, 2)
+	maps.Copy(workloadsToReload, forcedReloads)
+	for workload := range workloadsToReload {
+		_, ok := controller.workloadSecrets.GetLastReload(workload)
+		assert.True(t, ok, "workload %s was not reloaded", workload)
+	}
+}
+
+func TestReloadReplicaSet(t *testing.T) {
+	template := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	template.Labels = map[string]string{"app": "app"}
+	kubeClient := fake.NewSimpleClientset(
+		&appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Spec: appsv1.ReplicaSetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}},
+				Template: template,
+			},
+		},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "default", Labels: map[string]string{"app": "app"}}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", Labels: map[string]string{"app": "other"}}},
+	)
+	controller := newTestController(kubeClient)
+
+	_, err := controller.reloadWorkload(workload{name: "app", namespace: "default", kind: ReplicaSetKind}, "")
+	assert.NoError(t, err)
+
+	// The reload is recorded in the pod template and the pods are recreated with it
+	replicaSet, err := kubeClient.AppsV1().ReplicaSets("default").Get(context.Background(), "app", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", replicaSet.Spec.Template.GetAnnotations()[ReloadCountAnnotationName])
+	pods, err := kubeClient.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, pods.Items, 1)
+	assert.Equal(t, "other", pods.Items[0].Name)
+}
+
+func TestReloadFieldManager(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: DeploymentKind},
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Template: newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password"),
+		},
+	}
+	appWorkload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+
+	// The API server records the patch request of the workload, the fake clientset drops its options
+	var patch *http.Request
+	var patchBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/apis/apps/v1/namespaces/default/deployments/app" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			patch = r
+			patchBody, _ = io.ReadAll(r.Body)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(deployment)
+	}))
+	defer server.Close()
+	kubeClient, err := kubernetes.NewForConfig(&rest.Config{Host: server.URL})
+	assert.NoError(t, err)
+
+	t.Run("default", func(t *testing.T) {
+		controller := newTestController(kubeClient)
+
+		_, err := controller.reloadWorkload(appWorkload, "versions")
+		assert.NoError(t, err)
+
+		if assert.NotNil(t, patch) {
+			assert.Equal(t, string(types.ApplyPatchType), patch.Header.Get("Content-Type"))
+			assert.Equal(t, "vault-secrets-reloader", patch.URL.Query().Get("fieldManager"))
+			assert.Equal(t, "true", patch.URL.Query().Get("force"))
+			assert.JSONEq(t, `{
+				"apiVersion": "apps/v1",
+				"kind": "Deployment",
+				"metadata": {"name": "app", "namespace": "default"},
+				"spec": {"template": {"metadata": {"annotations": {
+					"alpha.vault.security.banzaicloud.io/secret-reload-count": "1",
+					"alpha.vault.security.banzaicloud.io/secret-versions": "versions"
+				}}}}
+			}`, string(patchBody))
+		}
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		patch = nil
+		controller := newTestController(kubeClient)
+		controller.reloaderConfig.FieldManager = "secrets-reloader"
+
+		_, err := controller.reloadWorkload(appWorkload, "")
+		assert.NoError(t, err)
+
+		if assert.NotNil(t, patch) {
+			assert.Equal(t, string(types.ApplyPatchType), patch.Header.Get("Content-Type"))
+			assert.Equal(t, "secrets-reloader", patch.URL.Query().Get("fieldManager"))
+		}
+	})
+}
+
+func TestReloadPatchStrategy(t *testing.T) {
+	tests := []struct {
+		name      string
+		strategy  PatchStrategy
+		patchType types.PatchType
+		force     bool
+	}{
+		{name: "default", patchType: types.ApplyPatchType, force: true},
+		{name: "server-side apply", strategy: PatchServerSideApply, patchType: types.ApplyPatchType, force: true},
+		{name: "strategic merge", strategy: PatchStrategicMerge, patchType: types.StrategicMergePatchType},
+		{name: "JSON merge", strategy: PatchJSONMerge, patchType: types.MergePatchType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+			template.Annotations["team"] = "payments"
+			kubeClient := fake.NewSimpleClientset(&appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+				Spec:       appsv1.DeploymentSpec{Template: template},
+			})
+			controller := newTestController(kubeClient)
+			controller.reloaderConfig.PatchStrategy = tt.strategy
+
+			_, err := controller.reloadWorkload(workload{name: "app", namespace: "default", kind: DeploymentKind}, "versions")
+			assert.NoError(t, err)
+
+			var patches []k8stesting.PatchAction
+			for _, action := range kubeClient.Actions() {
+				if patch, ok := action.(k8stesting.PatchAction); ok {
+					patches = append(patches, patch)
+				}
+			}
+			if assert.Len(t, patches, 1) {
+				assert.Equal(t, tt.patchType, patches[0].GetPatchType())
+				if tt.patchType != types.ApplyPatchType {
+					assert.JSONEq(t, `{"spec": {"template": {"metadata": {"annotations": {
+						"alpha.vault.security.banzaicloud.io/secret-reload-count": "1",
+						"alpha.vault.security.banzaicloud.io/secret-versions": "versions"
+					}}}}}`, string(patches[0].GetPatch()))
+				}
+			}
+			assert.Equal(t, tt.force, controller.reloadPatchOptions(tt.patchType).Force != nil)
+
+			// The other annotations of the pod template are kept
+			deployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, "1", deployment.Spec.Template.Annotations[ReloadCountAnnotationName])
+			assert.Equal(t, "versions", deployment.Spec.Template.Annotations[SecretVersionsAnnotationName])
+			assert.Equal(t, "payments", deployment.Spec.Template.Annotations["team"])
+		})
+	}
+}
+
+func TestReloadRestartAnnotation(t *testing.T) {
+	template := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	template.Annotations["kubectl.kubernetes.io/restartedAt"] = "2024-01-01T00:00:00Z"
+	kubeClient := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Template: template},
+	})
+	controller := newTestController(kubeClient)
+	controller.reloaderConfig.RestartAnnotation = "kubectl.kubernetes.io/restartedAt"
+	appWorkload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+
+	annotations := func() map[string]string {
+		deployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+		assert.NoError(t, err)
+		return deployment.Spec.Template.Annotations
+	}
+
+	// A value set by another tool is replaced by the reload count
+	_, err := controller.reloadWorkload(appWorkload, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "1", annotations()["kubectl.kubernetes.io/restartedAt"])
+
+	_, err = controller.reloadWorkload(appWorkload, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", annotations()["kubectl.kubernetes.io/restartedAt"])
+	assert.NotContains(t, annotations(), ReloadCountAnnotationName)
+}
+
+func TestReloadWorkloadStrategies(t *testing.T) {
+	newObjects := func(annotations map[string]string) []runtime.Object {
+		annotations[SecretReloadAnnotationName] = "true"
+		template := newTestPodTemplate(annotations, "vault:secret/data/app#password")
+		template.Labels = map[string]string{"app": "app"}
+		return []runtime.Object{
+			&appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "app"}},
+					Template: template,
+				},
+			},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "default", Labels: map[string]string{"app": "app"}}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app-2", Namespace: "default", Labels: map[string]string{"app": "app"}}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", Labels: map[string]string{"app": "other"}}},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		strategy        ReloadStrategy
+		annotations     map[string]string
+		wantReloadCount string
+		wantPods        []string
+	}{
+		{
+			name:            "rollout restart by default",
+			annotations:     map[string]string{},
+			wantReloadCount: "1",
+			wantPods:        []string{"app-1", "app-2", "other"},
+		},
+		{
+			name:        "delete pods",
+			strategy:    ReloadDeletePods,
+			annotations: map[string]string{},
+			wantPods:    []string{"other"},
+		},
+		{
+			name:        "delete pods set by annotation",
+			strategy:    ReloadRolloutRestart,
+			annotations: map[string]string{ReloadStrategyAnnotationName: string(ReloadDeletePods)},
+			wantPods:    []string{"other"},
+		},
+		{
+			name:            "rollout restart set by annotation",
+			strategy:        ReloadDeletePods,
+			annotations:     map[string]string{ReloadStrategyAnnotationName: string(ReloadRolloutRestart)},
+			wantReloadCount: "1",
+			wantPods:        []string{"app-1", "app-2", "other"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset(newObjects(tt.annotations)...)
+			controller := newTestController(kubeClient)
+			controller.reloaderConfig.ReloadStrategy = tt.strategy
+
+			_, err := controller.reloadWorkload(workload{name: "app", namespace: "default", kind: DeploymentKind}, "")
+			assert.NoError(t, err)
+
+			deployment, err := kubeClient.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantReloadCount, deployment.Spec.Template.GetAnnotations()[ReloadCountAnnotationName])
+
+			pods, err := kubeClient.CoreV1().Pods("default").List(context.Background(), metav1.ListOptions{})
+			assert.NoError(t, err)
+			var podNames []string
+			for _, pod := range pods.Items {
+				podNames = append(podNames, pod.Name)
+			}
+			assert.ElementsMatch(t, tt.wantPods, podNames)
+		})
+	}
+}
+
+func TestRunReloaderMissingSecretPolicy(t *testing.T) {
+	appWorkload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	newController := func(t *testing.T, policy MissingSecretPolicy) (*Controller, *bytes.Buffer) {
+		vault := newTestVault(t)
+		vault.setVersion("app", 1)
+
+		controller := newTestController(fake.NewSimpleClientset())
+		var logs bytes.Buffer
+		controller.logger = slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		controller.vaultClient = vault.client(t)
+		controller.vaultConfig = &VaultConfig{}
+		controller.reloaderConfig.MissingSecretPolicy = policy
+		// secret/data/deleted is not in Vault anymore
+		controller.workloadSecrets.Store(appWorkload, []string{"secret/data/app", "secret/data/deleted"})
+		return controller, &logs
+	}
+	missingSecretLevel := func(t *testing.T, logs *bytes.Buffer) string {
+		decoder := json.NewDecoder(logs)
+		for decoder.More() {
+			var record map[string]interface{}
+			assert.NoError(t, decoder.Decode(&record))
+			if record["msg"] == "Vault secret path secret/data/deleted not found" ||
+				strings.HasPrefix(record["msg"].(string), "Untracking secret path secret/data/deleted") {
+				return record["level"].(string)
+			}
+		}
+		return ""
+	}
+
+	t.Run("ignore", func(t *testing.T) {
+		controller, logs := newController(t, MissingSecretIgnore)
+		controller.runReloader(context.Background())
+
+		assert.Equal(t, "DEBUG", missingSecretLevel(t, logs))
+		assert.Equal(t, float64(0), testutil.ToFloat64(controller.metrics.missingSecrets))
+		assert.Contains(t, controller.workloadSecrets.GetSecretWorkloadsMap(), "secret/data/deleted")
+	})
+
+	t.Run("warn", func(t *testing.T) {
+		controller, logs := newController(t, MissingSecretWarn)
+		controller.runReloader(context.Background())
+
+		assert.Equal(t, "WARN", missingSecretLevel(t, logs))
+		assert.Equal(t, float64(1), testutil.ToFloat64(controller.metrics.missingSecrets))
+		assert.Contains(t, controller.workloadSecrets.GetSecretWorkloadsMap(), "secret/data/deleted")
+	})
+
+	t.Run("untrack", func(t *testing.T) {
+		controller, logs := newController(t, MissingSecretUntrack)
+		controller.runReloader(context.Background())
+
+		assert.Equal(t, "INFO", missingSecretLevel(t, logs))
+		assert.Equal(t, float64(0), testutil.ToFloat64(controller.metrics.missingSecrets))
+		assert.Equal(t, map[workload][]string{appWorkload: {"secret/data/app"}}, controller.workloadSecrets.GetWorkloadSecretsMap())
+
+		// The path is not tracked again when the workload is collected again
+		controller.workloadSecrets.Store(appWorkload, []string{"secret/data/app", "secret/data/deleted"})
+		assert.Equal(t, map[workload][]string{appWorkload: {"secret/data/app"}}, controller.workloadSecrets.GetWorkloadSecretsMap())
+	})
+
+	t.Run("default", func(t *testing.T) {
+		controller, logs := newController(t, "")
+		controller.runReloader(context.Background())
+
+		assert.Equal(t, "ERROR", missingSecretLevel(t, logs))
+	})
+}
@@ -0,0 +1,102 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	reloadHookStagePre  = "pre-reload"
+	reloadHookStagePost = "post-reload"
+
+	reloadHookTimeout = 10 * time.Second
+)
+
+// ReloadHooksConfig holds the URLs notified before and after every reload
+type ReloadHooksConfig struct {
+	PreReloadURL  string
+	PostReloadURL string
+	// BlockOnPreReloadFailure aborts the reload if the pre-reload hook fails
+	// or responds with a non-2xx status
+	BlockOnPreReloadFailure bool
+}
+
+// reloadHookPayload is the JSON body POSTed to the reload hooks
+type reloadHookPayload struct {
+	Stage       string         `json:"stage"`
+	Namespace   string         `json:"namespace"`
+	Kind        string         `json:"kind"`
+	Name        string         `json:"name"`
+	SecretPaths []string       `json:"secretPaths"`
+	Versions    map[string]int `json:"versions,omitempty"`
+	Timestamp   time.Time      `json:"timestamp"`
+	Outcome     string         `json:"outcome,omitempty"`
+	Error       string         `json:"error,omitempty"`
+}
+
+var reloadHookClient = &http.Client{Timeout: reloadHookTimeout}
+
+// newReloadHookPayload describes the reload of a workload with the last observed
+// versions of its changed secret paths
+func (c *Controller) newReloadHookPayload(stage string, workload workload, changedSecretPaths []string) reloadHookPayload {
+	payload := reloadHookPayload{
+		Stage:       stage,
+		Namespace:   workload.namespace,
+		Kind:        workload.kind,
+		Name:        workload.name,
+		SecretPaths: changedSecretPaths,
+		Timestamp:   time.Now().UTC(),
+	}
+	for _, secretPath := range changedSecretPaths {
+		if version, ok := c.workloadSecrets.GetVersion(secretPath); ok {
+			if payload.Versions == nil {
+				payload.Versions = make(map[string]int)
+			}
+			payload.Versions[secretPath] = version
+		}
+	}
+	return payload
+}
+
+// callReloadHook POSTs the payload to a reload hook, failing on non-2xx responses
+func callReloadHook(ctx context.Context, url string, payload reloadHookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := reloadHookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s hook responded with status %d", payload.Stage, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testReloadHook records the payloads POSTed to it, responding with status
+type testReloadHook struct {
+	mu       sync.Mutex
+	status   int
+	payloads []reloadHookPayload
+}
+
+func newTestReloadHook(t *testing.T, status int) (*testReloadHook, string) {
+	hook := &testReloadHook{status: status}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload reloadHookPayload
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+
+		hook.mu.Lock()
+		defer hook.mu.Unlock()
+		hook.payloads = append(hook.payloads, payload)
+		w.WriteHeader(hook.status)
+	}))
+	t.Cleanup(server.Close)
+	return hook, server.URL
+}
+
+func (h *testReloadHook) stages() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stages := []string{}
+	for _, payload := range h.payloads {
+		stages = append(stages, payload.Stage)
+	}
+	return stages
+}
+
+func TestReloadHooks(t *testing.T) {
+	deployment := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	newController := func(hooks ReloadHooksConfig) *Controller {
+		controller := newTestController(fake.NewSimpleClientset(&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+			Spec:       appsv1.DeploymentSpec{Template: newTestPodTemplate(nil, "vault:secret/data/app#password")},
+		}))
+		controller.reloaderConfig.ReloadHooks = hooks
+		controller.workloadSecrets.SetVersion("secret/data/app", 3)
+		return controller
+	}
+	reloadCount := func(controller *Controller) string {
+		deployment, err := controller.kubeClient.AppsV1().Deployments("default").Get(context.Background(), "app", metav1.GetOptions{})
+		assert.NoError(t, err)
+		return deployment.Spec.Template.Annotations[ReloadCountAnnotationName]
+	}
+
+	t.Run("pre and post reload", func(t *testing.T) {
+		hook, url := newTestReloadHook(t, http.StatusOK)
+		controller := newController(ReloadHooksConfig{PreReloadURL: url, PostReloadURL: url})
+
+		assert.NoError(t, controller.triggerReload(context.Background(), deployment, []string{"secret/data/app"}))
+		assert.Equal(t, "1", reloadCount(controller))
+		assert.Equal(t, []string{reloadHookStagePre, reloadHookStagePost}, hook.stages())
+
+		pre, post := hook.payloads[0], hook.payloads[1]
+		assert.Equal(t, "default", pre.Namespace)
+		assert.Equal(t, DeploymentKind, pre.Kind)
+		assert.Equal(t, "app", pre.Name)
+		assert.Equal(t, []string{"secret/data/app"}, pre.SecretPaths)
+		assert.Equal(t, map[string]int{"secret/data/app": 3}, pre.Versions)
+		assert.False(t, pre.Timestamp.IsZero())
+		assert.Empty(t, pre.Outcome)
+		assert.Equal(t, reloadOutcomeSuccess, post.Outcome)
+		assert.Empty(t, post.Error)
+	})
+
+	t.Run("failing pre-reload hook blocks the reload", func(t *testing.T) {
+		preHook, preURL := newTestReloadHook(t, http.StatusForbidden)
+		postHook, postURL := newTestReloadHook(t, http.StatusOK)
+		controller := newController(ReloadHooksConfig{PreReloadURL: preURL, PostReloadURL: postURL, BlockOnPreReloadFailure: true})
+
+		assert.Error(t, controller.triggerReload(context.Background(), deployment, []string{"secret/data/app"}))
+		assert.Empty(t, reloadCount(controller))
+		assert.Equal(t, []string{reloadHookStagePre}, preHook.stages())
+		assert.Empty(t, postHook.stages())
+	})
+
+	t.Run("failing pre-reload hook without blocking", func(t *testing.T) {
+		preHook, preURL := newTestReloadHook(t, http.StatusInternalServerError)
+		postHook, postURL := newTestReloadHook(t, http.StatusOK)
+		controller := newController(ReloadHooksConfig{PreReloadURL: preURL, PostReloadURL: postURL})
+
+		assert.NoError(t, controller.triggerReload(context.Background(), deployment, []string{"secret/data/app"}))
+		assert.Equal(t, "1", reloadCount(controller))
+		assert.Equal(t, []string{reloadHookStagePre}, preHook.stages())
+		assert.Equal(t, []string{reloadHookStagePost}, postHook.stages())
+	})
+
+	t.Run("failed reload", func(t *testing.T) {
+		hook, url := newTestReloadHook(t, http.StatusOK)
+		controller := newController(ReloadHooksConfig{PostReloadURL: url})
+
+		missing := workload{name: "missing", namespace: "default", kind: DeploymentKind}
+		assert.Error(t, controller.triggerReload(context.Background(), missing, []string{"secret/data/app"}))
+		assert.Equal(t, []string{reloadHookStagePost}, hook.stages())
+		assert.Equal(t, reloadOutcomeError, hook.payloads[0].Outcome)
+		assert.NotEmpty(t, hook.payloads[0].Error)
+	})
+}
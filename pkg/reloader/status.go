@@ -0,0 +1,87 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// reconcileStatus records the outcome of the reconcile cycles for the status endpoint
+type reconcileStatus struct {
+	mu                      sync.Mutex
+	lastSuccessfulReconcile time.Time
+	lastError               string
+	lastErrorTime           time.Time
+	reloadsTriggered        int
+}
+
+// recordReconcile records the end of a reconcile cycle, which failed if err is not nil
+func (s *reconcileStatus) recordReconcile(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.lastError = err.Error()
+		s.lastErrorTime = time.Now()
+		return
+	}
+	s.lastSuccessfulReconcile = time.Now()
+}
+
+func (s *reconcileStatus) recordReload() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reloadsTriggered++
+}
+
+// statusResponse is the body of the status endpoint, the times are omitted until set
+type statusResponse struct {
+	LastSuccessfulReconcile *time.Time `json:"lastSuccessfulReconcile,omitempty"`
+	LastError               string     `json:"lastError,omitempty"`
+	LastErrorTime           *time.Time `json:"lastErrorTime,omitempty"`
+	TrackedWorkloads        int        `json:"trackedWorkloads"`
+	ReloadsTriggered        int        `json:"reloadsTriggered"`
+}
+
+// StatusHandler returns a handler reporting the last successful reconcile, the last
+// reconcile error, the number of tracked workloads and of reloads triggered since start
+func (c *Controller) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		c.status.mu.Lock()
+		response := statusResponse{
+			LastError:        c.status.lastError,
+			ReloadsTriggered: c.status.reloadsTriggered,
+		}
+		if !c.status.lastSuccessfulReconcile.IsZero() {
+			lastSuccessfulReconcile := c.status.lastSuccessfulReconcile
+			response.LastSuccessfulReconcile = &lastSuccessfulReconcile
+		}
+		if !c.status.lastErrorTime.IsZero() {
+			lastErrorTime := c.status.lastErrorTime
+			response.LastErrorTime = &lastErrorTime
+		}
+		c.status.mu.Unlock()
+		response.TrackedWorkloads = len(c.workloadSecrets.GetWorkloadSecretsMap())
+
+		writeJSON(w, response)
+	})
+}
@@ -0,0 +1,89 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStatusHandler(t *testing.T) {
+	vault := newTestVault(t)
+	vault.setVersion("app", 1)
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Template: newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password"),
+		},
+	}
+	controller := newTestController(fake.NewSimpleClientset(deployment))
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.collectWorkloadSecrets(workload{name: "app", namespace: "default", kind: DeploymentKind}, nil, deployment.Spec.Template)
+
+	getStatus := func() statusResponse {
+		recorder := httptest.NewRecorder()
+		controller.StatusHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		var status statusResponse
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+		return status
+	}
+
+	status := getStatus()
+	assert.Nil(t, status.LastSuccessfulReconcile)
+	assert.Equal(t, 1, status.TrackedWorkloads)
+	assert.Equal(t, 0, status.ReloadsTriggered)
+
+	start := time.Now()
+	controller.runReloader(context.Background())
+	vault.setVersion("app", 2)
+	controller.runReloader(context.Background())
+
+	status = getStatus()
+	if assert.NotNil(t, status.LastSuccessfulReconcile) {
+		assert.WithinDuration(t, start, *status.LastSuccessfulReconcile, time.Minute)
+	}
+	assert.Empty(t, status.LastError)
+	assert.Nil(t, status.LastErrorTime)
+	assert.Equal(t, 1, status.ReloadsTriggered)
+
+	// A failed cycle keeps the last successful reconcile
+	lastSuccessfulReconcile := *status.LastSuccessfulReconcile
+	vault.Lock()
+	vault.failing = []string{"app"}
+	vault.Unlock()
+	controller.runReloader(context.Background())
+
+	status = getStatus()
+	assert.Equal(t, lastSuccessfulReconcile, *status.LastSuccessfulReconcile)
+	assert.Contains(t, status.LastError, "failed to get secret version from Vault")
+	assert.NotNil(t, status.LastErrorTime)
+	assert.Equal(t, 1, status.ReloadsTriggered)
+
+	recorder := httptest.NewRecorder()
+	controller.StatusHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/status", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
@@ -0,0 +1,57 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+// tracerName is the instrumentation scope of the spans of the reconcile cycles
+const tracerName = "github.com/bank-vaults/vault-secrets-reloader/pkg/reloader"
+
+// TracingConfig configures the OpenTelemetry tracing of the reconcile cycles
+type TracingConfig struct {
+	// OTLPEndpoint is the host:port of the OTLP HTTP collector, the OTEL_EXPORTER_OTLP_*
+	// environment variables are used if empty
+	OTLPEndpoint string
+	OTLPInsecure bool
+}
+
+// NewTracerProvider returns a tracer provider exporting the spans in batches
+// to an OTLP HTTP collector
+func NewTracerProvider(ctx context.Context, config TracingConfig) (*sdktrace.TracerProvider, error) {
+	var options []otlptracehttp.Option
+	if config.OTLPEndpoint != "" {
+		options = append(options, otlptracehttp.WithEndpoint(config.OTLPEndpoint))
+	}
+	if config.OTLPInsecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("vault-secrets-reloader"))),
+	), nil
+}
@@ -0,0 +1,71 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRunReloaderTracing(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Template: newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password"),
+		},
+	}
+	vault := newTestVault(t)
+	vault.setVersion("app", 2)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	controller := newTestController(fake.NewSimpleClientset(deployment))
+	controller.tracer = tracerProvider.Tracer(tracerName)
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.workloadSecrets.Store(workload{name: "app", namespace: "default", kind: DeploymentKind}, []string{"secret/data/app"})
+	controller.workloadSecrets.SetVersion("secret/data/app", 1)
+
+	controller.runReloader(context.Background())
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+	}
+	assert.Len(t, spans, 3)
+
+	reconcile := spans["reconcile"]
+	assert.False(t, reconcile.Parent.IsValid())
+
+	lookup := spans["vault.lookup"]
+	assert.Equal(t, reconcile.SpanContext.TraceID(), lookup.SpanContext.TraceID())
+	assert.Equal(t, reconcile.SpanContext.SpanID(), lookup.Parent.SpanID())
+	assert.Contains(t, lookup.Attributes, attribute.String("secret_path", "secret/data/app"))
+
+	reload := spans["reload"]
+	assert.Equal(t, reconcile.SpanContext.SpanID(), reload.Parent.SpanID())
+	assert.Contains(t, reload.Attributes, attribute.String("workload.namespace", "default"))
+	assert.Contains(t, reload.Attributes, attribute.String("workload.kind", DeploymentKind))
+	assert.Contains(t, reload.Attributes, attribute.String("workload.name", "app"))
+	assert.Contains(t, reload.Attributes, attribute.StringSlice("secret_paths", []string{"secret/data/app"}))
+}
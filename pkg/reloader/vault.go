@@ -16,16 +16,22 @@ package reloader
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/bank-vaults/vault-sdk/vault"
+	"github.com/hashicorp/go-retryablehttp"
 	vaultapi "github.com/hashicorp/vault/api"
+	"golang.org/x/time/rate"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -40,15 +46,37 @@ type VaultConfig struct {
 	TLSSecretNS          string
 	ClientTimeout        time.Duration
 	IgnoreMissingSecrets bool
+	// AppRoleID and AppRoleSecretID are the credentials of the approle auth method
+	AppRoleID       string
+	AppRoleSecretID string
+	// Token is the static token of the token auth method
+	Token string
+	// ServiceAccountTokenPath is the token file the kubernetes auth method logs in with
+	ServiceAccountTokenPath string
+	// RateLimit is the number of requests per second sent to each Vault server, with bursts
+	// of RateLimitBurst requests, requests are not limited if it is zero
+	RateLimit      float64
+	RateLimitBurst int
+	// CACert is a PEM encoded CA bundle file the certificate of Vault is verified with, the
+	// client certificate and key files are presented to Vault if they are set
+	CACert        string
+	ClientCert    string
+	ClientKey     string
+	TLSServerName string
+}
+
+// vaultAddrFromEnv returns the address of the default Vault server
+func vaultAddrFromEnv() string {
+	if addr := os.Getenv("VAULT_ADDR"); addr != "" {
+		return addr
+	}
+	return "https://vault:8200"
 }
 
 func getVaultConfigFromEnv() *VaultConfig {
 	var vaultConfig VaultConfig
 
-	vaultConfig.Addr = os.Getenv("VAULT_ADDR")
-	if vaultConfig.Addr == "" {
-		vaultConfig.Addr = "https://vault:8200"
-	}
+	vaultConfig.Addr = vaultAddrFromEnv()
 
 	vaultConfig.AuthMethod = os.Getenv("VAULT_AUTH_METHOD")
 	if vaultConfig.AuthMethod == "" {
@@ -60,6 +88,9 @@ func getVaultConfigFromEnv() *VaultConfig {
 	vaultConfig.Path = os.Getenv("VAULT_PATH")
 	if vaultConfig.Path == "" {
 		vaultConfig.Path = "kubernetes"
+		if vaultConfig.AuthMethod == AppRoleAuthMethod {
+			vaultConfig.Path = "approle"
+		}
 	}
 
 	vaultConfig.Namespace = os.Getenv("VAULT_NAMESPACE")
@@ -76,6 +107,11 @@ func getVaultConfigFromEnv() *VaultConfig {
 		vaultConfig.TLSSecretNS = "default"
 	}
 
+	vaultConfig.CACert = os.Getenv("VAULT_CACERT")
+	vaultConfig.ClientCert = os.Getenv("VAULT_CLIENT_CERT")
+	vaultConfig.ClientKey = os.Getenv("VAULT_CLIENT_KEY")
+	vaultConfig.TLSServerName = os.Getenv("VAULT_TLS_SERVER_NAME")
+
 	vaultConfig.ClientTimeout, _ = time.ParseDuration(os.Getenv("VAULT_CLIENT_TIMEOUT"))
 	if vaultConfig.ClientTimeout == 0 {
 		vaultConfig.ClientTimeout = 10 * time.Second
@@ -83,6 +119,19 @@ func getVaultConfigFromEnv() *VaultConfig {
 
 	vaultConfig.IgnoreMissingSecrets, _ = strconv.ParseBool(os.Getenv("VAULT_IGNORE_MISSING_SECRETS"))
 
+	vaultConfig.AppRoleID = os.Getenv("VAULT_APPROLE_ROLE_ID")
+	vaultConfig.AppRoleSecretID = os.Getenv("VAULT_APPROLE_SECRET_ID")
+	vaultConfig.Token = os.Getenv("VAULT_TOKEN")
+	vaultConfig.ServiceAccountTokenPath = os.Getenv("VAULT_SA_TOKEN_PATH")
+
+	// Same rps[:burst] format as the Vault CLI, the burst defaults to the rate
+	rateLimit, rateLimitBurst, _ := strings.Cut(os.Getenv("VAULT_RATE_LIMIT"), ":")
+	vaultConfig.RateLimit, _ = strconv.ParseFloat(rateLimit, 64)
+	vaultConfig.RateLimitBurst, _ = strconv.Atoi(rateLimitBurst)
+	if vaultConfig.RateLimit > 0 && vaultConfig.RateLimitBurst == 0 {
+		vaultConfig.RateLimitBurst = max(int(vaultConfig.RateLimit), 1)
+	}
+
 	return &vaultConfig
 }
 
@@ -90,7 +139,11 @@ func (c *Controller) initVaultClient() error {
 	if c.vaultClient != nil {
 		_, err := c.vaultClient.Sys().Health()
 		if err == nil {
-			// Client is valid, no need to init
+			// Client is valid, only log in again if its token is about to expire
+			if c.vaultTokenExpiring() {
+				c.logger.Info("Vault token is about to expire, logging in again")
+				return c.loginToVault()
+			}
 			return nil
 		}
 		// log error and continue with (re)creating client
@@ -100,18 +153,101 @@ func (c *Controller) initVaultClient() error {
 	c.logger.Info("Initializing Vault client")
 
 	c.vaultConfig = getVaultConfigFromEnv()
+	authenticator, err := newVaultAuthenticator(c.vaultConfig)
+	if err != nil {
+		return err
+	}
+
+	vaultClient, renewAt, err := c.newVaultClient(c.vaultConfig.Addr, authenticator)
+	if err != nil {
+		return err
+	}
+
+	c.vaultClient = vaultClient
+	c.vaultAuthenticator = authenticator
+	c.vaultTokenRenewAt = renewAt
+	// The clients of the other Vault servers are created again with the new config
+	c.vaultClients = make(map[string]*pooledVaultClient)
+	c.vaultAuthenticated.Store(true)
+	c.logger.Info("Vault client initialized")
+	return nil
+}
+
+// newVaultClient creates a client of the Vault server at the given address, logged in with
+// the authenticator, or by the Vault SDK if it is nil. It returns when the token of the
+// client should be renewed, which is zero if it is not up to the reloader.
+func (c *Controller) newVaultClient(addr string, authenticator VaultAuthenticator) (*vaultapi.Client, time.Time, error) {
 	clientConfig := vaultapi.DefaultConfig()
 	if clientConfig.Error != nil {
-		return clientConfig.Error
+		return nil, time.Time{}, clientConfig.Error
 	}
 
-	clientConfig.Address = c.vaultConfig.Addr
+	clientConfig.Address = addr
 	clientConfig.Timeout = c.vaultConfig.ClientTimeout
+	clientConfig.Backoff = retryAfterBackoff
+	// The requests are limited by the config, not by the VAULT_RATE_LIMIT read by the Vault SDK
+	clientConfig.Limiter = nil
+	if c.vaultConfig.RateLimit > 0 {
+		clientConfig.Limiter = rate.NewLimiter(rate.Limit(c.vaultConfig.RateLimit), max(c.vaultConfig.RateLimitBurst, 1))
+	}
+
+	err := c.configureVaultTLS(clientConfig)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var vaultClient *vaultapi.Client
+	if authenticator != nil {
+		vaultClient, err = vaultapi.NewClient(clientConfig)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		vaultClient.SetNamespace(c.vaultConfig.Namespace)
+	} else {
+		sdkClient, err := vault.NewClientFromConfig(
+			clientConfig,
+			vault.ClientRole(c.vaultConfig.Role),
+			vault.ClientAuthPath(c.vaultConfig.Path),
+			vault.ClientAuthMethod(c.vaultConfig.AuthMethod),
+			vault.ClientLogger(&clientLogger{logger: c.logger}),
+			vault.VaultNamespace(c.vaultConfig.Namespace),
+		)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		vaultClient = sdkClient.RawClient()
+	}
+	//
+	// Check connection to Vault
+	_, err = vaultClient.Sys().Health()
+	if err != nil {
+		c.logger.Error("testing connection to Vault failed")
+		return nil, time.Time{}, err
+	}
 
-	tlsConfig := vaultapi.TLSConfig{Insecure: c.vaultConfig.SkipVerify}
+	if authenticator == nil {
+		return vaultClient, time.Time{}, nil
+	}
+	renewAt, err := loginWithAuthenticator(authenticator, vaultClient)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return vaultClient, renewAt, nil
+}
+
+// configureVaultTLS sets up the TLS config of the Vault client transport, the CA of
+// the TLS Secret takes precedence over the CA bundle file
+func (c *Controller) configureVaultTLS(clientConfig *vaultapi.Config) error {
+	tlsConfig := vaultapi.TLSConfig{
+		CACert:        c.vaultConfig.CACert,
+		ClientCert:    c.vaultConfig.ClientCert,
+		ClientKey:     c.vaultConfig.ClientKey,
+		TLSServerName: c.vaultConfig.TLSServerName,
+		Insecure:      c.vaultConfig.SkipVerify,
+	}
 	err := clientConfig.ConfigureTLS(&tlsConfig)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to configure Vault TLS: %w", err)
 	}
 
 	if c.vaultConfig.TLSSecret != "" {
@@ -136,28 +272,89 @@ func (c *Controller) initVaultClient() error {
 		clientTLSConfig.RootCAs = pool
 	}
 
-	vaultClient, err := vault.NewClientFromConfig(
-		clientConfig,
-		vault.ClientRole(c.vaultConfig.Role),
-		vault.ClientAuthPath(c.vaultConfig.Path),
-		vault.ClientAuthMethod(c.vaultConfig.AuthMethod),
-		vault.ClientLogger(&clientLogger{logger: c.logger}),
-		vault.VaultNamespace(c.vaultConfig.Namespace),
-	)
-	if err != nil {
-		return err
+	return nil
+}
+
+// retryAfterBackoff waits as long as the Retry-After header of the 429 responses of rate
+// limited Vault servers asks to before retrying, and backs off linearly otherwise
+func retryAfterBackoff(minWait, maxWait time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter := resp.Header.Get("Retry-After")
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(retryAfter); err == nil {
+			return max(time.Until(date), 0)
+		}
 	}
-	//
-	// Check connection to Vault
-	_, err = vaultClient.RawClient().Sys().Health()
+	return retryablehttp.LinearJitterBackoff(minWait, maxWait, attemptNum, resp)
+}
+
+// pooledVaultClient is the client of a Vault server set by VaultAddrAnnotation
+type pooledVaultClient struct {
+	client  *vaultapi.Client
+	renewAt time.Time
+}
+
+// vaultClientForAddr returns the client of the Vault server at the given address, created
+// with the settings of the default client on first use, or the default client if it is empty
+func (c *Controller) vaultClientForAddr(addr string) (*vaultapi.Client, error) {
+	if addr == "" || addr == c.vaultConfig.Addr {
+		return c.vaultClient, nil
+	}
+	// Secret paths restored from the store were collected with another configuration
+	if !c.vaultAddrAllowed(addr) {
+		return nil, fmt.Errorf("Vault address %s is not allowed", addr)
+	}
+
+	if pooled, ok := c.vaultClients[addr]; ok {
+		if c.vaultAuthenticator == nil || !tokenRenewalDue(pooled.renewAt) {
+			return pooled.client, nil
+		}
+		renewAt, err := loginWithAuthenticator(c.vaultAuthenticator, pooled.client)
+		if err != nil {
+			delete(c.vaultClients, addr)
+			return nil, err
+		}
+		pooled.renewAt = renewAt
+		return pooled.client, nil
+	}
+
+	c.logger.Info(fmt.Sprintf("Initializing Vault client of %s", addr))
+	vaultClient, renewAt, err := c.newVaultClient(addr, c.vaultAuthenticator)
 	if err != nil {
-		c.logger.Error("testing connection to Vault failed")
-		return err
+		return nil, err
 	}
+	c.vaultClients[addr] = &pooledVaultClient{client: vaultClient, renewAt: renewAt}
+	return vaultClient, nil
+}
 
-	c.vaultClient = vaultClient.RawClient()
-	c.logger.Info("Vault client initialized")
-	return nil
+// vaultAddrAllowed tells whether the reloader may log in to a Vault server set by
+// VaultAddrAnnotation, which is the default one or one of AllowedVaultAddrs, as it
+// hands its own credentials to the server
+func (c *Controller) vaultAddrAllowed(addr string) bool {
+	normalize := func(addr string) string {
+		return strings.TrimRight(addr, "/")
+	}
+	addr = normalize(addr)
+	if addr == normalize(vaultAddrFromEnv()) {
+		return true
+	}
+	return slices.ContainsFunc(c.collectorConfig.AllowedVaultAddrs, func(allowed string) bool {
+		return allowed != "" && normalize(allowed) == addr
+	})
+}
+
+// secretClient returns the VaultClient of a tracked secret path, using the client of its Vault
+// server and namespace, along with the path of the secret in the namespace
+func (c *Controller) secretClient(secretPath string) (VaultClient, string, error) {
+	vaultAddr, namespacedPath := splitVaultAddrSecretPath(secretPath)
+	vaultNamespace, path := splitNamespacedSecretPath(namespacedPath)
+	vaultClient, err := c.vaultClientForAddr(vaultAddr)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to initialize Vault client of %s: %w", vaultAddr, err)
+	}
+	return newSDKVaultClient(vaultClient, vaultNamespace), path, nil
 }
 
 type ErrSecretNotFound struct {
@@ -168,22 +365,189 @@ func (e ErrSecretNotFound) Error() string {
 	return fmt.Sprintf("Vault secret path %s not found", e.secretPath)
 }
 
+// ErrNotKVMount is returned for secret paths mounted on another secrets engine than KV,
+// like database/creds/readonly, whose secrets are dynamic and so have no version
+type ErrNotKVMount struct {
+	secretPath string
+	mountType  string
+}
+
+func (e ErrNotKVMount) Error() string {
+	return fmt.Sprintf("Vault secret path %s is mounted on a %s secrets engine, not KV", e.secretPath, e.mountType)
+}
+
 type vaultSecretReader interface {
 	Read(path string) (*vaultapi.Secret, error)
+	List(path string) (*vaultapi.Secret, error)
 }
 
-func getSecretVersionFromVault(vaultClient vaultSecretReader, secretPath string) (int, error) {
-	secret, err := vaultClient.Read(secretPath)
+// secretReaderForNamespace returns a reader sending the X-Vault-Namespace header of the
+// given Vault Enterprise namespace, or using the namespace of the client if it is empty
+func secretReaderForNamespace(vaultClient *vaultapi.Client, vaultNamespace string) *vaultapi.Logical {
+	if vaultNamespace == "" {
+		return vaultClient.Logical()
+	}
+	return vaultClient.WithNamespace(vaultNamespace).Logical()
+}
+
+// getSecretVersionFromVault returns the current version of a KV version 2 secret from its
+// metadata, which doesn't require being allowed to read the data of the secret
+func getSecretVersionFromVault(vaultClient vaultSecretReader, secretPath string, mountPath string) (int, error) {
+	version, _, err := getSecretMetadataFromVault(vaultClient, secretPath, mountPath)
+	return version, err
+}
+
+// getSecretMetadataFromVault returns the current version and the custom metadata of a KV version 2 secret
+func getSecretMetadataFromVault(vaultClient vaultSecretReader, secretPath string, mountPath string) (int, map[string]string, error) {
+	secret, err := vaultClient.Read(metadataPath(mountPath, secretPath))
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 	if secret != nil {
-		secretVersion, err := secret.Data["metadata"].(map[string]interface{})["version"].(json.Number).Int64()
+		version, ok := secret.Data["current_version"].(json.Number)
+		if !ok {
+			return 0, nil, fmt.Errorf("Vault secret path %s has no version metadata", secretPath)
+		}
+		secretVersion, err := version.Int64()
 		if err != nil {
-			return 0, err
+			return 0, nil, err
+		}
+		customMetadata := make(map[string]string)
+		if values, ok := secret.Data["custom_metadata"].(map[string]interface{}); ok {
+			for key, value := range values {
+				if value, ok := value.(string); ok {
+					customMetadata[key] = value
+				}
+			}
+		}
+		return int(secretVersion), customMetadata, nil
+	}
+
+	return 0, nil, ErrSecretNotFound{secretPath: secretPath}
+}
+
+// metadataPath returns the metadata path of a KV version 2 secret path by replacing the
+// data segment following its mount path, as detected from Vault, with metadata, so that
+// any mount is supported, including nested ones like team/data/kv and ones named data.
+// Without a detected mount the first data segment is replaced, which is the one following
+// the mount unless the mount itself has a data segment.
+func metadataPath(mountPath string, secretPath string) string {
+	if mountPath != "" {
+		if name, ok := strings.CutPrefix(secretPath, mountPath+"/data/"); ok {
+			return mountPath + "/metadata/" + name
+		}
+	}
+	return strings.Replace(secretPath, "/data/", "/metadata/", 1)
+}
+
+// getSecretHashFromVault returns the hash of the contents of a secret, only hashing the
+// data of KV version 2 secrets so that the hash doesn't change with their metadata
+func getSecretHashFromVault(vaultClient vaultSecretReader, secretPath string, kvVersion int) (string, error) {
+	secret, err := vaultClient.Read(secretPath)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", ErrSecretNotFound{secretPath: secretPath}
+	}
+
+	contents := secret.Data
+	if kvVersion == 2 {
+		contents, _ = secret.Data["data"].(map[string]interface{})
+	}
+
+	// Map keys are sorted when marshaled, so the hash only changes with the contents
+	data, err := json.Marshal(contents)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// listSecretsFromVault returns the sorted paths of all secrets below a path prefix,
+// listing the metadata path of the prefix in case of KV version 2 mounts
+func listSecretsFromVault(vaultClient vaultSecretReader, prefix string, mount KVMount) ([]string, error) {
+	listPath := prefix + "/"
+	if mount.Version == 2 {
+		listPath = metadataPath(mount.Path, listPath)
+	}
+
+	secret, err := vaultClient.List(listPath)
+	if err != nil {
+		return nil, err
+	}
+	// Nothing below the prefix
+	if secret == nil {
+		return []string{}, nil
+	}
+
+	keys, _ := secret.Data["keys"].([]interface{})
+	secretPaths := []string{}
+	for _, key := range keys {
+		name, ok := key.(string)
+		if !ok || name == "" || name == "/" {
+			continue
 		}
-		return int(secretVersion), nil
+
+		// Keys ending with a slash are folders
+		if strings.HasSuffix(name, "/") {
+			childPaths, err := listSecretsFromVault(vaultClient, prefix+"/"+strings.TrimSuffix(name, "/"), mount)
+			if err != nil {
+				return nil, err
+			}
+			secretPaths = append(secretPaths, childPaths...)
+			continue
+		}
+		secretPaths = append(secretPaths, prefix+"/"+name)
+	}
+
+	slices.Sort(secretPaths)
+	return secretPaths, nil
+}
+
+// KVMount is the KV secrets engine a secret path is mounted on
+type KVMount struct {
+	// Path is the path of the mount without its trailing slash, e.g. team/kv,
+	// it is empty if the mount could not be detected
+	Path string
+	// Version is the version of the KV secrets engine
+	Version int
+}
+
+// mountPathOf returns the path of the mount of a secret path, or the first segment
+// of the secret path if the mount could not be detected
+func (m KVMount) mountPathOf(secretPath string) string {
+	if m.Path != "" {
+		return m.Path
+	}
+	mountPath, _, _ := strings.Cut(secretPath, "/")
+	return mountPath
+}
+
+// getKVMountFromVault returns the path and the version of the KV secrets engine the secret
+// path is mounted on, the same way the Vault CLI detects them, or ErrNotKVMount for other engines
+func getKVMountFromVault(vaultClient vaultSecretReader, secretPath string) (KVMount, error) {
+	mount, err := vaultClient.Read("sys/internal/ui/mounts/" + secretPath)
+	if err != nil {
+		return KVMount{}, err
+	}
+	if mount == nil {
+		return KVMount{}, fmt.Errorf("no mount found for Vault secret path %s", secretPath)
 	}
 
-	return 0, ErrSecretNotFound{secretPath: secretPath}
+	// generic is the former name of the KV version 1 secrets engine
+	if mountType, _ := mount.Data["type"].(string); mountType != "" && mountType != "kv" && mountType != "generic" {
+		return KVMount{}, ErrNotKVMount{secretPath: secretPath, mountType: mountType}
+	}
+
+	mountPath, _ := mount.Data["path"].(string)
+	kvMount := KVMount{Path: strings.TrimSuffix(mountPath, "/"), Version: 1}
+	options, _ := mount.Data["options"].(map[string]interface{})
+	if version, _ := options["version"].(string); version != "" {
+		if kvMount.Version, err = strconv.Atoi(version); err != nil {
+			return KVMount{}, err
+		}
+	}
+	return kvMount, nil
 }
@@ -15,13 +15,31 @@
 package reloader
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	vaultapi "github.com/hashicorp/vault/api"
 	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestGetVaultConfigFromEnv(t *testing.T) {
@@ -55,6 +73,9 @@ func TestGetVaultConfigFromEnv(t *testing.T) {
 		os.Setenv("VAULT_TLS_SECRET_NS", "test")
 		os.Setenv("VAULT_CLIENT_TIMEOUT", "1m")
 		os.Setenv("VAULT_IGNORE_MISSING_SECRETS", "true")
+		// Not leaked, it would limit the clients of the other tests
+		t.Setenv("VAULT_RATE_LIMIT", "2.5:10")
+		t.Setenv("VAULT_TLS_SERVER_NAME", "vault.test")
 
 		defaults := VaultConfig{
 			Addr:                 "http://127.0.0.1:8200",
@@ -65,13 +86,24 @@ func TestGetVaultConfigFromEnv(t *testing.T) {
 			SkipVerify:           true,
 			TLSSecret:            "test",
 			TLSSecretNS:          "test",
+			TLSServerName:        "vault.test",
 			ClientTimeout:        1 * time.Minute,
 			IgnoreMissingSecrets: true,
+			RateLimit:            2.5,
+			RateLimitBurst:       10,
 		}
 
 		vaultConfig := getVaultConfigFromEnv()
 		assert.Equal(t, defaults, *vaultConfig)
 	})
+
+	t.Run("rate limit without burst", func(t *testing.T) {
+		t.Setenv("VAULT_RATE_LIMIT", "50")
+
+		vaultConfig := getVaultConfigFromEnv()
+		assert.Equal(t, 50.0, vaultConfig.RateLimit)
+		assert.Equal(t, 50, vaultConfig.RateLimitBurst)
+	})
 }
 
 type vaultClientMock struct {
@@ -84,13 +116,18 @@ func (c *vaultClientMock) Read(path string) (*vaultapi.Secret, error) {
 	return c.vaultSecret, c.err
 }
 
+func (c *vaultClientMock) List(path string) (*vaultapi.Secret, error) {
+	_ = path
+	return c.vaultSecret, c.err
+}
+
 func TestGetSecretVersionFromVault(t *testing.T) {
 	t.Run("secret not found", func(t *testing.T) {
 		vaultClient := &vaultClientMock{
 			err: ErrSecretNotFound{},
 		}
 
-		_, err := getSecretVersionFromVault(vaultClient, "test")
+		_, err := getSecretVersionFromVault(vaultClient, "test", "")
 		assert.Equal(t, ErrSecretNotFound{}, err)
 	})
 
@@ -99,7 +136,7 @@ func TestGetSecretVersionFromVault(t *testing.T) {
 			err: assert.AnError,
 		}
 
-		_, err := getSecretVersionFromVault(vaultClient, "test")
+		_, err := getSecretVersionFromVault(vaultClient, "test", "")
 		assert.Equal(t, assert.AnError, err)
 	})
 
@@ -107,15 +144,523 @@ func TestGetSecretVersionFromVault(t *testing.T) {
 		vaultClient := &vaultClientMock{
 			vaultSecret: &vaultapi.Secret{
 				Data: map[string]interface{}{
-					"metadata": map[string]interface{}{
-						"version": json.Number("3"),
-					},
+					"current_version": json.Number("3"),
 				},
 			},
 		}
 
-		version, err := getSecretVersionFromVault(vaultClient, "test")
+		version, err := getSecretVersionFromVault(vaultClient, "test", "")
 		assert.NoError(t, err)
 		assert.Equal(t, 3, version)
 	})
 }
+
+func TestMetadataPath(t *testing.T) {
+	tests := []struct {
+		mountPath  string
+		secretPath string
+		want       string
+	}{
+		{mountPath: "secret", secretPath: "secret/data/app", want: "secret/metadata/app"},
+		{mountPath: "kv", secretPath: "kv/data/team/app/db", want: "kv/metadata/team/app/db"},
+		{mountPath: "team/kv", secretPath: "team/kv/data/app", want: "team/kv/metadata/app"},
+		{mountPath: "data", secretPath: "data/data/app", want: "data/metadata/app"},
+		{mountPath: "secret", secretPath: "secret/data/data/app", want: "secret/metadata/data/app"},
+		{mountPath: "secret", secretPath: "secret/data/app/data/key", want: "secret/metadata/app/data/key"},
+		// Mounts nested below a data segment need the detected mount path
+		{mountPath: "team/data/kv", secretPath: "team/data/kv/data/app", want: "team/data/kv/metadata/app"},
+		{mountPath: "team/data/kv", secretPath: "team/data/kv/data/", want: "team/data/kv/metadata/"},
+		// Without a detected mount, the first data segment is replaced
+		{secretPath: "secret/data/app", want: "secret/metadata/app"},
+		{secretPath: "team/kv/data/app", want: "team/kv/metadata/app"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, metadataPath(tt.mountPath, tt.secretPath), tt.secretPath)
+	}
+}
+
+func TestNestedKVMount(t *testing.T) {
+	// A KV version 2 engine mounted below a data segment, e.g. by a team owning the data/ prefix
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var response interface{}
+		switch strings.TrimSuffix(r.URL.Path, "/") {
+		case "/v1/sys/internal/ui/mounts/team/data/kv/data/app":
+			response = map[string]interface{}{"data": map[string]interface{}{
+				"path": "team/data/kv/", "type": "kv", "options": map[string]interface{}{"version": "2"},
+			}}
+		case "/v1/team/data/kv/metadata/app":
+			response = map[string]interface{}{"data": map[string]interface{}{"current_version": 4}}
+		case "/v1/team/data/kv/metadata":
+			response = map[string]interface{}{"data": map[string]interface{}{"keys": []string{"app"}}}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	config := vaultapi.DefaultConfig()
+	config.Address = server.URL
+	client, err := vaultapi.NewClient(config)
+	assert.NoError(t, err)
+	vaultClient := newSDKVaultClient(client, "")
+	ctx := context.Background()
+
+	mount, err := vaultClient.KVMount(ctx, "team/data/kv/data/app")
+	assert.NoError(t, err)
+	assert.Equal(t, KVMount{Path: "team/data/kv", Version: 2}, mount)
+
+	version, err := vaultClient.SecretVersion(ctx, "team/data/kv/data/app", mount.Path)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, version)
+
+	secretPaths, err := vaultClient.ListSecrets(ctx, "team/data/kv/data", mount)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"team/data/kv/data/app"}, secretPaths)
+}
+
+func TestGetSecretVersionFromVaultNamespace(t *testing.T) {
+	// Every Vault namespace holds a different version of the same path
+	versions := map[string]int{"": 1, "team-a": 2, "team-b": 3}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, ok := versions[r.Header.Get(vaultapi.NamespaceHeaderName)]
+		if !ok || r.URL.Path != "/v1/secret/metadata/app" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"current_version": version},
+		})
+	}))
+	defer server.Close()
+
+	config := vaultapi.DefaultConfig()
+	config.Address = server.URL
+	vaultClient, err := vaultapi.NewClient(config)
+	assert.NoError(t, err)
+	vaultClient.ClearNamespace()
+
+	for vaultNamespace, expected := range versions {
+		version, err := getSecretVersionFromVault(secretReaderForNamespace(vaultClient, vaultNamespace), "secret/data/app", "secret")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, version, "namespace %q", vaultNamespace)
+	}
+
+	_, err = getSecretVersionFromVault(secretReaderForNamespace(vaultClient, "team-c"), "secret/data/app", "secret")
+	assert.Equal(t, ErrSecretNotFound{secretPath: "secret/data/app"}, err)
+}
+
+// testVault is a fake Vault server with a KV version 2 engine mounted on secret/,
+// a KV version 1 engine mounted on kv/ and a database engine mounted on database/
+type testVault struct {
+	sync.Mutex
+	server *httptest.Server
+	// versions holds the versions of the secrets in secret/data/
+	versions map[string]int
+	// mountVersions holds the versions of the secrets of the other KV version 2 engines by mount
+	mountVersions map[string]map[string]int
+	// contents holds the data of the secrets in kv/, and of the ones in secret/data/ if set
+	contents map[string]map[string]interface{}
+	// customMetadata holds the custom metadata of the secrets in secret/data/
+	customMetadata map[string]map[string]string
+	// logins holds the bodies of the auth login requests
+	logins []map[string]interface{}
+	// tokenTTL is the lease duration of the tokens issued on login, in seconds
+	tokenTTL int
+	// throttled is the number of next requests rejected with a 429 response asking
+	// to retry after retryAfter seconds
+	throttled  int
+	retryAfter int
+	// requests holds the time of every request
+	requests []time.Time
+	// failing holds the names of the secrets in secret/data/ whose reads fail
+	failing []string
+	// forbidden holds the names of the secrets in secret/data/ whose reads are denied
+	forbidden []string
+	// failingOnce holds the names of the secrets in secret/data/ whose next read fails
+	failingOnce []string
+}
+
+func newTestVault(t *testing.T) *testVault {
+	vault := &testVault{
+		versions:       make(map[string]int),
+		mountVersions:  make(map[string]map[string]int),
+		contents:       make(map[string]map[string]interface{}),
+		customMetadata: make(map[string]map[string]string),
+	}
+	vault.server = httptest.NewServer(http.HandlerFunc(vault.serveHTTP))
+	t.Cleanup(vault.server.Close)
+	return vault
+}
+
+func (v *testVault) client(t *testing.T) *vaultapi.Client {
+	config := vaultapi.DefaultConfig()
+	config.Address = v.server.URL
+	vaultClient, err := vaultapi.NewClient(config)
+	assert.NoError(t, err)
+	return vaultClient
+}
+
+func (v *testVault) setVersion(name string, version int) {
+	v.Lock()
+	defer v.Unlock()
+	v.versions[name] = version
+}
+
+// setMountVersion sets the version of a secret of a KV version 2 engine mounted on another mount than secret/
+func (v *testVault) setMountVersion(mount string, name string, version int) {
+	v.Lock()
+	defer v.Unlock()
+	if v.mountVersions[mount] == nil {
+		v.mountVersions[mount] = make(map[string]int)
+	}
+	v.mountVersions[mount][name] = version
+}
+
+// cutMount splits a path into the mount it is on, which may be a nested mount set
+// with setMountVersion, and the path below the mount
+func (v *testVault) cutMount(path string) (string, string) {
+	for mount := range v.mountVersions {
+		if name, ok := strings.CutPrefix(path, mount+"/"); ok && strings.Contains(mount, "/") {
+			return mount, name
+		}
+	}
+	mount, name, _ := strings.Cut(path, "/")
+	return mount, name
+}
+
+// kvV2Versions returns the versions of the secrets of the KV version 2 engine mounted on a mount
+func (v *testVault) kvV2Versions(mount string) (map[string]int, bool) {
+	if mount == "secret" {
+		return v.versions, true
+	}
+	versions, ok := v.mountVersions[mount]
+	return versions, ok
+}
+
+// setCustomMetadata sets the custom metadata of a secret in secret/data/ without changing its version
+func (v *testVault) setCustomMetadata(name string, customMetadata map[string]string) {
+	v.Lock()
+	defer v.Unlock()
+	v.customMetadata[name] = customMetadata
+}
+
+func (v *testVault) setContents(name string, data map[string]interface{}) {
+	v.Lock()
+	defer v.Unlock()
+	v.contents[name] = data
+}
+
+func (v *testVault) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	v.Lock()
+	defer v.Unlock()
+
+	v.requests = append(v.requests, time.Now())
+	if v.throttled > 0 {
+		v.throttled--
+		w.Header().Set("Retry-After", strconv.Itoa(v.retryAfter))
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	mount, mountPath := v.cutMount(path)
+	kvV2Versions, kvV2Mount := v.kvV2Versions(mount)
+	var response interface{}
+	switch {
+	case r.URL.Query().Get("list") == "true":
+		if keys := v.list(path); len(keys) > 0 {
+			response = map[string]interface{}{"data": map[string]interface{}{"keys": keys}}
+		}
+	case strings.HasPrefix(path, "auth/") && strings.HasSuffix(path, "/login"):
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		body["path"] = path
+		v.logins = append(v.logins, body)
+		response = map[string]interface{}{"auth": map[string]interface{}{
+			"client_token": fmt.Sprintf("token-%d", len(v.logins)), "lease_duration": v.tokenTTL, "renewable": true,
+		}}
+	case path == "sys/health":
+		response = map[string]interface{}{"initialized": true, "sealed": false}
+	case strings.HasPrefix(path, "sys/internal/ui/mounts/"):
+		uiMount, _ := v.cutMount(strings.TrimPrefix(path, "sys/internal/ui/mounts/"))
+		if _, ok := v.kvV2Versions(uiMount); ok {
+			response = map[string]interface{}{"data": map[string]interface{}{
+				"path": uiMount + "/", "type": "kv", "options": map[string]interface{}{"version": "2"},
+			}}
+			break
+		}
+		switch uiMount {
+		case "kv":
+			response = map[string]interface{}{"data": map[string]interface{}{"path": "kv/", "type": "kv"}}
+		case "database":
+			response = map[string]interface{}{"data": map[string]interface{}{"path": "database/", "type": "database"}}
+		}
+	case kvV2Mount && strings.HasPrefix(mountPath, "metadata/"):
+		name := strings.TrimPrefix(mountPath, "metadata/")
+		if i := slices.Index(v.failingOnce, name); i >= 0 {
+			v.failingOnce = slices.Delete(v.failingOnce, i, i+1)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if slices.Contains(v.failing, name) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if slices.Contains(v.forbidden, name) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if version, ok := kvV2Versions[name]; ok {
+			data := map[string]interface{}{"current_version": version}
+			if customMetadata, ok := v.customMetadata[name]; ok && mount == "secret" {
+				data["custom_metadata"] = customMetadata
+			}
+			response = map[string]interface{}{"data": data}
+		}
+	case kvV2Mount && strings.HasPrefix(mountPath, "data/"):
+		name := strings.TrimPrefix(mountPath, "data/")
+		if slices.Contains(v.failing, name) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if slices.Contains(v.forbidden, name) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if version, ok := kvV2Versions[name]; ok {
+			data := v.contents[name]
+			if data == nil {
+				data = map[string]interface{}{}
+			}
+			response = map[string]interface{}{"data": map[string]interface{}{
+				"data": data, "metadata": map[string]interface{}{"version": version},
+			}}
+		}
+	case strings.HasPrefix(path, "kv/"):
+		if data, ok := v.contents[strings.TrimPrefix(path, "kv/")]; ok {
+			response = map[string]interface{}{"data": data}
+		}
+	}
+
+	if response == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// list returns the keys below a secret/metadata/ or kv/ folder
+func (v *testVault) list(path string) []string {
+	var prefix string
+	var names []string
+	switch {
+	case strings.HasPrefix(path, "secret/metadata/"):
+		prefix = strings.TrimPrefix(path, "secret/metadata/")
+		for name := range v.versions {
+			names = append(names, name)
+		}
+	case strings.HasPrefix(path, "kv/"):
+		prefix = strings.TrimPrefix(path, "kv/")
+		for name := range v.contents {
+			names = append(names, name)
+		}
+	}
+
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	keys := []string{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		key := strings.TrimPrefix(name, prefix)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func TestListSecretsFromVault(t *testing.T) {
+	vault := newTestVault(t)
+	vault.setVersion("team/app", 1)
+	vault.setVersion("team/db/postgres", 1)
+	vault.setVersion("team/db/redis", 1)
+	vault.setVersion("other/app", 1)
+	vault.setContents("team/app", map[string]interface{}{"password": "s3cr3t"})
+	vaultClient := vault.client(t)
+
+	secretPaths, err := listSecretsFromVault(vaultClient.Logical(), "secret/data/team", KVMount{Path: "secret", Version: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"secret/data/team/app", "secret/data/team/db/postgres", "secret/data/team/db/redis"}, secretPaths)
+
+	secretPaths, err = listSecretsFromVault(vaultClient.Logical(), "kv/team", KVMount{Path: "kv", Version: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kv/team/app"}, secretPaths)
+
+	secretPaths, err = listSecretsFromVault(vaultClient.Logical(), "secret/data/empty", KVMount{Path: "secret", Version: 2})
+	assert.NoError(t, err)
+	assert.Empty(t, secretPaths)
+}
+
+func TestVaultRateLimit(t *testing.T) {
+	t.Run("requests are paced", func(t *testing.T) {
+		vault := newTestVault(t)
+		vault.setVersion("app", 1)
+
+		controller := newTestController(fake.NewSimpleClientset())
+		controller.vaultConfig = &VaultConfig{RateLimit: 20, RateLimitBurst: 1}
+		vaultClient, _, err := controller.newVaultClient(vault.server.URL, &tokenAuthenticator{token: "test"})
+		assert.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			_, err := getSecretVersionFromVault(vaultClient.Logical(), "secret/data/app", "secret")
+			assert.NoError(t, err)
+		}
+
+		// The health check and the 5 reads are 50ms apart
+		vault.Lock()
+		defer vault.Unlock()
+		assert.Len(t, vault.requests, 6)
+		assert.GreaterOrEqual(t, vault.requests[5].Sub(vault.requests[0]), 240*time.Millisecond)
+	})
+
+	t.Run("Retry-After is respected", func(t *testing.T) {
+		vault := newTestVault(t)
+		vault.setVersion("app", 1)
+
+		controller := newTestController(fake.NewSimpleClientset())
+		controller.vaultConfig = &VaultConfig{}
+		vaultClient, _, err := controller.newVaultClient(vault.server.URL, &tokenAuthenticator{token: "test"})
+		assert.NoError(t, err)
+
+		// Longer than the 1.5s the linear backoff waits at most before the first retry
+		vault.Lock()
+		vault.throttled = 1
+		vault.retryAfter = 2
+		vault.requests = nil
+		vault.Unlock()
+
+		version, err := getSecretVersionFromVault(vaultClient.Logical(), "secret/data/app", "secret")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, version)
+
+		vault.Lock()
+		defer vault.Unlock()
+		assert.Len(t, vault.requests, 2)
+		assert.GreaterOrEqual(t, vault.requests[1].Sub(vault.requests[0]), 2*time.Second)
+	})
+}
+
+func TestGetKVMountFromVault(t *testing.T) {
+	vaultClient := newTestVault(t).client(t)
+
+	mount, err := getKVMountFromVault(vaultClient.Logical(), "secret/data/app")
+	assert.NoError(t, err)
+	assert.Equal(t, KVMount{Path: "secret", Version: 2}, mount)
+
+	mount, err = getKVMountFromVault(vaultClient.Logical(), "kv/app")
+	assert.NoError(t, err)
+	assert.Equal(t, KVMount{Path: "kv", Version: 1}, mount)
+
+	_, err = getKVMountFromVault(vaultClient.Logical(), "database/creds/app")
+	assert.Equal(t, ErrNotKVMount{secretPath: "database/creds/app", mountType: "database"}, err)
+
+	_, err = getKVMountFromVault(vaultClient.Logical(), "missing/app")
+	assert.Error(t, err)
+}
+
+func TestGetSecretHashFromVault(t *testing.T) {
+	vault := newTestVault(t)
+	vaultClient := vault.client(t)
+
+	vault.setContents("app", map[string]interface{}{"username": "app", "password": "s3cr3t"})
+	hash, err := getSecretHashFromVault(vaultClient.Logical(), "kv/app", 1)
+	assert.NoError(t, err)
+
+	vault.setContents("app", map[string]interface{}{"password": "s3cr3t", "username": "app"})
+	sameHash, err := getSecretHashFromVault(vaultClient.Logical(), "kv/app", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, hash, sameHash)
+
+	vault.setContents("app", map[string]interface{}{"username": "app", "password": "n3w"})
+	newHash, err := getSecretHashFromVault(vaultClient.Logical(), "kv/app", 1)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, newHash)
+
+	_, err = getSecretHashFromVault(vaultClient.Logical(), "kv/missing", 1)
+	assert.Equal(t, ErrSecretNotFound{secretPath: "kv/missing"}, err)
+	// Only the data of KV version 2 secrets is hashed, not their metadata
+	vault.setVersion("app", 1)
+	v2Hash, err := getSecretHashFromVault(vaultClient.Logical(), "secret/data/app", 2)
+	assert.NoError(t, err)
+	vault.setVersion("app", 2)
+	sameV2Hash, err := getSecretHashFromVault(vaultClient.Logical(), "secret/data/app", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, v2Hash, sameV2Hash)
+	assert.Equal(t, newHash, v2Hash)
+}
+
+func TestConfigureVaultTLS(t *testing.T) {
+	// The default config of the Vault SDK reads it as well
+	t.Setenv("VAULT_SKIP_VERIFY", "")
+	transportTLSConfig := func(t *testing.T, vaultConfig *VaultConfig) *tls.Config {
+		controller := newTestController(nil)
+		controller.vaultConfig = vaultConfig
+		clientConfig := vaultapi.DefaultConfig()
+		assert.NoError(t, controller.configureVaultTLS(clientConfig))
+		return clientConfig.HttpClient.Transport.(*http.Transport).TLSClientConfig
+	}
+
+	t.Run("skip verify", func(t *testing.T) {
+		assert.True(t, transportTLSConfig(t, &VaultConfig{SkipVerify: true}).InsecureSkipVerify)
+		assert.False(t, transportTLSConfig(t, &VaultConfig{SkipVerify: false}).InsecureSkipVerify)
+	})
+
+	t.Run("server name", func(t *testing.T) {
+		assert.Equal(t, "vault.test", transportTLSConfig(t, &VaultConfig{TLSServerName: "vault.test"}).ServerName)
+	})
+
+	t.Run("CA and client certificates", func(t *testing.T) {
+		certFile, keyFile := writeTestCertificate(t)
+
+		tlsConfig := transportTLSConfig(t, &VaultConfig{CACert: certFile, ClientCert: certFile, ClientKey: keyFile})
+		assert.NotNil(t, tlsConfig.RootCAs)
+		assert.NotNil(t, tlsConfig.GetClientCertificate)
+	})
+
+	t.Run("invalid CA", func(t *testing.T) {
+		controller := newTestController(nil)
+		controller.vaultConfig = &VaultConfig{CACert: filepath.Join(t.TempDir(), "missing.crt")}
+		assert.Error(t, controller.configureVaultTLS(vaultapi.DefaultConfig()))
+	})
+}
+
+// writeTestCertificate writes a self-signed certificate and its key to PEM files
+func writeTestCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "vault.test"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0o600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
@@ -0,0 +1,161 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+const (
+	KubernetesAuthMethod = "kubernetes"
+	AppRoleAuthMethod    = "approle"
+	TokenAuthMethod      = "token"
+
+	defaultServiceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// VaultAuthenticator logs in to Vault, returning the token issued along with its lease
+type VaultAuthenticator interface {
+	Login(vaultClient *vaultapi.Client) (*vaultapi.SecretAuth, error)
+}
+
+// newVaultAuthenticator returns the authenticator of the configured auth method, or nil
+// if the auth method is left to the Vault SDK
+func newVaultAuthenticator(vaultConfig *VaultConfig) (VaultAuthenticator, error) {
+	switch vaultConfig.AuthMethod {
+	case KubernetesAuthMethod:
+		return &kubernetesAuthenticator{
+			role:      vaultConfig.Role,
+			mountPath: vaultConfig.Path,
+			tokenPath: vaultConfig.ServiceAccountTokenPath,
+		}, nil
+	case AppRoleAuthMethod:
+		if vaultConfig.AppRoleID == "" {
+			return nil, fmt.Errorf("AppRole auth method requires a role ID")
+		}
+		return &appRoleAuthenticator{
+			roleID:    vaultConfig.AppRoleID,
+			secretID:  vaultConfig.AppRoleSecretID,
+			mountPath: vaultConfig.Path,
+		}, nil
+	case TokenAuthMethod:
+		if vaultConfig.Token == "" {
+			return nil, fmt.Errorf("token auth method requires a token")
+		}
+		return &tokenAuthenticator{token: vaultConfig.Token}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// kubernetesAuthenticator logs in with the service account token of the pod
+type kubernetesAuthenticator struct {
+	role      string
+	mountPath string
+	tokenPath string
+}
+
+func (a *kubernetesAuthenticator) Login(vaultClient *vaultapi.Client) (*vaultapi.SecretAuth, error) {
+	tokenPath := a.tokenPath
+	if tokenPath == "" {
+		tokenPath = defaultServiceAccountTokenPath
+	}
+	jwt, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %w", err)
+	}
+
+	return login(vaultClient, a.mountPath, map[string]interface{}{
+		"role": a.role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+}
+
+// appRoleAuthenticator logs in with an AppRole role ID and secret ID
+type appRoleAuthenticator struct {
+	roleID    string
+	secretID  string
+	mountPath string
+}
+
+func (a *appRoleAuthenticator) Login(vaultClient *vaultapi.Client) (*vaultapi.SecretAuth, error) {
+	return login(vaultClient, a.mountPath, map[string]interface{}{
+		"role_id":   a.roleID,
+		"secret_id": a.secretID,
+	})
+}
+
+// tokenAuthenticator uses a static token, which is never renewed
+type tokenAuthenticator struct {
+	token string
+}
+
+func (a *tokenAuthenticator) Login(_ *vaultapi.Client) (*vaultapi.SecretAuth, error) {
+	return &vaultapi.SecretAuth{ClientToken: a.token}, nil
+}
+
+func login(vaultClient *vaultapi.Client, mountPath string, data map[string]interface{}) (*vaultapi.SecretAuth, error) {
+	// Don't send the previous, possibly expired token along
+	vaultClient.ClearToken()
+	secret, err := vaultClient.Logical().Write("auth/"+strings.Trim(mountPath, "/")+"/login", data)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("no token returned by Vault login on %s", mountPath)
+	}
+	return secret.Auth, nil
+}
+
+// loginWithAuthenticator logs in a Vault client, returning when it should log in again,
+// once two thirds of the lease of the token elapsed
+func loginWithAuthenticator(authenticator VaultAuthenticator, vaultClient *vaultapi.Client) (time.Time, error) {
+	auth, err := authenticator.Login(vaultClient)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to log in to Vault: %w", err)
+	}
+	vaultClient.SetToken(auth.ClientToken)
+
+	if auth.LeaseDuration <= 0 {
+		return time.Time{}, nil
+	}
+	return time.Now().Add(time.Duration(auth.LeaseDuration) * time.Second * 2 / 3), nil
+}
+
+// loginToVault logs in the default Vault client with the authenticator of the controller
+func (c *Controller) loginToVault() error {
+	renewAt, err := loginWithAuthenticator(c.vaultAuthenticator, c.vaultClient)
+	if err != nil {
+		return err
+	}
+	c.vaultTokenRenewAt = renewAt
+	c.vaultAuthenticated.Store(true)
+	return nil
+}
+
+// vaultTokenExpiring tells whether the token issued by the authenticator is close to expiry
+func (c *Controller) vaultTokenExpiring() bool {
+	return c.vaultAuthenticator != nil && tokenRenewalDue(c.vaultTokenRenewAt)
+}
+
+// tokenRenewalDue tells whether the time to log in again is reached, it never is if zero
+func tokenRenewalDue(renewAt time.Time) bool {
+	return !renewAt.IsZero() && !time.Now().Before(renewAt)
+}
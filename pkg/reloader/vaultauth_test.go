@@ -0,0 +1,108 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewVaultAuthenticator(t *testing.T) {
+	t.Run("vault sdk auth method", func(t *testing.T) {
+		authenticator, err := newVaultAuthenticator(&VaultConfig{AuthMethod: "jwt"})
+		assert.NoError(t, err)
+		assert.Nil(t, authenticator)
+	})
+
+	t.Run("kubernetes", func(t *testing.T) {
+		authenticator, err := newVaultAuthenticator(&VaultConfig{AuthMethod: KubernetesAuthMethod, Role: "reloader", Path: "kubernetes"})
+		assert.NoError(t, err)
+		assert.Equal(t, &kubernetesAuthenticator{role: "reloader", mountPath: "kubernetes"}, authenticator)
+	})
+
+	t.Run("approle without role ID", func(t *testing.T) {
+		_, err := newVaultAuthenticator(&VaultConfig{AuthMethod: AppRoleAuthMethod})
+		assert.Error(t, err)
+	})
+
+	t.Run("token without token", func(t *testing.T) {
+		_, err := newVaultAuthenticator(&VaultConfig{AuthMethod: TokenAuthMethod})
+		assert.Error(t, err)
+	})
+}
+
+func TestVaultAuthenticatorLogin(t *testing.T) {
+	vault := newTestVault(t)
+
+	t.Run("kubernetes", func(t *testing.T) {
+		tokenPath := filepath.Join(t.TempDir(), "token")
+		assert.NoError(t, os.WriteFile(tokenPath, []byte("service-account-jwt\n"), 0o600))
+		authenticator := &kubernetesAuthenticator{role: "reloader", mountPath: "kubernetes", tokenPath: tokenPath}
+
+		auth, err := authenticator.Login(vault.client(t))
+		assert.NoError(t, err)
+		assert.NotEmpty(t, auth.ClientToken)
+		assert.Equal(t, map[string]interface{}{
+			"path": "auth/kubernetes/login", "role": "reloader", "jwt": "service-account-jwt",
+		}, vault.logins[len(vault.logins)-1])
+	})
+
+	t.Run("approle", func(t *testing.T) {
+		authenticator := &appRoleAuthenticator{roleID: "role", secretID: "secret", mountPath: "approle"}
+
+		auth, err := authenticator.Login(vault.client(t))
+		assert.NoError(t, err)
+		assert.NotEmpty(t, auth.ClientToken)
+		assert.Equal(t, map[string]interface{}{
+			"path": "auth/approle/login", "role_id": "role", "secret_id": "secret",
+		}, vault.logins[len(vault.logins)-1])
+	})
+
+	t.Run("token", func(t *testing.T) {
+		auth, err := (&tokenAuthenticator{token: "static"}).Login(vault.client(t))
+		assert.NoError(t, err)
+		assert.Equal(t, "static", auth.ClientToken)
+		assert.Zero(t, auth.LeaseDuration)
+	})
+}
+
+func TestVaultTokenRenewal(t *testing.T) {
+	vault := newTestVault(t)
+	vault.tokenTTL = 3600
+
+	controller := newTestController(fake.NewSimpleClientset())
+	controller.vaultClient = vault.client(t)
+	controller.vaultAuthenticator = &appRoleAuthenticator{roleID: "role", secretID: "secret", mountPath: "approle"}
+
+	assert.NoError(t, controller.loginToVault())
+	assert.Equal(t, "token-1", controller.vaultClient.Token())
+	assert.WithinDuration(t, time.Now().Add(40*time.Minute), controller.vaultTokenRenewAt, time.Minute)
+
+	// The token is still valid
+	assert.NoError(t, controller.initVaultClient())
+	assert.Len(t, vault.logins, 1)
+
+	// The token is about to expire
+	controller.vaultTokenRenewAt = time.Now().Add(-time.Second)
+	assert.NoError(t, controller.initVaultClient())
+	assert.Len(t, vault.logins, 2)
+	assert.Equal(t, "token-2", controller.vaultClient.Token())
+	assert.True(t, controller.vaultTokenRenewAt.After(time.Now()))
+}
@@ -0,0 +1,86 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// VaultClient is what the reconcile loop needs from Vault, so that comparing the
+// versions of the secrets can be tested without a Vault server
+type VaultClient interface {
+	// SecretVersion returns the current version of a KV version 2 secret mounted on mountPath
+	SecretVersion(ctx context.Context, secretPath string, mountPath string) (int, error)
+	// SecretMetadata returns the current version and the custom metadata of a KV version 2 secret mounted on mountPath
+	SecretMetadata(ctx context.Context, secretPath string, mountPath string) (int, map[string]string, error)
+	// SecretHash returns the hash of the contents of a secret, only hashing the data of KV version 2 secrets
+	SecretHash(ctx context.Context, secretPath string, kvVersion int) (string, error)
+	// ListSecrets returns the sorted paths of the secrets below a prefix of a KV mount, recursively
+	ListSecrets(ctx context.Context, prefix string, mount KVMount) ([]string, error)
+	// KVMount returns the path and the version of the KV secrets engine a secret path is
+	// mounted on, or ErrNotKVMount for other secrets engines
+	KVMount(ctx context.Context, secretPath string) (KVMount, error)
+}
+
+// sdkVaultClient is the VaultClient sending the requests with the Vault SDK
+type sdkVaultClient struct {
+	logical *vaultapi.Logical
+}
+
+// newSDKVaultClient returns the VaultClient of a Vault server, sending the requests to
+// the given Vault Enterprise namespace, or to the namespace of the client if it is empty
+func newSDKVaultClient(vaultClient *vaultapi.Client, vaultNamespace string) VaultClient {
+	return &sdkVaultClient{logical: secretReaderForNamespace(vaultClient, vaultNamespace)}
+}
+
+func (c *sdkVaultClient) SecretVersion(ctx context.Context, secretPath string, mountPath string) (int, error) {
+	return getSecretVersionFromVault(c.reader(ctx), secretPath, mountPath)
+}
+
+func (c *sdkVaultClient) SecretMetadata(ctx context.Context, secretPath string, mountPath string) (int, map[string]string, error) {
+	return getSecretMetadataFromVault(c.reader(ctx), secretPath, mountPath)
+}
+
+func (c *sdkVaultClient) SecretHash(ctx context.Context, secretPath string, kvVersion int) (string, error) {
+	return getSecretHashFromVault(c.reader(ctx), secretPath, kvVersion)
+}
+
+func (c *sdkVaultClient) ListSecrets(ctx context.Context, prefix string, mount KVMount) ([]string, error) {
+	return listSecretsFromVault(c.reader(ctx), prefix, mount)
+}
+
+func (c *sdkVaultClient) KVMount(ctx context.Context, secretPath string) (KVMount, error) {
+	return getKVMountFromVault(c.reader(ctx), secretPath)
+}
+
+func (c *sdkVaultClient) reader(ctx context.Context) vaultSecretReader {
+	return contextSecretReader{ctx: ctx, logical: c.logical}
+}
+
+// contextSecretReader is a vaultSecretReader sending its requests with a context
+type contextSecretReader struct {
+	ctx     context.Context
+	logical *vaultapi.Logical
+}
+
+func (r contextSecretReader) Read(path string) (*vaultapi.Secret, error) {
+	return r.logical.ReadWithContext(r.ctx, path)
+}
+
+func (r contextSecretReader) List(path string) (*vaultapi.Secret, error) {
+	return r.logical.ListWithContext(r.ctx, path)
+}
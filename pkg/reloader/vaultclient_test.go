@@ -0,0 +1,151 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockVaultClient is a VaultClient serving the secrets of a single KV mount from memory
+type mockVaultClient struct {
+	kvVersion int
+	versions  map[string]int
+	metadata  map[string]map[string]string
+	hashes    map[string]string
+	secrets   map[string][]string
+	err       error
+}
+
+func (m *mockVaultClient) SecretVersion(_ context.Context, secretPath string, _ string) (int, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	version, ok := m.versions[secretPath]
+	if !ok {
+		return 0, ErrSecretNotFound{secretPath: secretPath}
+	}
+	return version, nil
+}
+
+func (m *mockVaultClient) SecretMetadata(ctx context.Context, secretPath string, mountPath string) (int, map[string]string, error) {
+	version, err := m.SecretVersion(ctx, secretPath, mountPath)
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, m.metadata[secretPath], nil
+}
+
+func (m *mockVaultClient) SecretHash(_ context.Context, secretPath string, _ int) (string, error) {
+	if m.err != nil {
+		return "", m.err
+	}
+	hash, ok := m.hashes[secretPath]
+	if !ok {
+		return "", ErrSecretNotFound{secretPath: secretPath}
+	}
+	return hash, nil
+}
+
+func (m *mockVaultClient) ListSecrets(_ context.Context, prefix string, _ KVMount) ([]string, error) {
+	return m.secrets[prefix], m.err
+}
+
+func (m *mockVaultClient) KVMount(_ context.Context, secretPath string) (KVMount, error) {
+	if m.kvVersion == notKVMount {
+		return KVMount{}, ErrNotKVMount{secretPath: secretPath, mountType: "database"}
+	}
+	mount, _, _ := strings.Cut(secretPath, "/")
+	return KVMount{Path: mount, Version: m.kvVersion}, nil
+}
+
+func TestCheckSecret(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("version comparison", func(t *testing.T) {
+		controller := newTestController(nil)
+		vaultClient := &mockVaultClient{kvVersion: 2, versions: map[string]int{"secret/data/app": 1}}
+		check := func() bool {
+			changed, err := controller.checkSecret(ctx, controller.logger, vaultClient, "secret/data/app", "secret/data/app")
+			assert.NoError(t, err)
+			return changed
+		}
+
+		// The first version is only stored
+		assert.False(t, check())
+		assertVersion(t, controller.workloadSecrets, "secret/data/app", 1)
+		assert.False(t, check())
+
+		vaultClient.versions["secret/data/app"] = 2
+		assert.True(t, check())
+		assertVersion(t, controller.workloadSecrets, "secret/data/app", 2)
+		assert.False(t, check())
+	})
+
+	t.Run("hash comparison", func(t *testing.T) {
+		controller := newTestController(nil)
+		vaultClient := &mockVaultClient{kvVersion: 1, hashes: map[string]string{"kv/app": "a"}}
+		check := func() bool {
+			changed, err := controller.checkSecret(ctx, controller.logger, vaultClient, "kv/app", "kv/app")
+			assert.NoError(t, err)
+			return changed
+		}
+
+		assert.False(t, check())
+		assert.False(t, check())
+		vaultClient.hashes["kv/app"] = "b"
+		assert.True(t, check())
+		_, ok := controller.workloadSecrets.GetVersion("kv/app")
+		assert.False(t, ok)
+	})
+
+	t.Run("content hash change detection", func(t *testing.T) {
+		controller := newTestController(nil)
+		controller.reloaderConfig.ChangeDetection = ChangeDetectionContentHash
+		vaultClient := &mockVaultClient{kvVersion: 2, versions: map[string]int{"secret/data/app": 1}, hashes: map[string]string{"secret/data/app": "a"}}
+
+		changed, err := controller.checkSecret(ctx, controller.logger, vaultClient, "secret/data/app", "secret/data/app")
+		assert.NoError(t, err)
+		assert.False(t, changed)
+		hash, ok := controller.workloadSecrets.GetHash("secret/data/app")
+		assert.True(t, ok)
+		assert.Equal(t, "a", hash)
+	})
+
+	t.Run("not a KV secret", func(t *testing.T) {
+		controller := newTestController(nil)
+		vaultClient := &mockVaultClient{kvVersion: notKVMount}
+
+		changed, err := controller.checkSecret(ctx, controller.logger, vaultClient, "database/creds/readonly", "database/creds/readonly")
+		assert.NoError(t, err)
+		assert.False(t, changed)
+	})
+
+	t.Run("lookup errors", func(t *testing.T) {
+		controller := newTestController(nil)
+		controller.workloadSecrets.SetVersion("secret/data/app", 1)
+
+		_, err := controller.checkSecret(ctx, controller.logger, &mockVaultClient{kvVersion: 2}, "secret/data/app", "secret/data/app")
+		assert.Equal(t, ErrSecretNotFound{secretPath: "secret/data/app"}, err)
+
+		_, err = controller.checkSecret(ctx, controller.logger, &mockVaultClient{kvVersion: 2, err: assert.AnError}, "secret/data/app", "secret/data/app")
+		assert.Equal(t, assert.AnError, err)
+		// The stored version is kept
+		assertVersion(t, controller.workloadSecrets, "secret/data/app", 1)
+	})
+}
@@ -0,0 +1,135 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// defaultVersionCacheSize is the number of secret versions cached if no size is configured
+const defaultVersionCacheSize = 1000
+
+// versionCacheKey identifies a secret of a Vault server in a Vault namespace
+type versionCacheKey struct {
+	vaultAddr      string
+	vaultNamespace string
+	path           string
+}
+
+func newVersionCacheKey(secretPath string) versionCacheKey {
+	vaultAddr, namespacedPath := splitVaultAddrSecretPath(secretPath)
+	vaultNamespace, path := splitNamespacedSecretPath(namespacedPath)
+	return versionCacheKey{vaultAddr: vaultAddr, vaultNamespace: vaultNamespace, path: path}
+}
+
+type versionCacheEntry struct {
+	key       versionCacheKey
+	version   int
+	fetchedAt time.Time
+}
+
+// versionCache is a least recently used cache of the versions of the secrets looked up in
+// Vault, serving them until they are older than its TTL, a nil versionCache caches nothing
+type versionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries *list.List
+	items   map[versionCacheKey]*list.Element
+}
+
+// newVersionCache returns a cache of up to size versions, nil if the ttl is not positive
+func newVersionCache(ttl time.Duration, size int) *versionCache {
+	if ttl <= 0 {
+		return nil
+	}
+	if size <= 0 {
+		size = defaultVersionCacheSize
+	}
+	return &versionCache{
+		ttl:     ttl,
+		size:    size,
+		entries: list.New(),
+		items:   make(map[versionCacheKey]*list.Element),
+	}
+}
+
+// get returns the cached version of a secret path, if it was fetched within the TTL
+func (c *versionCache) get(secretPath string, now time.Time) (int, bool) {
+	if c == nil {
+		return 0, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[newVersionCacheKey(secretPath)]
+	if !ok {
+		return 0, false
+	}
+	entry := element.Value.(*versionCacheEntry)
+	if now.Sub(entry.fetchedAt) >= c.ttl {
+		c.entries.Remove(element)
+		delete(c.items, entry.key)
+		return 0, false
+	}
+	c.entries.MoveToFront(element)
+	return entry.version, true
+}
+
+// add caches the version of a secret path fetched at now, evicting the least
+// recently used version if the cache is full
+func (c *versionCache) add(secretPath string, version int, now time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := newVersionCacheKey(secretPath)
+	if element, ok := c.items[key]; ok {
+		entry := element.Value.(*versionCacheEntry)
+		entry.version = version
+		entry.fetchedAt = now
+		c.entries.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.entries.PushFront(&versionCacheEntry{key: key, version: version, fetchedAt: now})
+	if c.entries.Len() > c.size {
+		oldest := c.entries.Back()
+		c.entries.Remove(oldest)
+		delete(c.items, oldest.Value.(*versionCacheEntry).key)
+	}
+}
+
+// invalidateBefore drops the cached version of a secret path if it was fetched before
+// the given time, telling whether it was
+func (c *versionCache) invalidateBefore(secretPath string, before time.Time) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[newVersionCacheKey(secretPath)]
+	if !ok || !element.Value.(*versionCacheEntry).fetchedAt.Before(before) {
+		return false
+	}
+	c.entries.Remove(element)
+	delete(c.items, element.Value.(*versionCacheEntry).key)
+	return true
+}
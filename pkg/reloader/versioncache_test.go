@@ -0,0 +1,130 @@
+// Copyright © 2023 Cisco
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reloader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestVersionCache(t *testing.T) {
+	now := time.Now()
+
+	t.Run("hit and miss", func(t *testing.T) {
+		cache := newVersionCache(time.Minute, 10)
+		_, ok := cache.get("secret/data/app", now)
+		assert.False(t, ok)
+
+		cache.add("secret/data/app", 3, now)
+		version, ok := cache.get("secret/data/app", now.Add(30*time.Second))
+		assert.True(t, ok)
+		assert.Equal(t, 3, version)
+
+		// The same path in another Vault namespace or server is another secret
+		_, ok = cache.get(namespacedSecretPath("team-a", "secret/data/app"), now)
+		assert.False(t, ok)
+		_, ok = cache.get(vaultAddrSecretPath("https://vault-b:8200", "secret/data/app"), now)
+		assert.False(t, ok)
+
+		// and so is the same secret name in another mount
+		_, ok = cache.get("platform/data/app", now)
+		assert.False(t, ok)
+		cache.add("platform/data/app", 7, now)
+		version, _ = cache.get("secret/data/app", now)
+		assert.Equal(t, 3, version)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		cache := newVersionCache(time.Minute, 10)
+		cache.add("secret/data/app", 3, now)
+
+		_, ok := cache.get("secret/data/app", now.Add(time.Minute))
+		assert.False(t, ok)
+		assert.Empty(t, cache.items)
+	})
+
+	t.Run("least recently used eviction", func(t *testing.T) {
+		cache := newVersionCache(time.Minute, 2)
+		cache.add("secret/data/a", 1, now)
+		cache.add("secret/data/b", 1, now)
+		_, _ = cache.get("secret/data/a", now)
+		cache.add("secret/data/c", 1, now)
+
+		_, ok := cache.get("secret/data/b", now)
+		assert.False(t, ok)
+		_, ok = cache.get("secret/data/a", now)
+		assert.True(t, ok)
+		_, ok = cache.get("secret/data/c", now)
+		assert.True(t, ok)
+	})
+
+	t.Run("invalidation", func(t *testing.T) {
+		cache := newVersionCache(time.Minute, 10)
+		cache.add("secret/data/app", 3, now)
+
+		assert.False(t, cache.invalidateBefore("secret/data/app", now))
+		assert.True(t, cache.invalidateBefore("secret/data/app", now.Add(time.Second)))
+		_, ok := cache.get("secret/data/app", now)
+		assert.False(t, ok)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		cache := newVersionCache(0, 10)
+		assert.Nil(t, cache)
+		cache.add("secret/data/app", 3, now)
+		_, ok := cache.get("secret/data/app", now)
+		assert.False(t, ok)
+	})
+}
+
+func TestRunReloaderVersionCache(t *testing.T) {
+	template := newTestPodTemplate(map[string]string{SecretReloadAnnotationName: "true"}, "vault:secret/data/app#password")
+	kubeClient := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Template: template},
+	})
+	vault := newTestVault(t)
+	vault.setVersion("app", 1)
+	vault.setContents("config", map[string]interface{}{"token": "a"})
+
+	controller := newTestController(kubeClient)
+	controller.vaultClient = vault.client(t)
+	controller.vaultConfig = &VaultConfig{}
+	controller.versionCache = newVersionCache(time.Hour, 10)
+	appWorkload := workload{name: "app", namespace: "default", kind: DeploymentKind}
+	controller.workloadSecrets.Store(appWorkload, []string{"kv/config", "secret/data/app"})
+	controller.runReloader(context.Background())
+
+	// The cached version is served until a reload is triggered
+	vault.setVersion("app", 2)
+	controller.runReloader(context.Background())
+	assertVersion(t, controller.workloadSecrets, "secret/data/app", 1)
+	_, ok := controller.workloadSecrets.GetLastReload(appWorkload)
+	assert.False(t, ok)
+
+	// The KV version 1 secret is not cached, its change reloads the workload
+	// and bypasses the cached version of the other secret of the workload
+	vault.setContents("config", map[string]interface{}{"token": "b"})
+	controller.runReloader(context.Background())
+	assertVersion(t, controller.workloadSecrets, "secret/data/app", 2)
+	_, ok = controller.workloadSecrets.GetLastReload(appWorkload)
+	assert.True(t, ok)
+}